@@ -34,12 +34,14 @@ var (
 
 type app struct {
 	z     *zug.Zug
+	x     *x.TermWindow
 	c     console.Console
 	layer *zug.Layer
 	stdin *bufio.Reader
 
-	args []string
-	ix   int
+	args  []string
+	ix    int
+	shown int
 
 	escape uint8
 	csi    []byte
@@ -56,11 +58,14 @@ type app struct {
 const (
 	esc uint8 = 1 << iota
 	csi
+	osc
+	oscEsc
 )
 
-func new(z *zug.Zug, c console.Console, args []string) *app {
+func new(z *zug.Zug, tw *x.TermWindow, c console.Console, args []string) *app {
 	a := &app{
 		z:     z,
+		x:     tw,
 		c:     c,
 		layer: z.Layer("m"),
 		stdin: bufio.NewReader(os.Stdin),
@@ -70,8 +75,10 @@ func new(z *zug.Zug, c console.Console, args []string) *app {
 		quit: make(chan error),
 
 		cursorY: -1,
+		shown:   -1,
 	}
 
+	a.decodeSize()
 	a.show()
 	a.tick <- true
 
@@ -96,6 +103,14 @@ func (a *app) delta(d int) bool {
 	return false
 }
 
+func (a *app) page(d int) bool {
+	p := a.layer.Page() + d
+	if p < 0 || p >= a.layer.Pages() {
+		return false
+	}
+	return a.layer.SetPage(p) == nil
+}
+
 func (a *app) esc(n uint8) bool {
 	return a.escape&n != 0
 }
@@ -112,8 +127,22 @@ func (a *app) input() {
 	}
 
 	switch {
+	case a.esc(osc):
+		// Discard operating system commands, e.g.: the reply to the OSC 11
+		// query of termBackground arriving after it timed out. They are
+		// terminated by BEL or ST (ESC \).
+		if n == 7 || (n == 92 && a.esc(oscEsc)) {
+			a.escape = 0
+			return
+		}
+		a.escape &= ^oscEsc
+		if n == 27 {
+			a.escape |= oscEsc
+		}
 	case n == 27 && !a.esc(esc):
 		a.escape |= esc
+	case n == 93 && !a.esc(csi) && a.esc(esc):
+		a.escape |= osc
 	case n == 91 && !a.esc(csi) && a.esc(esc):
 		a.escape |= csi
 	case a.esc(esc) && !a.esc(csi) && (n < 0x40 || n > 0x5F):
@@ -147,6 +176,14 @@ func (a *app) input() {
 		if a.prev() {
 			a.tick <- true
 		}
+	case 'l':
+		if a.page(1) {
+			a.tick <- true
+		}
+	case 'h':
+		if a.page(-1) {
+			a.tick <- true
+		}
 	}
 }
 
@@ -156,6 +193,20 @@ func (a *app) show() {
 	ix := a.ix
 	err := a.layer.SetSource(a.args[ix])
 	perr(err)
+	if err == nil && ix != a.shown {
+		go func() { perr(a.layer.Wait()) }()
+	}
+	a.shown = ix
+}
+
+// decodeSize hints the size of the terminal window as the largest size
+// images are shown at, so large images are decoded reduced.
+func (a *app) decodeSize() {
+	geom, err := a.x.Geometry()
+	if err != nil {
+		return
+	}
+	a.z.SetDecodeSize(geom.Size())
 }
 
 func (a *app) termSize() (bool, image.Point) {
@@ -185,6 +236,9 @@ func (a *app) size(force bool) bool {
 	if !ch && !force {
 		return false
 	}
+	if ch {
+		a.decodeSize()
+	}
 
 	rw := term.X - 2
 	rh := term.Y
@@ -228,7 +282,7 @@ func (a *app) size(force bool) bool {
 
 	_ = a.layer.SetGeometryTerminal(dims.Rect())
 	a.layer.Show()
-	a.layer.Render()
+	_ = a.layer.Render()
 
 	if ch {
 		a.reqCursor()
@@ -293,7 +347,31 @@ func (a *app) run(redraw bool) error {
 	return a.z.RenderWithRefresh()
 }
 
+// termBackground queries the terminal background color.
+func termBackground() (x.Background, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	c, err := x.QueryBackground(tty, time.Millisecond*200)
+	if err != nil {
+		return nil, err
+	}
+	return x.SolidBackground(c), nil
+}
+
 func main() {
+	argb := flag.Bool(
+		"argb",
+		false,
+		"show the terminal behind transparent images, requires a compositor",
+	)
+	cpu := flag.Bool(
+		"cpu",
+		false,
+		"scale images on the CPU instead of using the X RENDER extension",
+	)
 	flag.Parse()
 	args := flag.Args()
 	if len(args) == 0 {
@@ -307,11 +385,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *argb && !x.UseARGB() {
+		fmt.Fprintln(os.Stderr, "no 32-bit ARGB visual available")
+	}
+	x.UseRender(!*cpu)
+
 	z := zug.New(img.DefaultManager, x)
 	term := console.Current()
-	app := new(z, term, args)
+	app := new(z, x, term, args)
 
 	_ = term.SetRaw()
+	if bg, err := termBackground(); err == nil {
+		app.layer.SetBackground(bg)
+	}
 	sig := make(chan os.Signal, 1)
 	done := func() {
 		app.Close()
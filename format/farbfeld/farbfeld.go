@@ -0,0 +1,127 @@
+// Package farbfeld implements a decoder for the farbfeld image format.
+//
+// See https://tools.suckless.org/farbfeld/
+package farbfeld
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/frizinak/zug/format"
+)
+
+// FormatError reports that the input is not a valid farbfeld image.
+type FormatError string
+
+func (e FormatError) Error() string { return "farbfeld: invalid format: " + string(e) }
+
+const magic = "farbfeld"
+
+func readHeader(r io.Reader) (w, h int, err error) {
+	var b [16]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil {
+		return
+	}
+	if string(b[:8]) != magic {
+		err = FormatError("bad magic")
+		return
+	}
+	uw, uh := binary.BigEndian.Uint32(b[8:]), binary.BigEndian.Uint32(b[12:])
+	if uw == 0 || uh == 0 || uw > 1<<30 || uh > 1<<30 {
+		err = FormatError("invalid dimensions")
+		return
+	}
+	return int(uw), int(uh), nil
+}
+
+// DecodeConfig returns the color model and dimensions of a farbfeld image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	w, h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBA64Model, Width: w, Height: h}, nil
+}
+
+// Decode reads a farbfeld image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	w, h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The farbfeld pixel layout is identical to that of image.NRGBA64.
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return img, unexpected(err)
+	}
+	return img, nil
+}
+
+// DecodeScaled reads a farbfeld image from r while box-filtering it down by
+// a factor of 1<<shift in both dimensions. The full resolution image is
+// never held in memory.
+func DecodeScaled(r io.Reader, shift uint) (image.Image, error) {
+	if shift == 0 {
+		return Decode(r)
+	}
+
+	br := bufio.NewReader(r)
+	w, h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	f := 1 << shift
+	ow, oh := (w+f-1)/f, (h+f-1)/f
+	img := image.NewNRGBA64(image.Rect(0, 0, ow, oh))
+	row := make([]byte, w*8)
+	acc := make([]uint64, ow*4)
+	cnt := make([]uint64, ow)
+
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return img, unexpected(err)
+		}
+		for x := 0; x < w; x++ {
+			o := (x >> shift) * 4
+			for i := 0; i < 4; i++ {
+				acc[o+i] += uint64(binary.BigEndian.Uint16(row[x*8+i*2:]))
+			}
+			cnt[x>>shift]++
+		}
+
+		if (y+1)%f != 0 && y != h-1 {
+			continue
+		}
+
+		o := img.PixOffset(0, y>>shift)
+		for x := 0; x < ow; x++ {
+			for i := 0; i < 4; i++ {
+				v := uint16(acc[x*4+i] / cnt[x])
+				binary.BigEndian.PutUint16(img.Pix[o+x*8+i*2:], v)
+				acc[x*4+i] = 0
+			}
+			cnt[x] = 0
+		}
+	}
+
+	return img, nil
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func init() {
+	image.RegisterFormat("farbfeld", magic, Decode, DecodeConfig)
+	format.RegisterScaled("farbfeld", DecodeScaled)
+}
@@ -0,0 +1,21 @@
+package farbfeld
+
+import (
+	"os"
+	"testing"
+
+	"github.com/frizinak/zug/internal/formattest"
+)
+
+func TestDecode(t *testing.T) {
+	f, err := os.Open("testdata/image.ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formattest.Compare(t, img, formattest.Golden(t, "image.png"))
+}
@@ -0,0 +1,118 @@
+// Package format contains helpers shared by the image decoders zug ships
+// with. Decoders for single image formats register themselves with the
+// standard image package, formats that can contain multiple pages
+// additionally register here.
+package format
+
+import (
+	"image"
+	"io"
+	"sync"
+)
+
+// Paged describes a format that can contain multiple pages.
+type Paged struct {
+	// Pages returns the number of pages in r.
+	Pages func(r io.ReaderAt) (int, error)
+	// Config returns the dimensions of the given zero-based page.
+	Config func(r io.ReaderAt, page int) (image.Config, error)
+	// Decode decodes the given zero-based page.
+	Decode func(r io.ReaderAt, page int) (image.Image, error)
+}
+
+var paged = struct {
+	sync.RWMutex
+	m map[string]Paged
+}{m: make(map[string]Paged)}
+
+// RegisterPaged registers a multi-page decoder for the format name as
+// returned by image.Decode and image.DecodeConfig.
+func RegisterPaged(name string, p Paged) {
+	paged.Lock()
+	paged.m[name] = p
+	paged.Unlock()
+}
+
+// LookupPaged returns the multi-page decoder for the given format name.
+func LookupPaged(name string) (Paged, bool) {
+	paged.RLock()
+	p, ok := paged.m[name]
+	paged.RUnlock()
+	return p, ok
+}
+
+// SizedDecoder decodes the image that best fits width x height pixels from
+// a format that holds the same image at several sizes.
+type SizedDecoder func(r io.Reader, width, height int) (image.Image, error)
+
+var sized = struct {
+	sync.RWMutex
+	m map[string]SizedDecoder
+}{m: make(map[string]SizedDecoder)}
+
+// RegisterSized registers a SizedDecoder for the format name as returned by
+// image.Decode and image.DecodeConfig.
+func RegisterSized(name string, dec SizedDecoder) {
+	sized.Lock()
+	sized.m[name] = dec
+	sized.Unlock()
+}
+
+// LookupSized returns the SizedDecoder for the given format name.
+func LookupSized(name string) (SizedDecoder, bool) {
+	sized.RLock()
+	d, ok := sized.m[name]
+	sized.RUnlock()
+	return d, ok
+}
+
+// Vector is a resolution independent image.
+type Vector interface {
+	// Size returns the intrinsic size in pixels.
+	Size() (w, h float64)
+	// Rasterize renders the image at exactly w x h pixels.
+	Rasterize(w, h int) image.Image
+}
+
+// RegionVector is implemented by Vectors that can render part of the
+// image without rasterizing all of it.
+type RegionVector interface {
+	Vector
+	// RasterizeRegion renders the part r of the image rasterized at w x h
+	// pixels, the returned image has bounds r.
+	RasterizeRegion(w, h int, r image.Rectangle) image.Image
+}
+
+// VectorFormat describes a vector image format.
+type VectorFormat struct {
+	Name string
+	// Match reports whether the given header (the first 1024 bytes or
+	// less) looks like this format.
+	Match func(header []byte) bool
+	// Decode parses a Vector from r.
+	Decode func(r io.Reader) (Vector, error)
+}
+
+var vectors = struct {
+	sync.RWMutex
+	l []VectorFormat
+}{}
+
+// RegisterVector registers a vector image format.
+func RegisterVector(f VectorFormat) {
+	vectors.Lock()
+	vectors.l = append(vectors.l, f)
+	vectors.Unlock()
+}
+
+// MatchVector returns the vector format matching the given header.
+func MatchVector(header []byte) (VectorFormat, bool) {
+	vectors.RLock()
+	defer vectors.RUnlock()
+	for _, f := range vectors.l {
+		if f.Match(header) {
+			return f, true
+		}
+	}
+	return VectorFormat{}, false
+}
@@ -0,0 +1,106 @@
+package format
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// gifFrames counts the image descriptors in a GIF. Data after a truncated
+// block is ignored as the standard decoder only reads the first frame.
+func gifFrames(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, 13)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return 0, err
+	}
+	if flags := head[10]; flags&0x80 != 0 {
+		if _, err := br.Discard(3 << (flags&7 + 1)); err != nil {
+			return 0, nil
+		}
+	}
+
+	skipBlocks := func() error {
+		for {
+			n, err := br.ReadByte()
+			if err != nil || n == 0 {
+				return err
+			}
+			if _, err := br.Discard(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var n int
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return n, nil
+		}
+		switch b {
+		case 0x21: // extension
+			if _, err := br.ReadByte(); err != nil {
+				return n, nil
+			}
+		case 0x2c: // image descriptor
+			n++
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(br, desc); err != nil {
+				return n, nil
+			}
+			if flags := desc[8]; flags&0x80 != 0 {
+				if _, err := br.Discard(3 << (flags&7 + 1)); err != nil {
+					return n, nil
+				}
+			}
+			// LZW minimum code size.
+			if _, err := br.ReadByte(); err != nil {
+				return n, nil
+			}
+		default: // trailer or garbage
+			return n, nil
+		}
+		if err := skipBlocks(); err != nil {
+			return n, nil
+		}
+	}
+}
+
+// pngFrames returns the frame count of the acTL chunk of an APNG, which
+// precedes the image data, and 1 for a plain PNG.
+func pngFrames(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(8); err != nil {
+		return 0, err
+	}
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, head); err != nil {
+			return 1, nil
+		}
+		l := binary.BigEndian.Uint32(head)
+		switch string(head[4:]) {
+		case "IDAT":
+			return 1, nil
+		case "acTL":
+			if l < 8 {
+				return 0, errors.New("png: invalid acTL chunk")
+			}
+			var n [4]byte
+			if _, err := io.ReadFull(br, n[:]); err != nil {
+				return 1, nil
+			}
+			return int(binary.BigEndian.Uint32(n[:]) & 0x7fffffff), nil
+		}
+		if _, err := br.Discard(int(l) + 4); err != nil {
+			return 1, nil
+		}
+	}
+}
+
+func init() {
+	RegisterFrames("gif", gifFrames)
+	RegisterFrames("png", pngFrames)
+}
@@ -0,0 +1,68 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func TestGIFFrames(t *testing.T) {
+	for _, global := range []bool{false, true} {
+		g := &gif.GIF{}
+		for i := 0; i < 3; i++ {
+			g.Image = append(g.Image, image.NewPaletted(image.Rect(i, 0, 4, 3), palette.Plan9))
+			g.Delay = append(g.Delay, 10)
+		}
+		if global {
+			g.Config = image.Config{ColorModel: color.Palette(palette.Plan9), Width: 4, Height: 3}
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := gifFrames(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("global palette %t: %d frames, expected 3", global, n)
+		}
+	}
+}
+
+func TestPNGFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	n, err := pngFrames(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("png: %d frames, expected 1", n)
+	}
+
+	// Insert an acTL chunk after IHDR (8 byte signature, 25 byte chunk).
+	actl := make([]byte, 20)
+	binary.BigEndian.PutUint32(actl, 8)
+	copy(actl[4:], "acTL")
+	binary.BigEndian.PutUint32(actl[8:], 5)
+	binary.BigEndian.PutUint32(actl[16:], crc32.ChecksumIEEE(actl[4:16]))
+	apng := append(append(append([]byte{}, data[:33]...), actl...), data[33:]...)
+	n, err = pngFrames(bytes.NewReader(apng))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("apng: %d frames, expected 5", n)
+	}
+}
@@ -0,0 +1,109 @@
+package icc
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// curve maps an encoded value in [0, 1] to linear light.
+type curve interface {
+	eval(x float64) float64
+}
+
+type gamma float64
+
+func (g gamma) eval(x float64) float64 { return math.Pow(x, float64(g)) }
+
+// table is a sampled curve, linearly interpolated.
+type table []float64
+
+func (t table) eval(x float64) float64 {
+	f := x * float64(len(t)-1)
+	i := int(f)
+	if i >= len(t)-1 {
+		return t[len(t)-1]
+	}
+	if i < 0 {
+		return t[0]
+	}
+	return t[i] + (t[i+1]-t[i])*(f-float64(i))
+}
+
+// parametric is a parametricCurveType with all 7 parameters, unused ones
+// set such that the function reduces to the encoded type.
+type parametric struct {
+	g, a, b, c, d, e, f float64
+}
+
+func (p parametric) eval(x float64) float64 {
+	if x >= p.d {
+		v := p.a*x + p.b
+		if v < 0 {
+			return p.e
+		}
+		return math.Pow(v, p.g) + p.e
+	}
+	return p.c*x + p.f
+}
+
+var srgbCurve = parametric{
+	g: 2.4,
+	a: 1 / 1.055,
+	b: 0.055 / 1.055,
+	c: 1 / 12.92,
+	d: 0.04045,
+}
+
+func parseCurve(b []byte) (curve, error) {
+	if b == nil {
+		return nil, ErrUnsupported
+	}
+	if len(b) < 12 {
+		return nil, FormatError("bad curve tag")
+	}
+
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > (len(b)-12)/2 {
+			return nil, FormatError("bad curve tag")
+		}
+		switch n {
+		case 0:
+			return gamma(1), nil
+		case 1:
+			return gamma(float64(binary.BigEndian.Uint16(b[12:])) / 256), nil
+		}
+		t := make(table, n)
+		for i := range t {
+			t[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 0xffff
+		}
+		return t, nil
+
+	case "para":
+		counts := [...]int{1, 3, 4, 5, 7}
+		typ := int(binary.BigEndian.Uint16(b[8:]))
+		if typ >= len(counts) || len(b) < 12+4*counts[typ] {
+			return nil, FormatError("bad parametric curve tag")
+		}
+		var v [7]float64
+		for i := 0; i < counts[typ]; i++ {
+			v[i] = s15Fixed16(b[12+4*i:])
+		}
+		p := parametric{g: v[0], a: 1}
+		switch typ {
+		case 1, 2:
+			p.a, p.b = v[1], v[2]
+			p.e, p.f = v[3], v[3]
+			if p.a != 0 {
+				p.d = -p.b / p.a
+			}
+		case 3, 4:
+			p.a, p.b, p.c, p.d = v[1], v[2], v[3], v[4]
+			p.e, p.f = v[5], v[6]
+		}
+		return p, nil
+	}
+
+	return nil, ErrUnsupported
+}
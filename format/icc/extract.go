@@ -0,0 +1,137 @@
+package icc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+
+	"github.com/frizinak/zug/format"
+)
+
+// maxProfile is the maximum size of an extracted profile, a LimitError is
+// returned for larger ones. Matrix/TRC profiles are a few KiB, LUT based
+// ones rarely exceed a MiB.
+const maxProfile = 4 << 20
+
+func profileLimit(n int64) error {
+	return &format.LimitError{Limit: "profile bytes", Value: n, Max: maxProfile}
+}
+
+// Extract returns the ICC profile embedded in the given JPEG, PNG or WebP
+// file, nil if there is none or the format is not recognized.
+func Extract(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return extractJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return extractPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return extractWebP(data)
+	}
+	return nil, nil
+}
+
+// extractJPEG concatenates the profile chunks in APP2 segments, see ICC.1
+// annex B.4.
+func extractJPEG(data []byte) ([]byte, error) {
+	const magic = "ICC_PROFILE\x00"
+	var chunks [][]byte
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil, FormatError("bad jpeg marker")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return nil, FormatError("truncated jpeg segment")
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xe2 && len(seg) > len(magic)+2 && string(seg[:len(magic)]) == magic {
+			seq, count := int(seg[len(magic)]), int(seg[len(magic)+1])
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+			if seq < 1 || seq > len(chunks) {
+				return nil, FormatError("bad jpeg icc chunk")
+			}
+			chunks[seq-1] = seg[len(magic)+2:]
+		}
+		i += 2 + n
+	}
+
+	var n int64
+	for _, c := range chunks {
+		if c == nil {
+			return nil, FormatError("missing jpeg icc chunk")
+		}
+		n += int64(len(c))
+	}
+	if n > maxProfile {
+		return nil, profileLimit(n)
+	}
+	p := make([]byte, 0, n)
+	for _, c := range chunks {
+		p = append(p, c...)
+	}
+	return p, nil
+}
+
+func extractPNG(data []byte) ([]byte, error) {
+	for i := 8; i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if n < 0 || i+12+n > len(data) {
+			return nil, FormatError("truncated png chunk")
+		}
+		switch typ {
+		case "iCCP":
+			c := data[i+8 : i+8+n]
+			nul := bytes.IndexByte(c, 0)
+			if nul < 0 || nul+2 > len(c) || c[nul+1] != 0 {
+				return nil, FormatError("bad png iCCP chunk")
+			}
+			r, err := zlib.NewReader(bytes.NewReader(c[nul+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			p, err := io.ReadAll(io.LimitReader(r, maxProfile+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(p) > maxProfile {
+				return nil, profileLimit(int64(len(p)))
+			}
+			return p, nil
+		case "IDAT", "IEND":
+			return nil, nil
+		}
+		i += 12 + n
+	}
+	return nil, nil
+}
+
+func extractWebP(data []byte) ([]byte, error) {
+	for i := 12; i+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if n < 0 || i+8+n > len(data) {
+			return nil, FormatError("truncated webp chunk")
+		}
+		if string(data[i:i+4]) == "ICCP" {
+			if n > maxProfile {
+				return nil, profileLimit(int64(n))
+			}
+			return data[i+8 : i+8+n], nil
+		}
+		i += 8 + n + n&1
+	}
+	return nil, nil
+}
@@ -0,0 +1,90 @@
+package icc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	"github.com/frizinak/zug/format"
+)
+
+func append32(b []byte, v uint32) []byte {
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], v)
+	return b
+}
+
+func pngWithProfile(profile []byte) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(profile)
+	w.Close()
+
+	b := []byte("\x89PNG\r\n\x1a\n")
+	chunk := func(typ string, data []byte) {
+		b = append32(b, uint32(len(data)))
+		start := len(b)
+		b = append(b, typ...)
+		b = append(b, data...)
+		b = append32(b, crc32.ChecksumIEEE(b[start:]))
+	}
+	chunk("iCCP", append([]byte("icc\x00\x00"), z.Bytes()...))
+	chunk("IEND", nil)
+	return b
+}
+
+func jpegWithProfile(profile []byte, chunkSize int) []byte {
+	const magic = "ICC_PROFILE\x00"
+	count := (len(profile) + chunkSize - 1) / chunkSize
+	b := []byte{0xff, 0xd8}
+	for i := 0; i < count; i++ {
+		c := profile[i*chunkSize:]
+		if len(c) > chunkSize {
+			c = c[:chunkSize]
+		}
+		b = append(b, 0xff, 0xe2)
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(2+len(magic)+2+len(c)))
+		b = append(b, magic...)
+		b = append(b, byte(i+1), byte(count))
+		b = append(b, c...)
+	}
+	return append(b, 0xff, 0xd9)
+}
+
+func TestExtract(t *testing.T) {
+	profile := make([]byte, 200000)
+	for i := range profile {
+		profile[i] = byte(i * 7)
+	}
+	for name, data := range map[string][]byte{
+		"png":  pngWithProfile(profile),
+		"jpeg": jpegWithProfile(profile, 65000),
+	} {
+		p, err := Extract(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(p, profile) {
+			t.Errorf("%s: extracted profile differs", name)
+		}
+	}
+}
+
+func TestExtractLimit(t *testing.T) {
+	big := make([]byte, maxProfile+1)
+	for name, data := range map[string][]byte{
+		"png":  pngWithProfile(big),
+		"jpeg": jpegWithProfile(big, 65500),
+	} {
+		_, err := Extract(data)
+		var l *format.LimitError
+		if !errors.Is(err, format.ErrLimit) || !errors.As(err, &l) {
+			t.Errorf("%s: got %v, expected LimitError", name, err)
+		}
+	}
+}
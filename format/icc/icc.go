@@ -0,0 +1,175 @@
+// Package icc parses matrix/TRC based RGB ICC profiles, extracts them from
+// JPEG, PNG and WebP files and converts pixels between them.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrUnsupported is returned for valid profiles that are not matrix/TRC
+// based RGB profiles, e.g.: LUT based or CMYK profiles.
+var ErrUnsupported = errors.New("icc: unsupported profile")
+
+// A FormatError reports an invalid profile.
+type FormatError string
+
+func (e FormatError) Error() string { return "icc: invalid profile: " + string(e) }
+
+type matrix [3][3]float64
+
+func (m matrix) mul(n matrix) matrix {
+	var r matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m matrix) inverse() (matrix, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return matrix{}, false
+	}
+	var r matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return r, true
+}
+
+// Profile is a matrix/TRC RGB profile.
+type Profile struct {
+	// Description as found in the desc tag, if any.
+	Description string
+
+	// toXYZ converts linear RGB to the D50 XYZ profile connection space.
+	toXYZ  matrix
+	curves [3]curve
+}
+
+// SRGB is the sRGB IEC61966-2.1 profile.
+var SRGB = &Profile{
+	Description: "sRGB IEC61966-2.1",
+	toXYZ: matrix{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	},
+	curves: [3]curve{srgbCurve, srgbCurve},
+}
+
+func init() { SRGB.curves[2] = srgbCurve }
+
+// Parse parses an ICC profile.
+func Parse(b []byte) (*Profile, error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" {
+		return nil, FormatError("bad header")
+	}
+	if size := binary.BigEndian.Uint32(b); int64(size) > int64(len(b)) {
+		return nil, FormatError("truncated")
+	}
+	if string(b[16:20]) != "RGB " || string(b[20:24]) != "XYZ " {
+		return nil, ErrUnsupported
+	}
+
+	n := binary.BigEndian.Uint32(b[128:])
+	if int64(n)*12 > int64(len(b)-132) {
+		return nil, FormatError("bad tag count")
+	}
+	tags := make(map[string][]byte, n)
+	for i := 0; i < int(n); i++ {
+		t := b[132+12*i:]
+		off, size := binary.BigEndian.Uint32(t[4:]), binary.BigEndian.Uint32(t[8:])
+		if int64(off)+int64(size) > int64(len(b)) || size < 8 {
+			return nil, FormatError("bad tag offset")
+		}
+		tags[string(t[:4])] = b[off : off+size]
+	}
+
+	p := &Profile{Description: description(tags["desc"])}
+	for i, sig := range [3]string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := parseXYZ(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for j := range xyz {
+			p.toXYZ[j][i] = xyz[j]
+		}
+	}
+	for i, sig := range [3]string{"rTRC", "gTRC", "bTRC"} {
+		c, err := parseCurve(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		p.curves[i] = c
+	}
+
+	if _, ok := p.toXYZ.inverse(); !ok {
+		return nil, FormatError("singular matrix")
+	}
+	return p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseXYZ(b []byte) ([3]float64, error) {
+	var xyz [3]float64
+	if b == nil {
+		return xyz, ErrUnsupported
+	}
+	if len(b) < 20 || string(b[:4]) != "XYZ " {
+		return xyz, FormatError("bad XYZ tag")
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(b[8+4*i:])
+	}
+	return xyz, nil
+}
+
+// description returns the text of a v2 textDescriptionType or the first
+// record of a v4 multiLocalizedUnicodeType.
+func description(b []byte) string {
+	switch {
+	case len(b) >= 12 && string(b[:4]) == "desc":
+		n := binary.BigEndian.Uint32(b[8:])
+		if n == 0 || int64(n) > int64(len(b)-12) {
+			return ""
+		}
+		return string(b[12 : 12+n-1])
+	case len(b) >= 28 && string(b[:4]) == "mluc":
+		size := binary.BigEndian.Uint32(b[20:])
+		off := binary.BigEndian.Uint32(b[24:])
+		if int64(off)+int64(size) > int64(len(b)) {
+			return ""
+		}
+		u := b[off : off+size]
+		r := make([]rune, 0, len(u)/2)
+		for i := 0; i+1 < len(u); i += 2 {
+			r = append(r, rune(binary.BigEndian.Uint16(u[i:])))
+		}
+		return string(r)
+	}
+	return ""
+}
+
+func (p *Profile) String() string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return fmt.Sprintf("icc profile %p", p)
+}
@@ -0,0 +1,142 @@
+package icc
+
+import (
+	"math"
+	"sync"
+)
+
+// outSize is the amount of entries in the table mapping linear light to
+// encoded output values.
+const outSize = 4096
+
+// Transform converts pixels from one profile to another.
+type Transform struct {
+	lin [3][256]float32
+	m   [3][3]float32
+	out *[3][outSize]uint8
+}
+
+// NewTransform returns a Transform from src to dst, nil if both are
+// (nearly) identical and no conversion is needed.
+func NewTransform(src, dst *Profile) *Transform {
+	inv, _ := dst.toXYZ.inverse()
+	m := inv.mul(src.toXYZ)
+
+	t := &Transform{out: dst.outputTable()}
+	identity := true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			t.m[i][j] = float32(m[i][j])
+			id := 0.0
+			if i == j {
+				id = 1
+			}
+			if math.Abs(m[i][j]-id) > 1e-3 {
+				identity = false
+			}
+		}
+		for v := range t.lin[i] {
+			l := src.curves[i].eval(float64(v) / 255)
+			t.lin[i][v] = float32(l)
+			if identity && t.out[i][lin2out(float32(l))] != uint8(v) {
+				identity = false
+			}
+		}
+	}
+
+	if identity {
+		return nil
+	}
+	return t
+}
+
+func lin2out(v float32) int {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return outSize - 1
+	}
+	return int(v*(outSize-1) + 0.5)
+}
+
+// outputTable lazily builds the tables encoding linear light using the
+// inverse of p's curves.
+func (p *Profile) outputTable() *[3][outSize]uint8 {
+	outputs.Lock()
+	defer outputs.Unlock()
+	if t, ok := outputs.m[p]; ok {
+		return t
+	}
+
+	t := &[3][outSize]uint8{}
+	for c, cv := range p.curves {
+		for i := range t[c] {
+			t[c][i] = uint8(math.Round(255 * invert(cv, float64(i)/(outSize-1))))
+		}
+	}
+	outputs.m[p] = t
+	return t
+}
+
+var outputs = struct {
+	sync.Mutex
+	m map[*Profile]*[3][outSize]uint8
+}{m: make(map[*Profile]*[3][outSize]uint8)}
+
+// invert finds x so that c.eval(x) = y using bisection, curves being
+// monotonically increasing.
+func invert(c curve, y float64) float64 {
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 32; i++ {
+		mid := (lo + hi) / 2
+		if c.eval(mid) < y {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// Apply converts pix in place. pix holds alpha-premultiplied pixels in B,
+// G, R, A byte order.
+func (t *Transform) Apply(pix []byte) {
+	for i := 0; i+3 < len(pix); i += 4 {
+		p := pix[i : i+4 : i+4]
+		a := p[3]
+		if a == 0 {
+			continue
+		}
+		b, g, r := p[0], p[1], p[2]
+		if a != 255 {
+			r = unpremul(r, a)
+			g = unpremul(g, a)
+			b = unpremul(b, a)
+		}
+
+		lr, lg, lb := t.lin[0][r], t.lin[1][g], t.lin[2][b]
+		r = t.out[0][lin2out(t.m[0][0]*lr+t.m[0][1]*lg+t.m[0][2]*lb)]
+		g = t.out[1][lin2out(t.m[1][0]*lr+t.m[1][1]*lg+t.m[1][2]*lb)]
+		b = t.out[2][lin2out(t.m[2][0]*lr+t.m[2][1]*lg+t.m[2][2]*lb)]
+
+		if a != 255 {
+			r = premul(r, a)
+			g = premul(g, a)
+			b = premul(b, a)
+		}
+		p[0], p[1], p[2] = b, g, r
+	}
+}
+
+func unpremul(v, a uint8) uint8 {
+	n := (uint32(v)*255 + uint32(a)/2) / uint32(a)
+	if n > 255 {
+		return 255
+	}
+	return uint8(n)
+}
+
+func premul(v, a uint8) uint8 {
+	return uint8((uint32(v)*uint32(a) + 127) / 255)
+}
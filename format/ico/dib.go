@@ -0,0 +1,155 @@
+package ico
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+)
+
+const (
+	biRGB       = 0
+	biBitfields = 3
+)
+
+type dib struct {
+	w, h        int
+	topDown     bool
+	bitCount    int
+	compression uint32
+	colors      int
+	headerSize  int
+}
+
+// dibHeader parses the BITMAPINFOHEADER of an icon image. The height
+// stored in the header covers both the color and the AND mask, the
+// returned height is that of the actual image.
+func dibHeader(b []byte) (dib, error) {
+	var d dib
+	if len(b) < 40 {
+		return d, FormatError("short DIB header")
+	}
+	d.headerSize = int(binary.LittleEndian.Uint32(b[0:]))
+	if d.headerSize < 40 || d.headerSize > len(b) {
+		return d, FormatError("invalid DIB header size")
+	}
+	w := int32(binary.LittleEndian.Uint32(b[4:]))
+	h := int32(binary.LittleEndian.Uint32(b[8:]))
+	if h < 0 {
+		d.topDown = true
+		h = -h
+	}
+	d.w, d.h = int(w), int(h)/2
+	d.bitCount = int(binary.LittleEndian.Uint16(b[14:]))
+	d.compression = binary.LittleEndian.Uint32(b[16:])
+	d.colors = int(binary.LittleEndian.Uint32(b[32:]))
+	if d.w <= 0 || d.h <= 0 || d.w > 1<<14 || d.h > 1<<14 {
+		return d, FormatError("invalid DIB dimensions")
+	}
+
+	switch d.bitCount {
+	case 1, 4, 8:
+		if d.colors == 0 || d.colors > 1<<d.bitCount {
+			d.colors = 1 << d.bitCount
+		}
+	case 24, 32:
+		d.colors = 0
+	default:
+		return d, UnsupportedError(fmt.Sprintf("%d bits per pixel", d.bitCount))
+	}
+
+	if d.compression != biRGB && !(d.compression == biBitfields && d.bitCount == 32) {
+		return d, UnsupportedError("compressed DIB")
+	}
+
+	return d, nil
+}
+
+func decodeDIB(b []byte) (image.Image, error) {
+	d, err := dibHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	off := d.headerSize
+	if d.compression == biBitfields {
+		// Assume standard BGRA masks.
+		off += 12
+	}
+
+	palette := make([][4]byte, d.colors)
+	for i := range palette {
+		if off+4 > len(b) {
+			return nil, FormatError("short palette")
+		}
+		copy(palette[i][:], b[off:off+4])
+		off += 4
+	}
+
+	stride := (d.w*d.bitCount + 31) / 32 * 4
+	maskStride := (d.w + 31) / 32 * 4
+	xor := b[off:]
+	if len(xor) < stride*d.h {
+		return nil, FormatError("short pixel data")
+	}
+	and := xor[stride*d.h:]
+	if len(and) < maskStride*d.h {
+		// Some encoders omit the mask for 32 bit images.
+		and = nil
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, d.w, d.h))
+	hasAlpha := false
+	for y := 0; y < d.h; y++ {
+		sy := d.h - 1 - y
+		if d.topDown {
+			sy = y
+		}
+		row := xor[sy*stride:]
+		o := img.PixOffset(0, y)
+		for x := 0; x < d.w; x++ {
+			var c [4]byte
+			switch d.bitCount {
+			case 1:
+				c = palette[row[x/8]>>(7-uint(x%8))&1]
+			case 4:
+				c = palette[row[x/2]>>(4*(1-uint(x%2)))&0x0f]
+			case 8:
+				c = palette[row[x]]
+			case 24:
+				copy(c[:3], row[x*3:x*3+3])
+			case 32:
+				copy(c[:], row[x*4:x*4+4])
+			}
+
+			a := byte(255)
+			if d.bitCount == 32 {
+				a = c[3]
+				hasAlpha = hasAlpha || a != 0
+			}
+			p := img.Pix[o+x*4 : o+x*4+4 : o+x*4+4]
+			p[0], p[1], p[2], p[3] = c[2], c[1], c[0], a
+		}
+	}
+
+	if d.bitCount == 32 && hasAlpha || and == nil {
+		return img, nil
+	}
+
+	for y := 0; y < d.h; y++ {
+		sy := d.h - 1 - y
+		if d.topDown {
+			sy = y
+		}
+		row := and[sy*maskStride:]
+		o := img.PixOffset(0, y)
+		for x := 0; x < d.w; x++ {
+			a := byte(255)
+			if row[x/8]>>(7-uint(x%8))&1 == 1 {
+				a = 0
+			}
+			img.Pix[o+x*4+3] = a
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,260 @@
+// Package ico implements a decoder for Windows icon (ICO) and cursor (CUR)
+// files.
+//
+// An icon file contains multiple images, Decode returns the largest one,
+// DecodeSize can be used to pick the one that best fits a given size.
+//
+// Importing this package registers ico and cur with the standard image
+// package, DecodeSize with format.RegisterSized and the amount of images
+// with format.RegisterFrames.
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/frizinak/zug/format"
+)
+
+// FormatError reports that the input is not a valid icon file.
+type FormatError string
+
+func (e FormatError) Error() string { return "ico: invalid format: " + string(e) }
+
+// UnsupportedError reports that the input uses a valid but unimplemented
+// feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "ico: unsupported feature: " + string(e) }
+
+const (
+	typeIcon   = 1
+	typeCursor = 2
+
+	pngMagic = "\x89PNG\r\n\x1a\n"
+)
+
+// Entry describes a single image in an icon file.
+type Entry struct {
+	Width, Height int
+	BitCount      int
+	// Hotspot is only set for cursors.
+	Hotspot image.Point
+
+	size   uint32
+	offset uint32
+}
+
+type file struct {
+	r       io.ReaderAt
+	typ     uint16
+	entries []Entry
+}
+
+func newReaderAt(r io.Reader) (io.ReaderAt, error) {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra, nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+func parse(r io.ReaderAt) (*file, error) {
+	var hdr [6]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(hdr[0:]) != 0 {
+		return nil, FormatError("bad magic")
+	}
+	f := &file{r: r, typ: binary.LittleEndian.Uint16(hdr[2:])}
+	if f.typ != typeIcon && f.typ != typeCursor {
+		return nil, FormatError("bad type")
+	}
+	n := int(binary.LittleEndian.Uint16(hdr[4:]))
+	if n == 0 {
+		return nil, FormatError("no images")
+	}
+
+	dir := make([]byte, 16*n)
+	if _, err := r.ReadAt(dir, 6); err != nil {
+		return nil, err
+	}
+
+	f.entries = make([]Entry, n)
+	for i := range f.entries {
+		d := dir[i*16 : i*16+16]
+		e := &f.entries[i]
+		// 0 means 256.
+		e.Width, e.Height = int(d[0]), int(d[1])
+		if e.Width == 0 {
+			e.Width = 256
+		}
+		if e.Height == 0 {
+			e.Height = 256
+		}
+		p1, p2 := binary.LittleEndian.Uint16(d[4:]), binary.LittleEndian.Uint16(d[6:])
+		if f.typ == typeCursor {
+			e.Hotspot = image.Pt(int(p1), int(p2))
+		} else {
+			e.BitCount = int(p2)
+		}
+		e.size = binary.LittleEndian.Uint32(d[8:])
+		e.offset = binary.LittleEndian.Uint32(d[12:])
+	}
+
+	return f, nil
+}
+
+// largest returns the index of the largest entry, the highest bit count
+// wins ties.
+func (f *file) largest() int {
+	best := 0
+	for i, e := range f.entries {
+		b := f.entries[best]
+		a, ba := e.Width*e.Height, b.Width*b.Height
+		if a > ba || (a == ba && e.BitCount > b.BitCount) {
+			best = i
+		}
+	}
+	return best
+}
+
+// fitting returns the index of the smallest entry that is at least w x h,
+// or the largest one if none are.
+func (f *file) fitting(w, h int) int {
+	best := -1
+	for i, e := range f.entries {
+		if e.Width < w || e.Height < h {
+			continue
+		}
+		if best == -1 {
+			best = i
+			continue
+		}
+		b := f.entries[best]
+		a, ba := e.Width*e.Height, b.Width*b.Height
+		if a < ba || (a == ba && e.BitCount > b.BitCount) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return f.largest()
+	}
+	return best
+}
+
+// data reads the image of entry ix. The size in the directory is only
+// trusted as far as the file actually holds that many bytes.
+func (f *file) data(ix int) ([]byte, error) {
+	e := f.entries[ix]
+	b, err := io.ReadAll(io.NewSectionReader(f.r, int64(e.offset), int64(e.size)))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != int(e.size) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+func (f *file) decodeConfig(ix int) (image.Config, error) {
+	b, err := f.data(ix)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if bytes.HasPrefix(b, []byte(pngMagic)) {
+		return png.DecodeConfig(bytes.NewReader(b))
+	}
+	h, err := dibHeader(b)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: h.w, Height: h.h}, nil
+}
+
+func (f *file) decode(ix int) (image.Image, error) {
+	b, err := f.data(ix)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, []byte(pngMagic)) {
+		return png.Decode(bytes.NewReader(b))
+	}
+	return decodeDIB(b)
+}
+
+// Entries lists all images contained in the icon file.
+func Entries(r io.Reader) ([]Entry, error) {
+	ra, err := newReaderAt(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(ra)
+	if err != nil {
+		return nil, err
+	}
+	return f.entries, nil
+}
+
+// DecodeConfig returns the color model and dimensions of the largest image
+// in the icon file.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	ra, err := newReaderAt(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	f, err := parse(ra)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return f.decodeConfig(f.largest())
+}
+
+// Decode decodes the largest image in the icon file.
+func Decode(r io.Reader) (image.Image, error) {
+	ra, err := newReaderAt(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(ra)
+	if err != nil {
+		return nil, err
+	}
+	return f.decode(f.largest())
+}
+
+// DecodeSize decodes the smallest image that is at least width x height
+// pixels, falling back to the largest image.
+func DecodeSize(r io.Reader, width, height int) (image.Image, error) {
+	ra, err := newReaderAt(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(ra)
+	if err != nil {
+		return nil, err
+	}
+	return f.decode(f.fitting(width, height))
+}
+
+func frames(r io.Reader) (int, error) {
+	e, err := Entries(r)
+	return len(e), err
+}
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", Decode, DecodeConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", Decode, DecodeConfig)
+	format.RegisterSized("ico", DecodeSize)
+	format.RegisterSized("cur", DecodeSize)
+	format.RegisterFrames("ico", frames)
+	format.RegisterFrames("cur", frames)
+}
@@ -0,0 +1,81 @@
+package ico
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/frizinak/zug/internal/formattest"
+)
+
+// testdata/icon.ico holds a 16x16 32-bit DIB with alpha, a 32x32 4-bit and
+// a 32x32 8-bit DIB with an AND mask, and a 48x48 PNG.
+func TestDecode(t *testing.T) {
+	data, err := os.ReadFile("testdata/icon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Entries(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%dx%d@%d", e.Width, e.Height, e.BitCount))
+	}
+	if s := fmt.Sprint(got); s != "[16x16@32 32x32@4 32x32@8 48x48@32]" {
+		t.Fatalf("entries %s", s)
+	}
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	formattest.Compare(t, img, formattest.Golden(t, "icon48.png"))
+
+	for _, c := range []struct {
+		w, h   int
+		golden string
+	}{
+		{1, 1, "icon16.png"},
+		{16, 16, "icon16.png"},
+		{17, 16, "icon32.png"},
+		{20, 20, "icon32.png"},
+		{48, 48, "icon48.png"},
+		{100, 100, "icon48.png"},
+	} {
+		img, err := DecodeSize(bytes.NewReader(data), c.w, c.h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(fmt.Sprintf("%dx%d", c.w, c.h), func(t *testing.T) {
+			formattest.Compare(t, img, formattest.Golden(t, c.golden))
+		})
+	}
+}
+
+// TestEntrySize checks a bogus entry size does not allocate it.
+func TestEntrySize(t *testing.T) {
+	data := []byte{
+		0, 0, 1, 0, 1, 0, // header, 1 image
+		16, 16, 0, 0, 1, 0, 32, 0,
+		0, 0, 0, 0x10, // size 256 MiB
+		22, 0, 0, 0, // offset
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := Decode(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+		t.Errorf("error %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := DecodeConfig(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+		t.Errorf("error %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
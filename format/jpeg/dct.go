@@ -0,0 +1,74 @@
+package jpeg
+
+import "math"
+
+const blockSize = 64 // A DCT block is 8x8.
+
+type block [blockSize]int32
+
+// idctTables holds, for each supported output size n, the basis functions
+// c(u) * cos((2x+1)uπ / 2n) for x, u < n, where c(0) = 1/√2 and c(u) = 1.
+var idctTables [9][]float32
+
+func init() {
+	for n := 1; n <= 8; n *= 2 {
+		t := make([]float32, n*n)
+		for x := 0; x < n; x++ {
+			for u := 0; u < n; u++ {
+				c := 1.0
+				if u == 0 {
+					c = 1 / math.Sqrt2
+				}
+				t[x*n+u] = float32(c * math.Cos(float64((2*x+1)*u)*math.Pi/float64(2*n)))
+			}
+		}
+		idctTables[n] = t
+	}
+}
+
+// idct performs an n-point inverse DCT on the top-left n×n coefficients of
+// b, n being 8, 4, 2 or 1. The resulting n×n samples are written to the
+// top-left of b and approximate the 8×8 block reduced by a factor of 8/n.
+func idct(b *block, n int) {
+	if n == 1 {
+		// Only the DC coefficient remains.
+		b[0] = (b[0] + 4) >> 3
+		return
+	}
+
+	t := idctTables[n]
+	var tmp [blockSize]float32
+	// Rows.
+	for v := 0; v < n; v++ {
+		row := b[8*v : 8*v+n]
+		for x := 0; x < n; x++ {
+			var sum float32
+			tx := t[x*n : x*n+n]
+			for u, c := range row {
+				sum += float32(c) * tx[u]
+			}
+			tmp[8*v+x] = sum
+		}
+	}
+	// Columns.
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			var sum float32
+			ty := t[y*n : y*n+n]
+			for v := 0; v < n; v++ {
+				sum += tmp[8*v+x] * ty[v]
+			}
+			b[8*y+x] = int32(math.Round(float64(sum / 4)))
+		}
+	}
+}
+
+func clamp(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,124 @@
+package jpeg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+// encode returns a w x h JPEG with smooth gradients and some noise, in
+// color (4:2:0) or grayscale.
+func encode(t *testing.T, w, h int, gray bool) []byte {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(int64(w*h) + 1))
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		for i := range g.Pix {
+			g.Pix[i] = uint8(i*7 + rnd.Intn(16))
+		}
+		img = g
+	} else {
+		c := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c.SetNRGBA(x, y, color.NRGBA{
+					uint8(x * 255 / w),
+					uint8(y * 255 / h),
+					uint8(rnd.Intn(256)),
+					255,
+				})
+			}
+		}
+		img = c
+	}
+	var buf bytes.Buffer
+	if err := stdjpeg.Encode(&buf, img, &stdjpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDecode compares full size output with image/jpeg. The inverse DCT
+// is computed in floating point rather than the integer approximation of
+// the standard library, which rounds differently.
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		w, h int
+		gray bool
+	}{
+		{64, 48, false},
+		{37, 23, false},
+		{1, 1, false},
+		{45, 31, true},
+	} {
+		t.Run(fmt.Sprintf("%dx%d/gray=%t", c.w, c.h, c.gray), func(t *testing.T) {
+			data := encode(t, c.w, c.h, c.gray)
+			exp, err := stdjpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != exp.Bounds() {
+				t.Fatalf("bounds %v, expected %v", got.Bounds(), exp.Bounds())
+			}
+			var sum, max int
+			b := exp.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+					e := color.RGBAModel.Convert(exp.At(x, y)).(color.RGBA)
+					for _, d := range []int{
+						abs(int(g.R) - int(e.R)),
+						abs(int(g.G) - int(e.G)),
+						abs(int(g.B) - int(e.B)),
+					} {
+						sum += d
+						if d > max {
+							max = d
+						}
+					}
+				}
+			}
+			mean := float64(sum) / float64(3*b.Dx()*b.Dy())
+			if max > 4 || mean > 0.1 {
+				t.Errorf("max difference %d, mean %.3f", max, mean)
+			}
+		})
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestDecodeScaled(t *testing.T) {
+	for _, size := range []image.Point{{64, 48}, {37, 23}, {9, 1}, {1, 1}} {
+		for _, gray := range []bool{false, true} {
+			data := encode(t, size.X, size.Y, gray)
+			for shift := uint(1); shift <= 4; shift++ {
+				img, err := DecodeScaled(bytes.NewReader(data), shift)
+				if err != nil {
+					t.Fatal(err)
+				}
+				exp := image.Pt(
+					(size.X+1<<shift-1)>>shift,
+					(size.Y+1<<shift-1)>>shift,
+				)
+				if got := img.Bounds().Size(); got != exp {
+					t.Errorf("%v gray=%t 1/%d: size %v, expected %v", size, gray, 1<<shift, got, exp)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package jpeg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// reduce box filters img by a factor of 1<<shift.
+func reduce(img image.Image, shift uint) image.Image {
+	b := img.Bounds()
+	f := 1 << shift
+	w, h := (b.Dx()+f-1)>>shift, (b.Dy()+f-1)>>shift
+
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a, n uint32
+			for sy := y << shift; sy < (y+1)<<shift && sy < b.Dy(); sy++ {
+				o := src.PixOffset(b.Min.X+x<<shift, b.Min.Y+sy)
+				for sx := x << shift; sx < (x+1)<<shift && sx < b.Dx(); sx++ {
+					r += uint32(src.Pix[o])
+					g += uint32(src.Pix[o+1])
+					bl += uint32(src.Pix[o+2])
+					a += uint32(src.Pix[o+3])
+					o += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n),
+			})
+		}
+	}
+
+	return dst
+}
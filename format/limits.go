@@ -0,0 +1,143 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// ErrLimit is matched (errors.Is) by all LimitErrors.
+var ErrLimit = errors.New("limit exceeded")
+
+// LimitError reports that an image exceeds one of the configured Limits.
+type LimitError struct {
+	// Limit is the name of the exceeded limit: pixels, bytes, frames or
+	// profile bytes.
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s %d > %d", ErrLimit, e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool { return target == ErrLimit }
+
+// Limits restricts the resources decoding an image may use.
+// A zero value means no limit.
+type Limits struct {
+	// MaxPixels is the maximum width * height of a decoded image.
+	MaxPixels int64
+	// MaxBytes is the maximum size of the encoded input.
+	MaxBytes int64
+	// MaxFrames is the maximum amount of pages, animation frames or, for
+	// icons, images. It is checked for formats registered with
+	// RegisterPaged or RegisterFrames, e.g.: TIFF, GIF, APNG and ICO.
+	MaxFrames int
+
+	// Downsample decodes a reduced version of images exceeding MaxPixels
+	// instead of failing, if the format supports it (see RegisterScaled).
+	// PNG, GIF, WebP, BMP and TIFF can not be decoded reduced, images in
+	// these formats exceeding MaxPixels fail regardless.
+	Downsample bool
+}
+
+// CheckPixels returns a LimitError if the given dimensions exceed
+// MaxPixels.
+func (l Limits) CheckPixels(w, h int) error {
+	if l.MaxPixels <= 0 {
+		return nil
+	}
+	if px := int64(w) * int64(h); px > l.MaxPixels {
+		return &LimitError{Limit: "pixels", Value: px, Max: l.MaxPixels}
+	}
+	return nil
+}
+
+// CheckFrames returns a LimitError if n exceeds MaxFrames.
+func (l Limits) CheckFrames(n int) error {
+	if l.MaxFrames > 0 && n > l.MaxFrames {
+		return &LimitError{Limit: "frames", Value: int64(n), Max: int64(l.MaxFrames)}
+	}
+	return nil
+}
+
+// Shift returns the smallest shift so that an image of the given size
+// reduced by a factor of 1<<shift does not exceed MaxPixels.
+func (l Limits) Shift(w, h int) uint {
+	var s uint
+	for s < 30 && l.CheckPixels((w+(1<<s)-1)>>s, (h+(1<<s)-1)>>s) != nil {
+		s++
+	}
+	return s
+}
+
+// ReadAll reads r until EOF or until more than MaxBytes were read, in
+// which case a LimitError is returned.
+func (l Limits) ReadAll(r io.Reader) ([]byte, error) {
+	if l.MaxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, l.MaxBytes+1))
+	if err != nil {
+		return b, err
+	}
+	if int64(len(b)) > l.MaxBytes {
+		return nil, &LimitError{Limit: "bytes", Value: int64(len(b)), Max: l.MaxBytes}
+	}
+	return b, nil
+}
+
+// ScaledDecoder decodes an image reduced by a factor of 1<<shift in both
+// dimensions without holding the full resolution image in memory.
+type ScaledDecoder func(r io.Reader, shift uint) (image.Image, error)
+
+var scaled = struct {
+	sync.RWMutex
+	m map[string]ScaledDecoder
+}{m: make(map[string]ScaledDecoder)}
+
+// RegisterScaled registers a ScaledDecoder for the format name as returned
+// by image.Decode and image.DecodeConfig.
+func RegisterScaled(name string, dec ScaledDecoder) {
+	scaled.Lock()
+	scaled.m[name] = dec
+	scaled.Unlock()
+}
+
+// LookupScaled returns the ScaledDecoder for the given format name.
+func LookupScaled(name string) (ScaledDecoder, bool) {
+	scaled.RLock()
+	d, ok := scaled.m[name]
+	scaled.RUnlock()
+	return d, ok
+}
+
+// FrameCounter returns the amount of frames, pages or images in r without
+// decoding them.
+type FrameCounter func(r io.Reader) (int, error)
+
+var frames = struct {
+	sync.RWMutex
+	m map[string]FrameCounter
+}{m: make(map[string]FrameCounter)}
+
+// RegisterFrames registers a FrameCounter for the format name as returned
+// by image.Decode and image.DecodeConfig. Limits.MaxFrames is checked
+// against it before decoding.
+func RegisterFrames(name string, count FrameCounter) {
+	frames.Lock()
+	frames.m[name] = count
+	frames.Unlock()
+}
+
+// LookupFrames returns the FrameCounter for the given format name.
+func LookupFrames(name string) (FrameCounter, bool) {
+	frames.RLock()
+	c, ok := frames.m[name]
+	frames.RUnlock()
+	return c, ok
+}
@@ -0,0 +1,448 @@
+// Package pnm implements a decoder for the netpbm family of formats:
+// PBM, PGM and PPM (both plain and raw) and PAM.
+package pnm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/frizinak/zug/format"
+)
+
+// FormatError reports that the input is not a valid netpbm file.
+type FormatError string
+
+func (e FormatError) Error() string { return "pnm: invalid format: " + string(e) }
+
+// UnsupportedError reports that the input uses a valid but unimplemented
+// feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "pnm: unsupported feature: " + string(e) }
+
+type header struct {
+	magic    byte
+	w, h     int
+	maxval   int
+	depth    int
+	tupltype string
+}
+
+func (h header) alpha() bool {
+	return strings.HasSuffix(h.tupltype, "_ALPHA")
+}
+
+func (h header) colorModel() color.Model {
+	wide := h.maxval > 0xff
+	switch {
+	case h.depth == 1 && !h.alpha() && wide:
+		return color.Gray16Model
+	case h.depth == 1 && !h.alpha():
+		return color.GrayModel
+	case wide:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+type reader struct {
+	*bufio.Reader
+}
+
+func (r reader) skipSpace() error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			continue
+		case '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return err
+			}
+			continue
+		}
+		return r.UnreadByte()
+	}
+}
+
+func (r reader) token() (string, error) {
+	if err := r.skipSpace(); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF && b.Len() != 0 {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			return b.String(), nil
+		case '#':
+			if err := r.UnreadByte(); err != nil {
+				return "", err
+			}
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+}
+
+func (r reader) uint(what string) (int, error) {
+	t, err := r.token()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t)
+	if err != nil || n < 0 {
+		return 0, FormatError(fmt.Sprintf("invalid %s '%s'", what, t))
+	}
+	return n, nil
+}
+
+func (r reader) header() (h header, err error) {
+	var magic [2]byte
+	if _, err = io.ReadFull(r, magic[:]); err != nil {
+		return
+	}
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '7' {
+		err = FormatError("bad magic")
+		return
+	}
+
+	h.magic = magic[1]
+	if h.magic == '7' {
+		return r.pamHeader(h)
+	}
+
+	if h.w, err = r.uint("width"); err != nil {
+		return
+	}
+	if h.h, err = r.uint("height"); err != nil {
+		return
+	}
+
+	h.maxval = 1
+	h.depth = 1
+	switch h.magic {
+	case '2', '5':
+		h.maxval, err = r.uint("maxval")
+	case '3', '6':
+		h.depth = 3
+		h.maxval, err = r.uint("maxval")
+	}
+	if err != nil {
+		return
+	}
+
+	if h.maxval < 1 || h.maxval > 0xffff {
+		err = FormatError("maxval out of range")
+		return
+	}
+
+	// The single whitespace character separating the header from the
+	// raster was consumed by token.
+	return
+}
+
+func (r reader) pamHeader(h header) (header, error) {
+	for {
+		t, err := r.token()
+		if err != nil {
+			return h, err
+		}
+
+		if t == "ENDHDR" {
+			break
+		}
+
+		switch t {
+		case "WIDTH":
+			h.w, err = r.uint("width")
+		case "HEIGHT":
+			h.h, err = r.uint("height")
+		case "DEPTH":
+			h.depth, err = r.uint("depth")
+		case "MAXVAL":
+			h.maxval, err = r.uint("maxval")
+		case "TUPLTYPE":
+			h.tupltype, err = r.token()
+		default:
+			return h, FormatError(fmt.Sprintf("unknown header field '%s'", t))
+		}
+		if err != nil {
+			return h, err
+		}
+	}
+
+	if h.maxval < 1 || h.maxval > 0xffff {
+		return h, FormatError("maxval out of range")
+	}
+
+	if h.tupltype == "" {
+		switch h.depth {
+		case 1:
+			h.tupltype = "GRAYSCALE"
+		case 2:
+			h.tupltype = "GRAYSCALE_ALPHA"
+		case 3:
+			h.tupltype = "RGB"
+		case 4:
+			h.tupltype = "RGB_ALPHA"
+		}
+	}
+
+	switch h.tupltype {
+	case "BLACKANDWHITE", "GRAYSCALE":
+		if h.depth != 1 {
+			return h, FormatError("depth does not match tupltype")
+		}
+	case "BLACKANDWHITE_ALPHA", "GRAYSCALE_ALPHA":
+		if h.depth != 2 {
+			return h, FormatError("depth does not match tupltype")
+		}
+	case "RGB":
+		if h.depth != 3 {
+			return h, FormatError("depth does not match tupltype")
+		}
+	case "RGB_ALPHA":
+		if h.depth != 4 {
+			return h, FormatError("depth does not match tupltype")
+		}
+	default:
+		return h, UnsupportedError(fmt.Sprintf("tupltype '%s'", h.tupltype))
+	}
+
+	return h, nil
+}
+
+// sampler reads samples from the raster and scales them to 16 bits.
+type sampler struct {
+	r      reader
+	h      header
+	bits   byte
+	nbits  uint
+	buf    []byte
+	binary bool
+}
+
+func (s *sampler) next() (uint16, error) {
+	switch {
+	case s.h.magic == '4':
+		if s.nbits == 0 {
+			b, err := s.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			s.bits, s.nbits = b, 8
+		}
+		s.nbits--
+		// In PBM 1 is black.
+		if s.bits>>s.nbits&1 == 1 {
+			return 0, nil
+		}
+		return 0xffff, nil
+
+	case s.h.magic == '1':
+		if err := s.r.skipSpace(); err != nil {
+			return 0, err
+		}
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case '0':
+			return 0xffff, nil
+		case '1':
+			return 0, nil
+		}
+		return 0, FormatError("invalid bit")
+
+	case !s.binary:
+		v, err := s.r.uint("sample")
+		if err != nil {
+			return 0, err
+		}
+		return s.scale(v), nil
+	}
+
+	n := 1
+	if s.h.maxval > 0xff {
+		n = 2
+	}
+	if _, err := io.ReadFull(s.r, s.buf[:n]); err != nil {
+		return 0, err
+	}
+	v := int(s.buf[0])
+	if n == 2 {
+		v = v<<8 | int(s.buf[1])
+	}
+	return s.scale(v), nil
+}
+
+func (s *sampler) scale(v int) uint16 {
+	if v > s.h.maxval {
+		v = s.h.maxval
+	}
+	return uint16((v*0xffff + s.h.maxval/2) / s.h.maxval)
+}
+
+// endRow discards padding bits at the end of a raw PBM row.
+func (s *sampler) endRow() { s.nbits = 0 }
+
+func newReader(r io.Reader) reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return reader{br}
+	}
+	return reader{bufio.NewReader(r)}
+}
+
+// DecodeConfig returns the color model and dimensions of a netpbm image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := newReader(r).header()
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.w, Height: h.h}, nil
+}
+
+// Decode reads a netpbm image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeScaled(r, 0)
+}
+
+// DecodeScaled reads a netpbm image from r while box-filtering it down by
+// a factor of 1<<shift in both dimensions. The full resolution image is
+// never held in memory.
+func DecodeScaled(r io.Reader, shift uint) (image.Image, error) {
+	br := newReader(r)
+	h, err := br.header()
+	if err != nil {
+		return nil, err
+	}
+
+	if h.w == 0 || h.h == 0 {
+		return nil, FormatError("empty image")
+	}
+
+	s := &sampler{
+		r:      br,
+		h:      h,
+		buf:    make([]byte, 2),
+		binary: h.magic >= '4',
+	}
+
+	f := 1 << shift
+	ow, oh := (h.w+f-1)/f, (h.h+f-1)/f
+	rect := image.Rect(0, 0, ow, oh)
+	depth := h.depth
+	gray := depth <= 2
+	alpha := depth == 2 || depth == 4
+
+	acc := make([]uint64, ow*4)
+	cnt := make([]uint64, ow)
+	px := make([]uint16, 4)
+
+	var out image.Image
+	var set func(x, y int, c [4]uint16)
+	wide := h.maxval > 0xff
+	switch {
+	case gray && !alpha && wide:
+		img := image.NewGray16(rect)
+		out = img
+		set = func(x, y int, c [4]uint16) { img.SetGray16(x, y, color.Gray16{c[0]}) }
+	case gray && !alpha:
+		img := image.NewGray(rect)
+		out = img
+		set = func(x, y int, c [4]uint16) { img.SetGray(x, y, color.Gray{uint8(c[0] >> 8)}) }
+	case wide:
+		img := image.NewNRGBA64(rect)
+		out = img
+		set = func(x, y int, c [4]uint16) {
+			img.SetNRGBA64(x, y, color.NRGBA64{c[0], c[1], c[2], c[3]})
+		}
+	default:
+		img := image.NewNRGBA(rect)
+		out = img
+		set = func(x, y int, c [4]uint16) {
+			img.SetNRGBA(x, y, color.NRGBA{
+				uint8(c[0] >> 8), uint8(c[1] >> 8), uint8(c[2] >> 8), uint8(c[3] >> 8),
+			})
+		}
+	}
+
+	flush := func(oy int) {
+		var c [4]uint16
+		for x := 0; x < ow; x++ {
+			n := cnt[x]
+			if n == 0 {
+				continue
+			}
+			for i := 0; i < 4; i++ {
+				c[i] = uint16(acc[x*4+i] / n)
+				acc[x*4+i] = 0
+			}
+			cnt[x] = 0
+			set(x, oy, c)
+		}
+	}
+
+	for y := 0; y < h.h; y++ {
+		for x := 0; x < h.w; x++ {
+			for i := 0; i < depth; i++ {
+				v, err := s.next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						err = io.ErrUnexpectedEOF
+					}
+					return out, err
+				}
+				px[i] = v
+			}
+
+			switch depth {
+			case 1:
+				px[1], px[2], px[3] = px[0], px[0], 0xffff
+			case 2:
+				px[3] = px[1]
+				px[1], px[2] = px[0], px[0]
+			case 3:
+				px[3] = 0xffff
+			}
+
+			o := (x >> shift) * 4
+			for i := 0; i < 4; i++ {
+				acc[o+i] += uint64(px[i])
+			}
+			cnt[x>>shift]++
+		}
+		s.endRow()
+
+		if (y+1)%f == 0 || y == h.h-1 {
+			flush(y >> shift)
+		}
+	}
+
+	return out, nil
+}
+
+func init() {
+	for i := '1'; i <= '7'; i++ {
+		image.RegisterFormat("pnm", "P"+string(i), Decode, DecodeConfig)
+	}
+	format.RegisterScaled("pnm", DecodeScaled)
+}
@@ -0,0 +1,35 @@
+package pnm
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/frizinak/zug/internal/formattest"
+)
+
+func TestDecode(t *testing.T) {
+	for _, name := range []string{
+		"p1.pbm", // plain, with a comment
+		"p2.pgm", // plain, maxval 15
+		"p3.ppm", // plain, maxval 1000
+		"p4.pbm", // rows padded to a byte
+		"p5.pgm",
+		"p6.ppm", // 16-bit
+		"p7.pam", // RGB_ALPHA
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formattest.Compare(t, img, formattest.Golden(t, strings.TrimSuffix(name, path.Ext(name))+".png"))
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package qoi implements a decoder for the Quite OK Image format.
+//
+// See https://qoiformat.org/qoi-specification.pdf
+package qoi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/frizinak/zug/format"
+)
+
+// FormatError reports that the input is not a valid QOI image.
+type FormatError string
+
+func (e FormatError) Error() string { return "qoi: invalid format: " + string(e) }
+
+const (
+	magic = "qoif"
+
+	opIndex = 0x00
+	opDiff  = 0x40
+	opLuma  = 0x80
+	opRun   = 0xc0
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	opMask  = 0xc0
+
+	// Guards against absurd headers, as per the reference implementation.
+	maxPixels = 400000000
+)
+
+type header struct {
+	w, h     uint32
+	channels byte
+	space    byte
+}
+
+func readHeader(r io.Reader) (h header, err error) {
+	var b [14]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil {
+		return
+	}
+	if string(b[:4]) != magic {
+		err = FormatError("bad magic")
+		return
+	}
+	h.w = binary.BigEndian.Uint32(b[4:])
+	h.h = binary.BigEndian.Uint32(b[8:])
+	h.channels = b[12]
+	h.space = b[13]
+	if h.channels != 3 && h.channels != 4 {
+		err = FormatError("invalid channel count")
+		return
+	}
+	if h.w == 0 || h.h == 0 || uint64(h.w)*uint64(h.h) > maxPixels {
+		err = FormatError("invalid dimensions")
+	}
+	return
+}
+
+// DecodeConfig returns the color model and dimensions of a QOI image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(h.w),
+		Height:     int(h.h),
+	}, nil
+}
+
+// Decode reads a QOI image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeScaled(r, 0)
+}
+
+// DecodeScaled reads a QOI image from r while box-filtering it down by a
+// factor of 1<<shift in both dimensions. The full resolution image is
+// never held in memory.
+func DecodeScaled(r io.Reader, shift uint) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	w, ht := int(h.w), int(h.h)
+	f := 1 << shift
+	ow, oh := (w+f-1)/f, (ht+f-1)/f
+	img := image.NewNRGBA(image.Rect(0, 0, ow, oh))
+
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+
+	acc := make([]uint32, ow*4)
+	cnt := make([]uint32, ow)
+
+	flush := func(oy int) {
+		o := img.PixOffset(0, oy)
+		for x := 0; x < ow; x++ {
+			n := cnt[x]
+			if n == 0 {
+				continue
+			}
+			for i := 0; i < 4; i++ {
+				img.Pix[o+x*4+i] = uint8(acc[x*4+i] / n)
+				acc[x*4+i] = 0
+			}
+			cnt[x] = 0
+		}
+	}
+
+	for y := 0; y < ht; y++ {
+		for x := 0; x < w; x++ {
+			if run > 0 {
+				run--
+			} else {
+				b, err := br.ReadByte()
+				if err != nil {
+					return img, unexpected(err)
+				}
+
+				switch {
+				case b == opRGB:
+					if _, err := io.ReadFull(br, px[:3]); err != nil {
+						return img, unexpected(err)
+					}
+				case b == opRGBA:
+					if _, err := io.ReadFull(br, px[:]); err != nil {
+						return img, unexpected(err)
+					}
+				case b&opMask == opIndex:
+					px = index[b]
+				case b&opMask == opDiff:
+					px[0] += (b>>4)&3 - 2
+					px[1] += (b>>2)&3 - 2
+					px[2] += b&3 - 2
+				case b&opMask == opLuma:
+					b2, err := br.ReadByte()
+					if err != nil {
+						return img, unexpected(err)
+					}
+					dg := b&0x3f - 32
+					px[0] += dg - 8 + (b2>>4)&0x0f
+					px[1] += dg
+					px[2] += dg - 8 + b2&0x0f
+				case b&opMask == opRun:
+					run = int(b & 0x3f)
+				}
+
+				index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+			}
+
+			o := (x >> shift) * 4
+			acc[o+0] += uint32(px[0])
+			acc[o+1] += uint32(px[1])
+			acc[o+2] += uint32(px[2])
+			acc[o+3] += uint32(px[3])
+			cnt[x>>shift]++
+		}
+
+		if (y+1)%f == 0 || y == ht-1 {
+			flush(y >> shift)
+		}
+	}
+
+	return img, nil
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func init() {
+	image.RegisterFormat("qoi", magic, Decode, DecodeConfig)
+	format.RegisterScaled("qoi", DecodeScaled)
+}
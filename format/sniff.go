@@ -0,0 +1,159 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrUnsupported is matched by errors for data in an unknown format or
+	// a known format zug can not decode.
+	ErrUnsupported = errors.New("unsupported format")
+	// ErrTruncated is matched by errors for data that ended prematurely.
+	ErrTruncated = errors.New("truncated data")
+)
+
+// DecodeError annotates a decoding error with the sniffed format.
+type DecodeError struct {
+	// Format as returned by Sniff, empty if unknown.
+	Format string
+	// Err is ErrUnsupported, ErrTruncated or the error returned by the
+	// decoder.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	msg := e.Err.Error()
+	if e.Format == "" || strings.HasPrefix(msg, e.Format+":") {
+		return msg
+	}
+	return e.Format + ": " + msg
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Type is the result of Sniff.
+type Type struct {
+	// Name of the format, empty if unknown.
+	Name string
+	// Supported reports whether a decoder is registered for this format.
+	Supported bool
+}
+
+// SniffLen is the amount of bytes Sniff needs to reliably detect a format.
+const SniffLen = 1024
+
+type magic struct {
+	name  string
+	match func(b []byte) bool
+}
+
+func prefix(p string) func([]byte) bool {
+	return func(b []byte) bool { return bytes.HasPrefix(b, []byte(p)) }
+}
+
+func riff(kind string) func([]byte) bool {
+	return func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == kind
+	}
+}
+
+// isobmff matches the ftyp box of ISO base media files (mp4, heic, ...)
+// against the given brands.
+func isobmff(brands ...string) func([]byte) bool {
+	return func(b []byte) bool {
+		if len(b) < 12 || string(b[4:8]) != "ftyp" {
+			return false
+		}
+		major := string(b[8:12])
+		for _, br := range brands {
+			if major == br {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var magics = []magic{
+	{"png", prefix("\x89PNG\r\n\x1a\n")},
+	{"jpeg", prefix("\xff\xd8\xff")},
+	{"gif", prefix("GIF8")},
+	{"webp", riff("WEBP")},
+	{"bmp", prefix("BM")},
+	{"tiff", prefix("II\x2a\x00")},
+	{"tiff", prefix("MM\x00\x2a")},
+	{"qoi", prefix("qoif")},
+	{"farbfeld", prefix("farbfeld")},
+	{"ico", prefix("\x00\x00\x01\x00")},
+	{"cur", prefix("\x00\x00\x02\x00")},
+	{"heic", isobmff("heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1")},
+	{"avif", isobmff("avif", "avis")},
+	{"jxl", prefix("\xff\x0a")},
+	{"jxl", prefix("\x00\x00\x00\x0cJXL \r\n\x87\n")},
+	{"jpeg2000", prefix("\x00\x00\x00\x0cjP  \r\n\x87\n")},
+	{"pdf", prefix("%PDF-")},
+	{"psd", prefix("8BPS")},
+	{"openexr", prefix("\x76\x2f\x31\x01")},
+	{"mp4", isobmff("isom", "iso2", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "dash", "3gp4", "3gp5", "3g2a")},
+	{"mov", isobmff("qt  ")},
+	{"webm", prefix("\x1a\x45\xdf\xa3")},
+	{"avi", riff("AVI ")},
+	{"ogg", prefix("OggS")},
+	{"pnm", func(b []byte) bool { return len(b) >= 2 && b[0] == 'P' && b[1] >= '1' && b[1] <= '7' }},
+}
+
+// Sniff detects the format of the given header using magic numbers,
+// including common formats zug can not decode such as HEIC, AVIF, JPEG XL,
+// PDF and video containers. Pass at least SniffLen bytes if available.
+func Sniff(header []byte) Type {
+	if len(header) > SniffLen {
+		header = header[:SniffLen]
+	}
+
+	if f, ok := MatchVector(header); ok {
+		return Type{Name: f.Name, Supported: true}
+	}
+
+	var t Type
+	for _, m := range magics {
+		if m.match(header) {
+			t.Name = m.name
+			break
+		}
+	}
+
+	_, name, err := image.DecodeConfig(bytes.NewReader(header))
+	t.Supported = err != image.ErrFormat
+	if t.Supported && name != "" {
+		t.Name = name
+	}
+
+	return t
+}
+
+// WrapError annotates err with the format sniffed from header and maps it
+// to ErrUnsupported or ErrTruncated where applicable. LimitErrors and nil
+// are returned as is.
+func WrapError(header []byte, err error) error {
+	if err == nil || errors.Is(err, ErrLimit) {
+		return err
+	}
+	var derr *DecodeError
+	if errors.As(err, &derr) {
+		return err
+	}
+
+	t := Sniff(header)
+	switch {
+	case err == image.ErrFormat || !t.Supported:
+		err = ErrUnsupported
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		err = ErrTruncated
+	}
+
+	return &DecodeError{Format: t.Name, Err: err}
+}
@@ -0,0 +1,160 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"testing"
+
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// magicHeaders are headers matching each entry of magics. Those of
+// unsupported formats are the shortest that match.
+var magicHeaders = []struct {
+	name      string
+	header    string
+	supported bool
+}{
+	{"png", "\x89PNG\r\n\x1a\n", true},
+	{"jpeg", "\xff\xd8\xff", true},
+	// The gif decoder requires a version, so the magic alone is not enough.
+	{"gif", "GIF8", false},
+	{"gif", "GIF89a", true},
+	{"webp", "RIFF\x00\x00\x00\x00WEBP", false},
+	{"bmp", "BM", false},
+	{"tiff", "II\x2a\x00", false},
+	{"tiff", "MM\x00\x2a", false},
+	{"qoi", "qoif", false},
+	{"farbfeld", "farbfeld", false},
+	{"ico", "\x00\x00\x01\x00", false},
+	{"cur", "\x00\x00\x02\x00", false},
+	{"heic", "\x00\x00\x00\x18ftypheic", false},
+	{"heic", "\x00\x00\x00\x18ftypmif1", false},
+	{"avif", "\x00\x00\x00\x1cftypavif", false},
+	{"jxl", "\xff\x0a", false},
+	{"jxl", "\x00\x00\x00\x0cJXL \r\n\x87\n", false},
+	{"jpeg2000", "\x00\x00\x00\x0cjP  \r\n\x87\n", false},
+	{"pdf", "%PDF-", false},
+	{"psd", "8BPS", false},
+	{"openexr", "\x76\x2f\x31\x01", false},
+	{"mp4", "\x00\x00\x00\x20ftypisom", false},
+	{"mp4", "\x00\x00\x00\x20ftypmp42", false},
+	{"mov", "\x00\x00\x00\x14ftypqt  ", false},
+	{"webm", "\x1a\x45\xdf\xa3", false},
+	{"avi", "RIFF\x00\x00\x00\x00AVI ", false},
+	{"ogg", "OggS", false},
+	{"pnm", "P1", false},
+	{"pnm", "P4", false},
+	{"pnm", "P7", false},
+}
+
+func TestSniff(t *testing.T) {
+	names := make(map[string]bool)
+	for _, c := range magicHeaders {
+		names[c.name] = true
+	}
+	for _, m := range magics {
+		if !names[m.name] {
+			t.Errorf("no test header for %s", m.name)
+		}
+	}
+
+	for _, c := range magicHeaders {
+		exp := Type{Name: c.name, Supported: c.supported}
+		if got := Sniff([]byte(c.header)); got != exp {
+			t.Errorf("%q: got %+v, expected %+v", c.header, got, exp)
+		}
+		long := append([]byte(c.header), make([]byte, 2*SniffLen)...)
+		if got := Sniff(long); got != exp {
+			t.Errorf("%q followed by zeros: got %+v, expected %+v", c.header, got, exp)
+		}
+
+		// Registered decoders match their own, possibly shorter, magic.
+		if c.supported {
+			continue
+		}
+		for n := 0; n < len(c.header); n++ {
+			if got := Sniff([]byte(c.header[:n])); got != (Type{}) {
+				t.Errorf("%q truncated to %d bytes: got %+v", c.header, n, got)
+			}
+		}
+	}
+}
+
+func TestSniffUnknown(t *testing.T) {
+	for _, h := range []string{
+		"",
+		"hello world",
+		"P8",
+		"RIFF\x00\x00\x00\x00WAVE",
+		"\x00\x00\x00\x18ftypcrx ",
+		"\x00\x00\x00\x18moovheic",
+	} {
+		if got := Sniff([]byte(h)); got != (Type{}) {
+			t.Errorf("%q: got %+v", h, got)
+		}
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	truncated := buf.Bytes()[:buf.Len()/2]
+	_, errTruncated := png.Decode(bytes.NewReader(truncated))
+	_, _, errFormat := image.Decode(bytes.NewReader([]byte("hello world")))
+	limit := &LimitError{Limit: "pixels", Value: 2, Max: 1}
+
+	for _, c := range []struct {
+		name   string
+		header string
+		err    error
+		is     error
+		format string
+		msg    string
+	}{
+		{"truncated", string(truncated), errTruncated, ErrTruncated, "png", "png: truncated data"},
+		{"unknown", "hello world", errFormat, ErrUnsupported, "", "unsupported format"},
+		{"unsupported", "\x00\x00\x00\x18ftypheic", errFormat, ErrUnsupported, "heic", "heic: unsupported format"},
+		{"decoder", "\x89PNG\r\n\x1a\n", png.FormatError("bad"), png.FormatError("bad"), "png", "png: invalid format: bad"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := WrapError([]byte(c.header), c.err)
+			if !errors.Is(err, c.is) {
+				t.Errorf("%v does not match %v", err, c.is)
+			}
+			if err.Error() != c.msg {
+				t.Errorf("message %q, expected %q", err.Error(), c.msg)
+			}
+
+			wrapped := fmt.Errorf("open image: %w", err)
+			var derr *DecodeError
+			if !errors.As(wrapped, &derr) {
+				t.Fatalf("%v is not a DecodeError", wrapped)
+			}
+			if derr.Format != c.format {
+				t.Errorf("format %q, expected %q", derr.Format, c.format)
+			}
+			if again := WrapError(nil, wrapped); again != wrapped {
+				t.Errorf("wrapped again: %v", again)
+			}
+		})
+	}
+
+	if err := WrapError(nil, nil); err != nil {
+		t.Errorf("nil error wrapped: %v", err)
+	}
+	wrapped := fmt.Errorf("decode: %w", limit)
+	if err := WrapError([]byte("\x89PNG\r\n\x1a\n"), wrapped); err != wrapped {
+		t.Errorf("LimitError wrapped: %v", err)
+	}
+	var lerr *LimitError
+	if !errors.As(WrapError(nil, wrapped), &lerr) || lerr != limit {
+		t.Error("LimitError not found")
+	}
+}
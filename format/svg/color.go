@@ -0,0 +1,230 @@
+package svg
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+type paintKind byte
+
+const (
+	paintNone paintKind = iota
+	paintColor
+	paintCurrent
+)
+
+type paint struct {
+	kind paintKind
+	c    color.NRGBA
+}
+
+// parsePaint parses a fill or stroke value. Gradients referenced with
+// url() resolve to a representative solid color.
+func (r *renderer) parsePaint(s string) (paint, bool) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return paint{}, false
+	case "none", "transparent":
+		return paint{kind: paintNone}, true
+	case "currentColor":
+		return paint{kind: paintCurrent}, true
+	}
+
+	if strings.HasPrefix(s, "url(") {
+		end := strings.IndexByte(s, ')')
+		if end == -1 {
+			return paint{}, false
+		}
+		id := strings.Trim(strings.TrimSpace(s[4:end]), `"'`)
+		if c, ok := r.gradientColor(strings.TrimPrefix(id, "#")); ok {
+			return paint{kind: paintColor, c: c}, true
+		}
+		// Fallback color after the url.
+		return r.parsePaint(s[end+1:])
+	}
+
+	c, ok := parseColor(s)
+	if !ok {
+		return paint{}, false
+	}
+	return paint{kind: paintColor, c: c}, true
+}
+
+// gradientColor returns the average color of the stops of the gradient
+// with the given id, following href chains.
+func (r *renderer) gradientColor(id string) (color.NRGBA, bool) {
+	for i := 0; i < 8; i++ {
+		n := r.doc.ids[id]
+		if n == nil {
+			return color.NRGBA{}, false
+		}
+		if n.name != "linearGradient" && n.name != "radialGradient" {
+			return color.NRGBA{}, false
+		}
+
+		var sum [4]float64
+		var count float64
+		for _, stop := range n.children {
+			if stop.name != "stop" {
+				continue
+			}
+			props := parseStyle(stop.attr("style"))
+			get := func(name string) string {
+				if v, ok := props[name]; ok {
+					return v
+				}
+				return stop.attr(name)
+			}
+			c, ok := parseColor(get("stop-color"))
+			if !ok {
+				c = color.NRGBA{0, 0, 0, 255}
+			}
+			if o, ok := parseOpacity(get("stop-opacity")); ok {
+				c.A = uint8(float64(c.A)*o + 0.5)
+			}
+			sum[0] += float64(c.R)
+			sum[1] += float64(c.G)
+			sum[2] += float64(c.B)
+			sum[3] += float64(c.A)
+			count++
+		}
+
+		if count == 0 {
+			id = strings.TrimPrefix(n.attr("href"), "#")
+			continue
+		}
+
+		return color.NRGBA{
+			uint8(sum[0]/count + 0.5),
+			uint8(sum[1]/count + 0.5),
+			uint8(sum[2]/count + 0.5),
+			uint8(sum[3]/count + 0.5),
+		}, true
+	}
+	return color.NRGBA{}, false
+}
+
+func parseColor(s string) (color.NRGBA, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return color.NRGBA{}, false
+	}
+
+	if s[0] == '#' {
+		h := s[1:]
+		switch len(h) {
+		case 3, 4:
+			var b [4]byte
+			b[3] = 0xff
+			for i := range h {
+				v, err := strconv.ParseUint(h[i:i+1], 16, 8)
+				if err != nil {
+					return color.NRGBA{}, false
+				}
+				b[i] = uint8(v * 0x11)
+			}
+			return color.NRGBA{b[0], b[1], b[2], b[3]}, true
+		case 6, 8:
+			v, err := strconv.ParseUint(h, 16, 32)
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			if len(h) == 6 {
+				v = v<<8 | 0xff
+			}
+			return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+		}
+		return color.NRGBA{}, false
+	}
+
+	l := strings.ToLower(s)
+	if strings.HasPrefix(l, "rgb(") || strings.HasPrefix(l, "rgba(") {
+		open, end := strings.IndexByte(l, '('), strings.IndexByte(l, ')')
+		if end < open {
+			return color.NRGBA{}, false
+		}
+		parts := strings.FieldsFunc(l[open+1:end], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) < 3 {
+			return color.NRGBA{}, false
+		}
+		var c [4]uint8
+		c[3] = 0xff
+		for i := 0; i < len(parts) && i < 4; i++ {
+			p := parts[i]
+			max := 255.0
+			if i == 3 {
+				max = 1
+			}
+			var v float64
+			var err error
+			if strings.HasSuffix(p, "%") {
+				v, err = strconv.ParseFloat(p[:len(p)-1], 64)
+				v = v * max / 100
+			} else {
+				v, err = strconv.ParseFloat(p, 64)
+			}
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			v = clamp(v/max, 0, 1)
+			c[i] = uint8(v*255 + 0.5)
+		}
+		return color.NRGBA{c[0], c[1], c[2], c[3]}, true
+	}
+
+	if c, ok := colornames.Map[l]; ok {
+		return color.NRGBA{c.R, c.G, c.B, c.A}, true
+	}
+	return color.NRGBA{}, false
+}
+
+func parseOpacity(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	pct := strings.HasSuffix(s, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, false
+	}
+	if pct {
+		v /= 100
+	}
+	return clamp(v, 0, 1), true
+}
+
+// parseStyle parses a style attribute into its declarations.
+func parseStyle(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, decl := range strings.Split(s, ";") {
+		i := strings.IndexByte(decl, ':')
+		if i == -1 {
+			continue
+		}
+		k := strings.TrimSpace(decl[:i])
+		v := strings.TrimSpace(decl[i+1:])
+		v = strings.TrimSpace(strings.TrimSuffix(v, "!important"))
+		m[k] = v
+	}
+	return m
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,232 @@
+package svg
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+type point struct{ x, y float64 }
+
+// matrix is an affine transform [a c e; b d f; 0 0 1].
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+func translate(x, y float64) matrix { return matrix{1, 0, 0, 1, x, y} }
+func scale(x, y float64) matrix     { return matrix{x, 0, 0, y, 0, 0} }
+
+func rotate(deg float64) matrix {
+	s, c := math.Sincos(deg * math.Pi / 180)
+	return matrix{c, s, -s, c, 0, 0}
+}
+
+// mul returns the transform that first applies m and then n.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		n[0]*m[0] + n[2]*m[1],
+		n[1]*m[0] + n[3]*m[1],
+		n[0]*m[2] + n[2]*m[3],
+		n[1]*m[2] + n[3]*m[3],
+		n[0]*m[4] + n[2]*m[5] + n[4],
+		n[1]*m[4] + n[3]*m[5] + n[5],
+	}
+}
+
+func (m matrix) apply(p point) point {
+	return point{
+		m[0]*p.x + m[2]*p.y + m[4],
+		m[1]*p.x + m[3]*p.y + m[5],
+	}
+}
+
+// scaleFactor returns the average scale of m, used to transform stroke
+// widths.
+func (m matrix) scaleFactor() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// parseTransform parses a transform attribute, the returned matrix maps
+// element user space to the parent's user space.
+func parseTransform(s string) matrix {
+	m := identity
+	for {
+		s = strings.TrimLeft(s, " \t\r\n,")
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open == -1 || end < open {
+			return m
+		}
+
+		name := strings.TrimSpace(s[:open])
+		args := numbers(s[open+1 : end])
+		s = s[end+1:]
+
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+
+		var t matrix
+		switch name {
+		case "matrix":
+			if len(args) != 6 {
+				return m
+			}
+			copy(t[:], args)
+		case "translate":
+			t = translate(arg(0, 0), arg(1, 0))
+		case "scale":
+			sx := arg(0, 1)
+			t = scale(sx, arg(1, sx))
+		case "rotate":
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = translate(-cx, -cy).mul(rotate(arg(0, 0))).mul(translate(cx, cy))
+		case "skewX":
+			t = matrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = matrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m
+		}
+
+		// Transforms in the list are applied right to left.
+		m = t.mul(m)
+	}
+}
+
+// numbers parses a whitespace and/or comma separated list of numbers.
+func numbers(s string) []float64 {
+	var l []float64
+	p := numParser{s: s}
+	for {
+		v, ok := p.number()
+		if !ok {
+			return l
+		}
+		l = append(l, v)
+	}
+}
+
+var units = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 96.0 / 72,
+	"pc": 16,
+	"mm": 96 / 25.4,
+	"cm": 96 / 2.54,
+	"in": 96,
+	"em": 16,
+	"ex": 8,
+}
+
+// parseLength parses a length in pixels, percentages are relative to ref.
+func parseLength(s string, ref float64) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-1]), 64)
+		return v * ref / 100, err == nil
+	}
+
+	i := len(s)
+	for i > 0 && (s[i-1] >= 'a' && s[i-1] <= 'z') {
+		i--
+	}
+	u, ok := units[s[i:]]
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+	return v * u, err == nil
+}
+
+type numParser struct {
+	s string
+	i int
+}
+
+func (p *numParser) skip() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r', '\n', ',':
+			p.i++
+			continue
+		}
+		return
+	}
+}
+
+func (p *numParser) done() bool {
+	p.skip()
+	return p.i >= len(p.s)
+}
+
+// flag parses an arc flag which, unlike numbers, need not be separated
+// from what follows.
+func (p *numParser) flag() (bool, bool) {
+	p.skip()
+	if p.i >= len(p.s) {
+		return false, false
+	}
+	switch p.s[p.i] {
+	case '0':
+		p.i++
+		return false, true
+	case '1':
+		p.i++
+		return true, true
+	}
+	return false, false
+}
+
+func (p *numParser) number() (float64, bool) {
+	p.skip()
+	start := p.i
+	i := p.i
+	if i < len(p.s) && (p.s[i] == '+' || p.s[i] == '-') {
+		i++
+	}
+	digits, dot := false, false
+	for i < len(p.s) {
+		c := p.s[i]
+		if c >= '0' && c <= '9' {
+			digits = true
+			i++
+			continue
+		}
+		if c == '.' && !dot {
+			dot = true
+			i++
+			continue
+		}
+		break
+	}
+	if !digits {
+		return 0, false
+	}
+	if i < len(p.s) && (p.s[i] == 'e' || p.s[i] == 'E') {
+		j := i + 1
+		if j < len(p.s) && (p.s[j] == '+' || p.s[j] == '-') {
+			j++
+		}
+		k := j
+		for k < len(p.s) && p.s[k] >= '0' && p.s[k] <= '9' {
+			k++
+		}
+		if k > j {
+			i = k
+		}
+	}
+
+	v, err := strconv.ParseFloat(p.s[start:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	p.i = i
+	return v, true
+}
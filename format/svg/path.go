@@ -0,0 +1,339 @@
+package svg
+
+import "math"
+
+type op byte
+
+const (
+	opMove op = iota
+	opLine
+	opQuad
+	opCube
+	opClose
+)
+
+type segment struct {
+	op  op
+	pts [3]point
+}
+
+// path is a list of segments using absolute coordinates, arcs are
+// converted to cubic béziers.
+type path []segment
+
+func (p *path) moveTo(a point)       { *p = append(*p, segment{op: opMove, pts: [3]point{a}}) }
+func (p *path) lineTo(a point)       { *p = append(*p, segment{op: opLine, pts: [3]point{a}}) }
+func (p *path) quadTo(a, b point)    { *p = append(*p, segment{op: opQuad, pts: [3]point{a, b}}) }
+func (p *path) cubeTo(a, b, c point) { *p = append(*p, segment{op: opCube, pts: [3]point{a, b, c}}) }
+func (p *path) close()               { *p = append(*p, segment{op: opClose}) }
+
+func (p path) transform(m matrix) path {
+	n := make(path, len(p))
+	for i, s := range p {
+		n[i].op = s.op
+		for j := range s.pts {
+			n[i].pts[j] = m.apply(s.pts[j])
+		}
+	}
+	return n
+}
+
+// parsePath parses path data, parsing stops at the first error as
+// mandated by the spec.
+func parsePath(d string) path {
+	var p path
+	np := numParser{s: d}
+	var cur, start, ctrl point
+	var cmd, prev byte
+
+	for !np.done() {
+		c := np.s[np.i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			cmd = c
+			np.i++
+		} else if cmd == 0 {
+			return p
+		} else if cmd == 'M' {
+			cmd = 'L'
+		} else if cmd == 'm' {
+			cmd = 'l'
+		}
+
+		rel := cmd >= 'a'
+		abs := func(x, y float64) point {
+			if rel {
+				return point{cur.x + x, cur.y + y}
+			}
+			return point{x, y}
+		}
+
+		nums := func(n int) ([]float64, bool) {
+			v := make([]float64, n)
+			for i := range v {
+				var ok bool
+				if v[i], ok = np.number(); !ok {
+					return nil, false
+				}
+			}
+			return v, true
+		}
+
+		upper := cmd &^ 0x20
+		switch upper {
+		case 'Z':
+			p.close()
+			cur = start
+			prev = upper
+			continue
+		case 'M', 'L', 'T':
+			v, ok := nums(2)
+			if !ok {
+				return p
+			}
+			pt := abs(v[0], v[1])
+			switch upper {
+			case 'M':
+				p.moveTo(pt)
+				start = pt
+			case 'L':
+				p.lineTo(pt)
+			case 'T':
+				c := cur
+				if prev == 'Q' || prev == 'T' {
+					c = point{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+				}
+				p.quadTo(c, pt)
+				ctrl = c
+			}
+			cur = pt
+		case 'H', 'V':
+			v, ok := nums(1)
+			if !ok {
+				return p
+			}
+			pt := cur
+			switch {
+			case upper == 'H' && rel:
+				pt.x += v[0]
+			case upper == 'H':
+				pt.x = v[0]
+			case rel:
+				pt.y += v[0]
+			default:
+				pt.y = v[0]
+			}
+			p.lineTo(pt)
+			cur = pt
+		case 'C':
+			v, ok := nums(6)
+			if !ok {
+				return p
+			}
+			a, b, c := abs(v[0], v[1]), abs(v[2], v[3]), abs(v[4], v[5])
+			p.cubeTo(a, b, c)
+			ctrl, cur = b, c
+		case 'S':
+			v, ok := nums(4)
+			if !ok {
+				return p
+			}
+			a := cur
+			if prev == 'C' || prev == 'S' {
+				a = point{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+			}
+			b, c := abs(v[0], v[1]), abs(v[2], v[3])
+			p.cubeTo(a, b, c)
+			ctrl, cur = b, c
+		case 'Q':
+			v, ok := nums(4)
+			if !ok {
+				return p
+			}
+			a, b := abs(v[0], v[1]), abs(v[2], v[3])
+			p.quadTo(a, b)
+			ctrl, cur = a, b
+		case 'A':
+			v, ok := nums(3)
+			if !ok {
+				return p
+			}
+			large, ok1 := np.flag()
+			sweep, ok2 := np.flag()
+			e, ok3 := nums(2)
+			if !ok1 || !ok2 || !ok3 {
+				return p
+			}
+			end := abs(e[0], e[1])
+			p.arcTo(cur, v[0], v[1], v[2], large, sweep, end)
+			cur = end
+		default:
+			return p
+		}
+
+		prev = upper
+	}
+
+	return p
+}
+
+// arcTo appends an elliptical arc from a to b as cubic béziers, see
+// https://www.w3.org/TR/SVG11/implnote.html#ArcImplementationNotes
+func (p *path) arcTo(a point, rx, ry, rot float64, large, sweep bool, b point) {
+	if a == b {
+		return
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		p.lineTo(b)
+		return
+	}
+
+	sin, cos := math.Sincos(rot * math.Pi / 180)
+	dx, dy := (a.x-b.x)/2, (a.y-b.y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		l = math.Sqrt(l)
+		rx, ry = rx*l, ry*l
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	f := 0.0
+	if num > 0 && den > 0 {
+		f = math.Sqrt(num / den)
+	}
+	if large == sweep {
+		f = -f
+	}
+	cx1, cy1 := f*rx*y1/ry, -f*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (a.x+b.x)/2
+	cy := sin*cx1 + cos*cy1 + (a.y+b.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	t1 := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	dt := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && dt > 0 {
+		dt -= 2 * math.Pi
+	} else if sweep && dt < 0 {
+		dt += 2 * math.Pi
+	}
+
+	n := int(math.Ceil(math.Abs(dt) / (math.Pi / 2)))
+	step := dt / float64(n)
+	k := 4.0 / 3 * math.Tan(step/4)
+	ellipse := func(t float64) (point, point) {
+		s, c := math.Sincos(t)
+		pt := point{cx + rx*c*cos - ry*s*sin, cy + rx*c*sin + ry*s*cos}
+		d := point{-rx*s*cos - ry*c*sin, -rx*s*sin + ry*c*cos}
+		return pt, d
+	}
+
+	t := t1
+	from, d1 := ellipse(t)
+	for i := 0; i < n; i++ {
+		to, d2 := ellipse(t + step)
+		if i == n-1 {
+			to = b
+		}
+		p.cubeTo(
+			point{from.x + k*d1.x, from.y + k*d1.y},
+			point{to.x - k*d2.x, to.y - k*d2.y},
+			to,
+		)
+		t += step
+		from, d1 = to, d2
+	}
+}
+
+// flatten converts the path to polylines with the given tolerance in
+// device pixels.
+func (p path) flatten(tol float64) (lines [][]point, closed []bool) {
+	var cur []point
+	var start, pen point
+	isClosed := false
+	flush := func() {
+		if len(cur) > 1 {
+			lines = append(lines, cur)
+			closed = append(closed, isClosed)
+		}
+		cur = nil
+		isClosed = false
+	}
+
+	for _, s := range p {
+		switch s.op {
+		case opMove:
+			flush()
+			start, pen = s.pts[0], s.pts[0]
+			cur = append(cur, pen)
+		case opLine:
+			if len(cur) == 0 {
+				cur = append(cur, pen)
+			}
+			pen = s.pts[0]
+			cur = append(cur, pen)
+		case opQuad:
+			if len(cur) == 0 {
+				cur = append(cur, pen)
+			}
+			a, b, c := pen, s.pts[0], s.pts[1]
+			n := segments(tol, a, b, c)
+			for i := 1; i <= n; i++ {
+				t := float64(i) / float64(n)
+				mt := 1 - t
+				cur = append(cur, point{
+					mt*mt*a.x + 2*mt*t*b.x + t*t*c.x,
+					mt*mt*a.y + 2*mt*t*b.y + t*t*c.y,
+				})
+			}
+			pen = c
+		case opCube:
+			if len(cur) == 0 {
+				cur = append(cur, pen)
+			}
+			a, b, c, d := pen, s.pts[0], s.pts[1], s.pts[2]
+			n := segments(tol, a, b, c, d)
+			for i := 1; i <= n; i++ {
+				t := float64(i) / float64(n)
+				mt := 1 - t
+				cur = append(cur, point{
+					mt*mt*mt*a.x + 3*mt*mt*t*b.x + 3*mt*t*t*c.x + t*t*t*d.x,
+					mt*mt*mt*a.y + 3*mt*mt*t*b.y + 3*mt*t*t*c.y + t*t*t*d.y,
+				})
+			}
+			pen = d
+		case opClose:
+			if len(cur) != 0 {
+				if cur[len(cur)-1] != start {
+					cur = append(cur, start)
+				}
+				isClosed = true
+			}
+			flush()
+			pen = start
+		}
+	}
+	flush()
+	return
+}
+
+// segments estimates the amount of line segments needed to approximate a
+// bézier curve with the given control points.
+func segments(tol float64, pts ...point) int {
+	l := 0.0
+	for i := 1; i < len(pts); i++ {
+		l += math.Hypot(pts[i].x-pts[i-1].x, pts[i].y-pts[i-1].y)
+	}
+	n := int(math.Ceil(math.Sqrt(l / tol)))
+	if n < 1 {
+		return 1
+	}
+	if n > 256 {
+		return 256
+	}
+	return n
+}
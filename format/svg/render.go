@@ -0,0 +1,454 @@
+package svg
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+const (
+	maxDepth = 64
+	// maxElements is the amount of elements a document may render,
+	// counting each element instantiated by <use>.
+	maxElements = 1 << 20
+)
+
+type style struct {
+	fill, stroke  paint
+	fillOpacity   float64
+	strokeOpacity float64
+	opacity       float64
+	strokeWidth   float64
+	color         color.NRGBA
+	cap, join     string
+	miterLimit    float64
+	dashes        []float64
+	dashOffset    float64
+}
+
+func defaultStyle() style {
+	return style{
+		fill:          paint{kind: paintColor, c: color.NRGBA{0, 0, 0, 255}},
+		stroke:        paint{kind: paintNone},
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		opacity:       1,
+		strokeWidth:   1,
+		color:         color.NRGBA{0, 0, 0, 255},
+		cap:           "butt",
+		join:          "miter",
+		miterLimit:    4,
+	}
+}
+
+type renderer struct {
+	doc *Document
+	dst *image.RGBA
+	z   *vector.Rasterizer
+}
+
+func newRenderer(d *Document, dst *image.RGBA) *renderer {
+	return &renderer{doc: d, dst: dst, z: &vector.Rasterizer{}}
+}
+
+// style computes the style of n, inheriting from parent. It returns false
+// if the element should not be rendered.
+func (r *renderer) style(n *node, parent style) (style, bool) {
+	s := parent
+	s.opacity = parent.opacity
+	props := parseStyle(n.attr("style"))
+	get := func(name string) string {
+		v, ok := props[name]
+		if !ok {
+			v = n.attr(name)
+		}
+		if v == "inherit" {
+			return ""
+		}
+		return v
+	}
+
+	if get("display") == "none" {
+		return s, false
+	}
+	if v := get("visibility"); v == "hidden" || v == "collapse" {
+		return s, false
+	}
+
+	if c, ok := parseColor(get("color")); ok {
+		s.color = c
+	}
+	if p, ok := r.parsePaint(get("fill")); ok {
+		s.fill = p
+	}
+	if p, ok := r.parsePaint(get("stroke")); ok {
+		s.stroke = p
+	}
+	if v, ok := parseOpacity(get("fill-opacity")); ok {
+		s.fillOpacity = v
+	}
+	if v, ok := parseOpacity(get("stroke-opacity")); ok {
+		s.strokeOpacity = v
+	}
+	if v, ok := parseOpacity(get("opacity")); ok {
+		s.opacity *= v
+	}
+	if v, ok := parseLength(get("stroke-width"), 100); ok && v >= 0 {
+		s.strokeWidth = v
+	}
+	if v := get("stroke-linecap"); v != "" {
+		s.cap = v
+	}
+	if v := get("stroke-linejoin"); v != "" {
+		s.join = v
+	}
+	if v := numbers(get("stroke-miterlimit")); len(v) == 1 && v[0] >= 1 {
+		s.miterLimit = v[0]
+	}
+	if v := get("stroke-dasharray"); v != "" {
+		s.dashes = nil
+		if v != "none" {
+			d := numbers(v)
+			sum := 0.0
+			for _, l := range d {
+				if l < 0 {
+					d = nil
+					break
+				}
+				sum += l
+			}
+			if len(d)%2 == 1 {
+				d = append(d, d...)
+			}
+			if sum > 0 {
+				s.dashes = d
+			}
+		}
+	}
+	if v := numbers(get("stroke-dashoffset")); len(v) == 1 {
+		s.dashOffset = v[0]
+	}
+
+	return s, true
+}
+
+func (r *renderer) num(n *node, name string, ref float64) float64 {
+	v, _ := parseLength(n.attr(name), ref)
+	return v
+}
+
+func (r *renderer) render(n *node, m matrix, parent style, depth int) {
+	if depth > maxDepth {
+		return
+	}
+
+	st, ok := r.style(n, parent)
+	if !ok {
+		return
+	}
+
+	m = parseTransform(n.attr("transform")).mul(m)
+	vb := r.doc.viewBox
+	refW, refH := r.doc.width, r.doc.height
+	if vb != nil {
+		refW, refH = vb.w, vb.h
+	}
+	refD := math.Hypot(refW, refH) / math.Sqrt2
+
+	var p path
+	switch n.name {
+	case "svg":
+		if depth != 0 {
+			m = r.nested(n, refW, refH).mul(m)
+		}
+		r.children(n, m, st, depth)
+		return
+	case "g", "a", "switch":
+		r.children(n, m, st, depth)
+		return
+	case "use":
+		id := strings.TrimPrefix(n.attr("href"), "#")
+		t := r.doc.ids[id]
+		if t == nil {
+			return
+		}
+		m = translate(r.num(n, "x", refW), r.num(n, "y", refH)).mul(m)
+		if t.name == "symbol" {
+			r.children(t, m, st, depth+1)
+			return
+		}
+		r.render(t, m, st, depth+1)
+		return
+
+	case "path":
+		p = parsePath(n.attr("d"))
+	case "rect":
+		x, y := r.num(n, "x", refW), r.num(n, "y", refH)
+		w, h := r.num(n, "width", refW), r.num(n, "height", refH)
+		if w <= 0 || h <= 0 {
+			return
+		}
+		rx, rxok := parseLength(n.attr("rx"), refW)
+		ry, ryok := parseLength(n.attr("ry"), refH)
+		if !rxok {
+			rx = ry
+		}
+		if !ryok {
+			ry = rx
+		}
+		p = rectPath(x, y, w, h, rx, ry)
+	case "circle":
+		rad := r.num(n, "r", refD)
+		if rad <= 0 {
+			return
+		}
+		p = ellipsePath(r.num(n, "cx", refW), r.num(n, "cy", refH), rad, rad)
+	case "ellipse":
+		rx, ry := r.num(n, "rx", refW), r.num(n, "ry", refH)
+		if rx <= 0 || ry <= 0 {
+			return
+		}
+		p = ellipsePath(r.num(n, "cx", refW), r.num(n, "cy", refH), rx, ry)
+	case "line":
+		p.moveTo(point{r.num(n, "x1", refW), r.num(n, "y1", refH)})
+		p.lineTo(point{r.num(n, "x2", refW), r.num(n, "y2", refH)})
+	case "polyline", "polygon":
+		v := numbers(n.attr("points"))
+		for i := 0; i+1 < len(v); i += 2 {
+			if i == 0 {
+				p.moveTo(point{v[i], v[i+1]})
+				continue
+			}
+			p.lineTo(point{v[i], v[i+1]})
+		}
+		if n.name == "polygon" && len(p) != 0 {
+			p.close()
+		}
+	default:
+		return
+	}
+
+	if len(p) == 0 {
+		return
+	}
+
+	dp := p.transform(m)
+	if c, ok := r.color(st.fill, st, st.fillOpacity); ok && n.name != "line" {
+		r.fill(dp, c)
+	}
+	if c, ok := r.color(st.stroke, st, st.strokeOpacity); ok && st.strokeWidth > 0 {
+		r.stroke(dp, st, st.strokeWidth*m.scaleFactor(), m.scaleFactor(), c)
+	}
+}
+
+// elements adds the amount of elements render visits for n to count and
+// returns false as soon as it exceeds maxElements.
+func (d *Document) elements(n *node, depth int, count *int) bool {
+	if depth > maxDepth {
+		return true
+	}
+	*count++
+	if *count > maxElements {
+		return false
+	}
+
+	switch n.name {
+	case "svg", "g", "a", "switch":
+		return d.childElements(n, depth, count)
+	case "use":
+		t := d.ids[strings.TrimPrefix(n.attr("href"), "#")]
+		if t == nil {
+			return true
+		}
+		if t.name == "symbol" {
+			return d.childElements(t, depth+1, count)
+		}
+		return d.elements(t, depth+1, count)
+	}
+	return true
+}
+
+func (d *Document) childElements(n *node, depth int, count *int) bool {
+	for _, c := range n.children {
+		if !d.elements(c, depth+1, count) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *renderer) children(n *node, m matrix, st style, depth int) {
+	for _, c := range n.children {
+		r.render(c, m, st, depth+1)
+	}
+}
+
+// nested returns the transform established by a nested svg element.
+func (r *renderer) nested(n *node, refW, refH float64) matrix {
+	x, y := r.num(n, "x", refW), r.num(n, "y", refH)
+	m := translate(x, y)
+	vb := numbers(n.attr("viewBox"))
+	w, wok := parseLength(n.attr("width"), refW)
+	h, hok := parseLength(n.attr("height"), refH)
+	if len(vb) != 4 || vb[2] <= 0 || vb[3] <= 0 || !wok || !hok {
+		return m
+	}
+
+	d := &Document{
+		width:   w,
+		height:  h,
+		viewBox: &viewBox{vb[0], vb[1], vb[2], vb[3]},
+		aspect:  parseAspect(n.attr("preserveAspectRatio")),
+	}
+	return d.viewport(w, h).mul(m)
+}
+
+func (r *renderer) color(p paint, st style, opacity float64) (color.NRGBA, bool) {
+	var c color.NRGBA
+	switch p.kind {
+	case paintNone:
+		return c, false
+	case paintCurrent:
+		c = st.color
+	default:
+		c = p.c
+	}
+	c.A = uint8(float64(c.A)*opacity*st.opacity + 0.5)
+	return c, c.A != 0
+}
+
+func bounds(pts func(func(point))) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	pts(func(p point) {
+		minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+		maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+	})
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	return image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1,
+	)
+}
+
+// begin prepares the rasterizer for drawing within b, it returns false
+// if nothing would be visible.
+func (r *renderer) begin(b image.Rectangle) (image.Rectangle, bool) {
+	b = b.Intersect(r.dst.Rect)
+	if b.Empty() {
+		return b, false
+	}
+	r.z.Reset(b.Dx(), b.Dy())
+	return b, true
+}
+
+func (r *renderer) draw(b image.Rectangle, c color.NRGBA) {
+	r.z.Draw(r.dst, b, image.NewUniform(c), image.Point{})
+}
+
+func (r *renderer) fill(p path, c color.NRGBA) {
+	b, ok := r.begin(bounds(func(f func(point)) {
+		for _, s := range p {
+			for _, pt := range s.pts {
+				if s.op != opClose {
+					f(pt)
+				}
+			}
+		}
+	}))
+	if !ok {
+		return
+	}
+
+	ox, oy := float64(b.Min.X), float64(b.Min.Y)
+	pt := func(p point) (float32, float32) {
+		return float32(p.x - ox), float32(p.y - oy)
+	}
+
+	open := false
+	for _, s := range p {
+		switch s.op {
+		case opMove:
+			if open {
+				r.z.ClosePath()
+			}
+			r.z.MoveTo(pt(s.pts[0]))
+			open = true
+			continue
+		case opClose:
+			if open {
+				r.z.ClosePath()
+			}
+			open = false
+			continue
+		}
+
+		if !open {
+			// Drawing after a close continues from the subpath start.
+			x, y := r.z.Pen()
+			r.z.MoveTo(x, y)
+			open = true
+		}
+
+		switch s.op {
+		case opLine:
+			x, y := pt(s.pts[0])
+			r.z.LineTo(x, y)
+		case opQuad:
+			x1, y1 := pt(s.pts[0])
+			x2, y2 := pt(s.pts[1])
+			r.z.QuadTo(x1, y1, x2, y2)
+		case opCube:
+			x1, y1 := pt(s.pts[0])
+			x2, y2 := pt(s.pts[1])
+			x3, y3 := pt(s.pts[2])
+			r.z.CubeTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+	if open {
+		r.z.ClosePath()
+	}
+
+	r.draw(b, c)
+}
+
+func rectPath(x, y, w, h, rx, ry float64) path {
+	var p path
+	rx = math.Min(math.Max(rx, 0), w/2)
+	ry = math.Min(math.Max(ry, 0), h/2)
+	if rx == 0 || ry == 0 {
+		p.moveTo(point{x, y})
+		p.lineTo(point{x + w, y})
+		p.lineTo(point{x + w, y + h})
+		p.lineTo(point{x, y + h})
+		p.close()
+		return p
+	}
+
+	p.moveTo(point{x + rx, y})
+	p.lineTo(point{x + w - rx, y})
+	p.arcTo(point{x + w - rx, y}, rx, ry, 0, false, true, point{x + w, y + ry})
+	p.lineTo(point{x + w, y + h - ry})
+	p.arcTo(point{x + w, y + h - ry}, rx, ry, 0, false, true, point{x + w - rx, y + h})
+	p.lineTo(point{x + rx, y + h})
+	p.arcTo(point{x + rx, y + h}, rx, ry, 0, false, true, point{x, y + h - ry})
+	p.lineTo(point{x, y + ry})
+	p.arcTo(point{x, y + ry}, rx, ry, 0, false, true, point{x + rx, y})
+	p.close()
+	return p
+}
+
+func ellipsePath(cx, cy, rx, ry float64) path {
+	var p path
+	a, b := point{cx + rx, cy}, point{cx - rx, cy}
+	p.moveTo(a)
+	p.arcTo(a, rx, ry, 0, false, true, b)
+	p.arcTo(b, rx, ry, 0, false, true, a)
+	p.close()
+	return p
+}
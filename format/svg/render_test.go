@@ -0,0 +1,155 @@
+package svg
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/frizinak/zug/format"
+)
+
+var (
+	transparent = color.RGBA{}
+	red         = color.RGBA{255, 0, 0, 255}
+	green       = color.RGBA{0, 128, 0, 255}
+	blue        = color.RGBA{0, 0, 255, 255}
+)
+
+type pixel struct {
+	x, y int
+	c    color.RGBA
+}
+
+// TestRender rasterizes 20 x 20 documents at 40 x 40 and checks pixels
+// that are fully covered or not covered at all.
+func TestRender(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		body string
+		px   []pixel
+	}{
+		{
+			"rect",
+			`<rect x="2" y="2" width="6" height="6" fill="red"/>`,
+			[]pixel{{2, 2, transparent}, {5, 5, red}, {14, 14, red}, {17, 17, transparent}, {30, 30, transparent}},
+		},
+		{
+			"circle",
+			`<circle cx="10" cy="10" r="5" fill="#00f"/>`,
+			[]pixel{{20, 20, blue}, {20, 12, blue}, {20, 8, transparent}, {11, 11, transparent}},
+		},
+		{
+			"line stroke",
+			`<line x1="0" y1="10" x2="20" y2="10" stroke="blue" stroke-width="4" fill="red"/>`,
+			[]pixel{{5, 20, blue}, {35, 17, blue}, {20, 10, transparent}, {20, 30, transparent}},
+		},
+		{
+			"path",
+			`<path d="M0 0 H10 V10 Z" fill="green"/>`,
+			[]pixel{{18, 2, green}, {2, 18, transparent}, {30, 2, transparent}},
+		},
+		{
+			"polygon",
+			`<polygon points="10,0 20,20 0,20" fill="red"/>`,
+			[]pixel{{20, 30, red}, {2, 2, transparent}, {38, 2, transparent}},
+		},
+		{
+			"transform",
+			`<g transform="translate(10 0)"><rect width="5" height="5" fill="red"/></g>`,
+			[]pixel{{2, 2, transparent}, {22, 2, red}},
+		},
+		{
+			"use",
+			`<defs><rect id="r" width="5" height="5" fill="red"/></defs>
+			<use href="#r" x="10" y="10"/>`,
+			[]pixel{{2, 2, transparent}, {22, 22, red}},
+		},
+		{
+			"use symbol",
+			`<symbol id="s"><rect width="5" height="5" fill="green"/></symbol>
+			<use xlink:href="#s" x="10"/>`,
+			[]pixel{{2, 2, transparent}, {22, 2, green}},
+		},
+		{
+			"use inherits",
+			`<defs><rect id="r" width="5" height="5"/></defs>
+			<g fill="blue"><use href="#r"/></g>`,
+			[]pixel{{2, 2, blue}},
+		},
+		{
+			"display none",
+			`<rect width="20" height="20" fill="red" style="display: none"/>`,
+			[]pixel{{10, 10, transparent}},
+		},
+		{
+			"opacity",
+			`<rect width="20" height="20" fill="red" fill-opacity="0.5"/>`,
+			[]pixel{{10, 10, color.RGBA{128, 0, 0, 128}}},
+		},
+		{
+			"nested svg",
+			`<svg x="10" y="10" width="10" height="10" viewBox="0 0 100 100">
+				<rect width="50" height="50" fill="red"/>
+			</svg>`,
+			[]pixel{{2, 2, transparent}, {22, 22, red}, {32, 32, transparent}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d := parse(t, fmt.Sprintf(
+				`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="20" height="20">%s</svg>`,
+				c.body,
+			))
+			img := d.Rasterize(40, 40).(*image.RGBA)
+			for _, p := range c.px {
+				g := img.RGBAAt(p.x, p.y)
+				if diff(g.R, p.c.R) > 1 || diff(g.G, p.c.G) > 1 || diff(g.B, p.c.B) > 1 || diff(g.A, p.c.A) > 1 {
+					t.Errorf("pixel %d,%d: got %v, expected %v", p.x, p.y, g, p.c)
+				}
+			}
+		})
+	}
+}
+
+// TestRenderUseCycle checks that elements using themselves stop at
+// maxDepth.
+func TestRenderUseCycle(t *testing.T) {
+	d := parse(t, `<svg xmlns="http://www.w3.org/2000/svg" width="20" height="20">
+		<g id="a"><rect width="1" height="1" fill="red"/><use href="#a" x="1" y="1"/></g>
+	</svg>`)
+	img := d.Rasterize(20, 20).(*image.RGBA)
+	for i := 0; i < 20; i++ {
+		if g := img.RGBAAt(i, i); g != red {
+			t.Errorf("pixel %d,%d: got %v, expected %v", i, i, g, red)
+		}
+	}
+}
+
+// uses returns a document where each of n groups uses the previous one
+// twice, the last one is rendered 2^n times.
+func uses(n int) string {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg"><defs><rect id="g0" width="1" height="1"/>`)
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<g id="g%d"><use href="#g%d"/><use href="#g%d"/></g>`, i, i-1, i-1)
+	}
+	fmt.Fprintf(&b, `</defs><use href="#g%d"/></svg>`, n)
+	return b.String()
+}
+
+func TestParseElements(t *testing.T) {
+	if _, err := Parse(strings.NewReader(uses(12))); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Parse(strings.NewReader(uses(40)))
+	var derr *format.DecodeError
+	if !errors.As(err, &derr) || derr.Format != "svg" {
+		t.Fatalf("got %v, expected a DecodeError", err)
+	}
+	if !errors.Is(err, ErrElements) {
+		t.Fatalf("got %v, expected ErrElements", err)
+	}
+}
@@ -0,0 +1,246 @@
+package svg
+
+import (
+	"image/color"
+	"math"
+)
+
+// polygon adds a closed polygon to the rasterizer. All polygons are added
+// with the same orientation so overlapping parts of a stroke add up
+// instead of cancelling each other out.
+func (r *renderer) polygon(ox, oy float64, pts ...point) {
+	if len(pts) < 3 {
+		return
+	}
+	area := 0.0
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += pts[i].x*pts[j].y - pts[j].x*pts[i].y
+	}
+
+	at := func(i int) (float32, float32) {
+		if area < 0 {
+			i = len(pts) - 1 - i
+		}
+		return float32(pts[i].x - ox), float32(pts[i].y - oy)
+	}
+
+	r.z.MoveTo(at(0))
+	for i := 1; i < len(pts); i++ {
+		r.z.LineTo(at(i))
+	}
+	r.z.ClosePath()
+}
+
+func (r *renderer) circle(ox, oy float64, c point, rad float64) {
+	n := int(math.Ceil(rad * 2))
+	if n < 8 {
+		n = 8
+	}
+	if n > 128 {
+		n = 128
+	}
+	pts := make([]point, n)
+	for i := range pts {
+		s, co := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		pts[i] = point{c.x + co*rad, c.y + s*rad}
+	}
+	r.polygon(ox, oy, pts...)
+}
+
+// dash splits the polylines according to the dash pattern.
+func dash(lines [][]point, closed []bool, pattern []float64, offset float64) ([][]point, []bool) {
+	total := 0.0
+	for _, d := range pattern {
+		total += d
+	}
+
+	var out [][]point
+	var outClosed []bool
+	for _, l := range lines {
+		ix, on := 0, true
+		rem := pattern[0]
+		off := math.Mod(offset, total)
+		if off < 0 {
+			off += total
+		}
+		for off > 0 {
+			if off < rem {
+				rem -= off
+				break
+			}
+			off -= rem
+			ix = (ix + 1) % len(pattern)
+			on = !on
+			rem = pattern[ix]
+		}
+
+		var cur []point
+		if on {
+			cur = []point{l[0]}
+		}
+		for i := 1; i < len(l); i++ {
+			a, b := l[i-1], l[i]
+			seg := math.Hypot(b.x-a.x, b.y-a.y)
+			pos := 0.0
+			for seg-pos > rem {
+				pos += rem
+				t := pos / seg
+				p := point{a.x + (b.x-a.x)*t, a.y + (b.y-a.y)*t}
+				if on {
+					cur = append(cur, p)
+					out = append(out, cur)
+					outClosed = append(outClosed, false)
+					cur = nil
+				} else {
+					cur = []point{p}
+				}
+				on = !on
+				ix = (ix + 1) % len(pattern)
+				rem = pattern[ix]
+			}
+			rem -= seg - pos
+			if on {
+				cur = append(cur, b)
+			}
+		}
+		if on && len(cur) > 1 {
+			out = append(out, cur)
+			outClosed = append(outClosed, false)
+		}
+	}
+	return out, outClosed
+}
+
+func (r *renderer) stroke(p path, st style, width, scale float64, c color.NRGBA) {
+	hw := width / 2
+	lines, closed := p.flatten(0.25)
+	if len(st.dashes) != 0 {
+		d := make([]float64, len(st.dashes))
+		for i := range d {
+			d[i] = st.dashes[i] * scale
+		}
+		lines, closed = dash(lines, closed, d, st.dashOffset*scale)
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	pad := hw * math.Max(st.miterLimit, 1)
+	b, ok := r.begin(bounds(func(f func(point)) {
+		for _, l := range lines {
+			for _, pt := range l {
+				f(point{pt.x - pad, pt.y - pad})
+				f(point{pt.x + pad, pt.y + pad})
+			}
+		}
+	}))
+	if !ok {
+		return
+	}
+	ox, oy := float64(b.Min.X), float64(b.Min.Y)
+
+	for li, l := range lines {
+		// Drop consecutive duplicate points.
+		pts := l[:1:1]
+		for _, pt := range l[1:] {
+			if pt != pts[len(pts)-1] {
+				pts = append(pts, pt)
+			}
+		}
+
+		if len(pts) == 1 {
+			switch st.cap {
+			case "round":
+				r.circle(ox, oy, pts[0], hw)
+			case "square":
+				q := pts[0]
+				r.polygon(ox, oy,
+					point{q.x - hw, q.y - hw}, point{q.x + hw, q.y - hw},
+					point{q.x + hw, q.y + hw}, point{q.x - hw, q.y + hw},
+				)
+			}
+			continue
+		}
+
+		isClosed := closed[li]
+		dirs := make([]point, len(pts)-1)
+		for i := range dirs {
+			a, b := pts[i], pts[i+1]
+			d := math.Hypot(b.x-a.x, b.y-a.y)
+			dirs[i] = point{(b.x - a.x) / d, (b.y - a.y) / d}
+		}
+
+		for i, d := range dirs {
+			a, b := pts[i], pts[i+1]
+			n := point{-d.y * hw, d.x * hw}
+			if !isClosed && st.cap == "square" {
+				if i == 0 {
+					a = point{a.x - d.x*hw, a.y - d.y*hw}
+				}
+				if i == len(dirs)-1 {
+					b = point{b.x + d.x*hw, b.y + d.y*hw}
+				}
+			}
+			r.polygon(ox, oy,
+				point{a.x + n.x, a.y + n.y}, point{b.x + n.x, b.y + n.y},
+				point{b.x - n.x, b.y - n.y}, point{a.x - n.x, a.y - n.y},
+			)
+		}
+
+		for i := 1; i < len(pts); i++ {
+			if i == len(pts)-1 && !isClosed {
+				break
+			}
+			d0 := dirs[i-1]
+			d1 := dirs[0]
+			if i < len(dirs) {
+				d1 = dirs[i]
+			}
+			r.join(ox, oy, st, pts[i], d0, d1, hw)
+		}
+
+		if !isClosed && st.cap == "round" {
+			r.circle(ox, oy, pts[0], hw)
+			r.circle(ox, oy, pts[len(pts)-1], hw)
+		}
+	}
+
+	r.draw(b, c)
+}
+
+func (r *renderer) join(ox, oy float64, st style, v, d0, d1 point, hw float64) {
+	cross := d0.x*d1.y - d0.y*d1.x
+	if math.Abs(cross) < 1e-9 && d0.x*d1.x+d0.y*d1.y > 0 {
+		return
+	}
+
+	if st.join == "round" {
+		r.circle(ox, oy, v, hw)
+		return
+	}
+
+	// The outer side of the turn is opposite to the turn direction.
+	s := 1.0
+	if cross > 0 {
+		s = -1
+	}
+	n0 := point{-d0.y * hw * s, d0.x * hw * s}
+	n1 := point{-d1.y * hw * s, d1.x * hw * s}
+	a := point{v.x + n0.x, v.y + n0.y}
+	b := point{v.x + n1.x, v.y + n1.y}
+
+	if st.join == "miter" || st.join == "miter-clip" || st.join == "arcs" {
+		// Intersect a + t*d0 with b - u*d1.
+		if math.Abs(cross) > 1e-9 {
+			t := ((b.x-a.x)*d1.y - (b.y-a.y)*d1.x) / cross
+			m := point{a.x + d0.x*t, a.y + d0.y*t}
+			if math.Hypot(m.x-v.x, m.y-v.y)/hw <= st.miterLimit {
+				r.polygon(ox, oy, v, a, m, b)
+				return
+			}
+		}
+	}
+
+	r.polygon(ox, oy, v, a, b)
+}
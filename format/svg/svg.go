@@ -0,0 +1,276 @@
+// Package svg implements a rasterizer for a practical subset of SVG 1.1:
+// basic shapes, paths, groups, use, transforms, viewBox,
+// preserveAspectRatio, fills and strokes. Gradients are approximated by a
+// solid color, text, filters, masks and clip paths are ignored.
+//
+// Importing this package registers svg with format.RegisterVector.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/frizinak/zug/format"
+)
+
+// ErrElements is returned by Parse for documents that would render too
+// many elements, e.g.: by nesting <use> elements that each instantiate
+// the previous one several times.
+var ErrElements = errors.New("svg: too many elements")
+
+// DefaultBackground is the background new Documents are rendered onto.
+var DefaultBackground color.Color = color.Transparent
+
+type node struct {
+	name     string
+	attrs    map[string]string
+	children []*node
+	text     string
+}
+
+func (n *node) attr(name string) string { return n.attrs[name] }
+
+// Document is a parsed SVG document.
+type Document struct {
+	// Background is drawn behind the document, defaults to
+	// DefaultBackground.
+	Background color.Color
+
+	root *node
+	ids  map[string]*node
+
+	width, height float64
+	viewBox       *viewBox
+	aspect        aspect
+}
+
+type viewBox struct {
+	x, y, w, h float64
+}
+
+type aspect struct {
+	// Alignment per axis, 0 = min, 0.5 = mid, 1 = max, -1 = none.
+	ax, ay float64
+	slice  bool
+}
+
+// Parse reads an SVG document from r. Documents that would render more
+// than a million elements are rejected with a format.DecodeError wrapping
+// ErrElements.
+func Parse(r io.Reader) (*Document, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}
+
+	d := &Document{Background: DefaultBackground, ids: make(map[string]*node)}
+	var stack []*node
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				// xlink:href and href both end up as href.
+				n.attrs[a.Name.Local] = strings.TrimSpace(a.Value)
+			}
+			if id := n.attrs["id"]; id != "" {
+				d.ids[id] = n
+			}
+			if len(stack) != 0 {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			} else if n.name != "svg" {
+				return nil, errors.New("svg: root element is not <svg>")
+			} else {
+				d.root = n
+			}
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			if len(stack) != 0 {
+				stack = stack[:len(stack)-1]
+			}
+
+		case xml.CharData:
+			if len(stack) != 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+
+	if d.root == nil {
+		return nil, errors.New("svg: no <svg> element")
+	}
+
+	var n int
+	if !d.elements(d.root, 0, &n) {
+		return nil, &format.DecodeError{Format: "svg", Err: ErrElements}
+	}
+
+	d.init()
+	return d, nil
+}
+
+func (d *Document) init() {
+	if vb := numbers(d.root.attr("viewBox")); len(vb) == 4 && vb[2] > 0 && vb[3] > 0 {
+		d.viewBox = &viewBox{vb[0], vb[1], vb[2], vb[3]}
+	}
+
+	d.aspect = parseAspect(d.root.attr("preserveAspectRatio"))
+
+	const defW, defH = 300, 150
+	w, wok := parseLength(d.root.attr("width"), 0)
+	h, hok := parseLength(d.root.attr("height"), 0)
+	if strings.HasSuffix(d.root.attr("width"), "%") {
+		wok = false
+	}
+	if strings.HasSuffix(d.root.attr("height"), "%") {
+		hok = false
+	}
+
+	switch {
+	case wok && hok:
+	case d.viewBox != nil && wok:
+		h = w * d.viewBox.h / d.viewBox.w
+	case d.viewBox != nil && hok:
+		w = h * d.viewBox.w / d.viewBox.h
+	case d.viewBox != nil:
+		w, h = d.viewBox.w, d.viewBox.h
+	default:
+		if !wok {
+			w = defW
+		}
+		if !hok {
+			h = defH
+		}
+	}
+
+	if w <= 0 || h <= 0 {
+		w, h = defW, defH
+	}
+	d.width, d.height = w, h
+}
+
+func parseAspect(s string) aspect {
+	a := aspect{ax: 0.5, ay: 0.5}
+	f := strings.Fields(s)
+	if len(f) != 0 && f[0] == "defer" {
+		f = f[1:]
+	}
+	if len(f) == 0 {
+		return a
+	}
+
+	align := f[0]
+	if align == "none" {
+		a.ax, a.ay = -1, -1
+	} else if len(align) == 8 {
+		pos := func(s string) float64 {
+			switch s {
+			case "Min":
+				return 0
+			case "Max":
+				return 1
+			}
+			return 0.5
+		}
+		a.ax, a.ay = pos(align[1:4]), pos(align[5:8])
+	}
+
+	a.slice = len(f) > 1 && f[1] == "slice"
+	return a
+}
+
+// Size returns the intrinsic size of the document in pixels.
+func (d *Document) Size() (w, h float64) { return d.width, d.height }
+
+// viewport returns the transform from user space of the root element to
+// a w x h pixel viewport.
+func (d *Document) viewport(vw, vh float64) matrix {
+	vb := d.viewBox
+	if vb == nil {
+		vb = &viewBox{0, 0, d.width, d.height}
+	}
+
+	sx, sy := vw/vb.w, vh/vb.h
+	if d.aspect.ax < 0 {
+		return translate(-vb.x, -vb.y).mul(scale(sx, sy))
+	}
+
+	s := sx
+	if (sy < sx) != d.aspect.slice {
+		s = sy
+	}
+
+	tx := (vw - vb.w*s) * d.aspect.ax
+	ty := (vh - vb.h*s) * d.aspect.ay
+	return translate(-vb.x, -vb.y).mul(scale(s, s)).mul(translate(tx, ty))
+}
+
+// Rasterize renders the document at exactly w x h pixels. The viewBox is
+// fitted according to preserveAspectRatio.
+func (d *Document) Rasterize(w, h int) image.Image {
+	return d.RasterizeRegion(w, h, image.Rect(0, 0, w, h))
+}
+
+// RasterizeRegion renders only the part r of the document rasterized at
+// w x h pixels.
+func (d *Document) RasterizeRegion(w, h int, r image.Rectangle) image.Image {
+	dst := image.NewRGBA(r)
+	if w <= 0 || h <= 0 || r.Empty() {
+		return dst
+	}
+
+	if d.Background != nil {
+		if _, _, _, a := d.Background.RGBA(); a != 0 {
+			fill(dst, d.Background)
+		}
+	}
+
+	rr := newRenderer(d, dst)
+	rr.render(d.root, d.viewport(float64(w), float64(h)), defaultStyle(), 0)
+	return dst
+}
+
+func fill(dst *image.RGBA, c color.Color) {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	px := []byte{rgba.R, rgba.G, rgba.B, rgba.A}
+	for i := 0; i < len(dst.Pix); i += 4 {
+		copy(dst.Pix[i:i+4], px)
+	}
+}
+
+// Match reports whether the given header looks like an SVG document.
+func Match(header []byte) bool {
+	h := bytes.TrimLeft(header, "\xef\xbb\xbf \t\r\n")
+	if !bytes.HasPrefix(h, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(header, []byte("<svg"))
+}
+
+func decode(r io.Reader) (format.Vector, error) { return Parse(r) }
+
+func init() {
+	format.RegisterVector(format.VectorFormat{
+		Name:   "svg",
+		Match:  Match,
+		Decode: decode,
+	})
+}
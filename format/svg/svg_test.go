@@ -0,0 +1,55 @@
+package svg
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, doc string) *Document {
+	t.Helper()
+	d, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestRasterizeRegion(t *testing.T) {
+	d := parse(t, `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="30" viewBox="0 0 40 30">
+		<circle cx="20" cy="15" r="12" fill="red" stroke="blue" stroke-width="3"/>
+		<path d="M2 2 L38 28 L2 28 Z" fill="green" fill-opacity="0.5"/>
+	</svg>`)
+	d.Background = color.White
+
+	const w, h = 160, 120
+	full := d.Rasterize(w, h).(*image.RGBA)
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, w, h),
+		image.Rect(37, 11, 101, 75),
+		image.Rect(150, 100, 170, 130),
+	} {
+		got := d.RasterizeRegion(w, h, r)
+		if got.Bounds() != r {
+			t.Fatalf("%v: bounds %v", r, got.Bounds())
+		}
+		vis := r.Intersect(full.Rect)
+		for y := vis.Min.Y; y < vis.Max.Y; y++ {
+			for x := vis.Min.X; x < vis.Max.X; x++ {
+				g := got.(*image.RGBA).RGBAAt(x, y)
+				e := full.RGBAAt(x, y)
+				if diff(g.R, e.R) > 1 || diff(g.G, e.G) > 1 || diff(g.B, e.B) > 1 || diff(g.A, e.A) > 1 {
+					t.Fatalf("%v: pixel %d,%d: got %v, expected %v", r, x, y, g, e)
+				}
+			}
+		}
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
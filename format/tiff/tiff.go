@@ -0,0 +1,165 @@
+// Package tiff adds multi-page support to golang.org/x/image/tiff.
+//
+// Importing this package registers the tiff format with both the standard
+// image package and format.RegisterPaged.
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/frizinak/zug/format"
+	"golang.org/x/image/tiff"
+)
+
+const (
+	leHeader = "II\x2A\x00"
+	beHeader = "MM\x00\x2A"
+
+	// Arbitrary upper bound to guard against IFD loops and malicious files.
+	maxPages = 1 << 16
+)
+
+func byteOrder(r io.ReaderAt) (binary.ByteOrder, error) {
+	p := make([]byte, 4)
+	if _, err := r.ReadAt(p, 0); err != nil {
+		return nil, err
+	}
+	switch string(p) {
+	case leHeader:
+		return binary.LittleEndian, nil
+	case beHeader:
+		return binary.BigEndian, nil
+	}
+	return nil, tiff.FormatError("malformed header")
+}
+
+// offsets returns the offsets of all IFDs in r, up to and including page
+// max if max >= 0.
+func offsets(r io.ReaderAt, max int) ([]uint32, error) {
+	bo, err := byteOrder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := make([]byte, 4)
+	if _, err := r.ReadAt(p, 4); err != nil {
+		return nil, err
+	}
+
+	var l []uint32
+	seen := make(map[uint32]struct{})
+	off := bo.Uint32(p)
+	for off != 0 {
+		if _, ok := seen[off]; ok {
+			return nil, tiff.FormatError("IFD loop")
+		}
+		if len(l) >= maxPages {
+			return nil, tiff.FormatError("too many pages")
+		}
+		seen[off] = struct{}{}
+		l = append(l, off)
+		if max >= 0 && len(l) > max {
+			break
+		}
+
+		if _, err := r.ReadAt(p[:2], int64(off)); err != nil {
+			return nil, err
+		}
+		n := int64(bo.Uint16(p[:2]))
+		if _, err := r.ReadAt(p, int64(off)+2+n*12); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		off = bo.Uint32(p)
+	}
+
+	return l, nil
+}
+
+// Pages returns the amount of pages (IFDs) in the given tiff.
+func Pages(r io.ReaderAt) (int, error) {
+	l, err := offsets(r, -1)
+	return len(l), err
+}
+
+// newPageReader returns a reader that presents the given zero-based page as
+// the first one.
+func newPageReader(r io.ReaderAt, page int) (io.Reader, error) {
+	if page < 0 {
+		return nil, fmt.Errorf("tiff: invalid page %d", page)
+	}
+	l, err := offsets(r, page)
+	if err != nil {
+		return nil, err
+	}
+	if page >= len(l) {
+		return nil, fmt.Errorf("tiff: page %d out of range [0, %d)", page, len(l))
+	}
+	if page == 0 {
+		return io.NewSectionReader(r, 0, 1<<63-1), nil
+	}
+
+	bo, _ := byteOrder(r)
+	return &pageReader{r: r, bo: bo, ifd: l[page]}, nil
+}
+
+// DecodePageConfig returns the color model and dimensions of the
+// zero-based page from the given tiff.
+func DecodePageConfig(r io.ReaderAt, page int) (image.Config, error) {
+	pr, err := newPageReader(r, page)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return tiff.DecodeConfig(pr)
+}
+
+// DecodePage decodes the zero-based page from the given tiff.
+func DecodePage(r io.ReaderAt, page int) (image.Image, error) {
+	pr, err := newPageReader(r, page)
+	if err != nil {
+		return nil, err
+	}
+	return tiff.Decode(pr)
+}
+
+// pageReader presents r as if the IFD at offset ifd were the first one.
+type pageReader struct {
+	r   io.ReaderAt
+	bo  binary.ByteOrder
+	ifd uint32
+}
+
+func (p *pageReader) Read([]byte) (int, error) {
+	return 0, errors.New("tiff: pageReader only supports ReadAt")
+}
+
+func (p *pageReader) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.r.ReadAt(b, off)
+	if off >= 8 || off+int64(n) <= 4 {
+		return n, err
+	}
+
+	var hdr [4]byte
+	p.bo.PutUint32(hdr[:], p.ifd)
+	for i := 0; i < n; i++ {
+		o := off + int64(i)
+		if o >= 4 && o < 8 {
+			b[i] = hdr[o-4]
+		}
+	}
+	return n, err
+}
+
+func init() {
+	format.RegisterPaged("tiff", format.Paged{
+		Pages:  Pages,
+		Config: DecodePageConfig,
+		Decode: DecodePage,
+	})
+}
@@ -0,0 +1,46 @@
+package tiff
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/frizinak/zug/internal/formattest"
+)
+
+func TestPages(t *testing.T) {
+	data, err := os.ReadFile("testdata/pages.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+
+	n, err := Pages(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("pages %d, expected 3", n)
+	}
+
+	for page := 0; page < n; page++ {
+		exp := formattest.Golden(t, fmt.Sprintf("page%d.png", page))
+		c, err := DecodePageConfig(r, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Width != exp.Bounds().Dx() || c.Height != exp.Bounds().Dy() {
+			t.Fatalf("page %d: config %dx%d, expected %v", page, c.Width, c.Height, exp.Bounds())
+		}
+		img, err := DecodePage(r, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formattest.Compare(t, img, exp)
+	}
+
+	if _, err := DecodePage(r, n); err == nil {
+		t.Fatal("expected an error decoding a page out of range")
+	}
+}
@@ -3,11 +3,15 @@ package img
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+
+	"github.com/frizinak/zug/format"
 )
 
 type httpHandler struct {
@@ -29,6 +33,9 @@ func (h *httpHandler) Get(u *url.URL, dir string) (ok bool, temp bool, file stri
 	return
 }
 
+// get downloads u to dest. Responses in a format known to be unsupported
+// result in an error matching format.ErrUnsupported, incomplete ones in
+// format.ErrTruncated. dest is only created on success.
 func get(u *url.URL, dest string) error {
 	res, err := http.Get(u.String())
 	if err != nil {
@@ -36,14 +43,46 @@ func get(u *url.URL, dest string) error {
 	}
 	defer res.Body.Close()
 
-	f, err := os.Create(dest)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %s", res.Status)
+	}
+
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp)
 	defer f.Close()
 
-	_, err = io.Copy(f, res.Body)
-	return err
+	header := make([]byte, format.SniffLen)
+	n, err := io.ReadFull(res.Body, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	header = header[:n]
+	t := format.Sniff(header)
+	if !t.Supported && t.Name != "" {
+		return &format.DecodeError{Format: t.Name, Err: format.ErrUnsupported}
+	}
+
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	written, err := io.Copy(f, res.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		(err == nil && res.ContentLength > int64(n)+written) {
+		return &format.DecodeError{Format: t.Name, Err: format.ErrTruncated}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dest)
 }
 
 var HttpH = &httpHandler{}
@@ -0,0 +1,47 @@
+// Package formattest contains helpers for testing the decoders in format
+// against golden images.
+package formattest
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Golden decodes the png in the testdata directory holding the expected
+// pixels.
+func Golden(t testing.TB, name string) image.Image {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// Compare fails t if got differs from exp in bounds or any alpha
+// premultiplied pixel.
+func Compare(t testing.TB, got, exp image.Image) {
+	t.Helper()
+	if got.Bounds() != exp.Bounds() {
+		t.Fatalf("bounds %v, expected %v", got.Bounds(), exp.Bounds())
+	}
+	b := exp.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.RGBA64Model.Convert(got.At(x, y))
+			e := color.RGBA64Model.Convert(exp.At(x, y))
+			if g != e {
+				t.Fatalf("pixel %d,%d: %v, expected %v", x, y, g, e)
+			}
+		}
+	}
+}
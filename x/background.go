@@ -0,0 +1,154 @@
+package x
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Background is composited behind an image before it is uploaded so
+// transparent pixels are shown against it.
+type Background interface {
+	Filter
+	// Pixel returns the 0xRRGGBB color used for the window background.
+	Pixel() uint32
+}
+
+func pixel(c color.RGBA) uint32 {
+	return uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
+func opaque(c color.Color) color.RGBA {
+	r, g, b, _ := c.RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+}
+
+// over composites the premultiplied pixel p over the opaque color c.
+func over(p []byte, c color.RGBA) {
+	a := p[3]
+	if a == 255 {
+		return
+	}
+	ia := uint32(255 - a)
+	p[0] += uint8((uint32(c.B)*ia + 127) / 255)
+	p[1] += uint8((uint32(c.G)*ia + 127) / 255)
+	p[2] += uint8((uint32(c.R)*ia + 127) / 255)
+	p[3] = 255
+}
+
+type solid color.RGBA
+
+// SolidBackground returns a Background of a single color, its alpha is
+// ignored.
+func SolidBackground(c color.Color) Background { return solid(opaque(c)) }
+
+func (s solid) Pixel() uint32 { return pixel(color.RGBA(s)) }
+
+func (s solid) Filter(img *BGRA) {
+	rows(img, func(p []byte) {
+		for i := 0; i < len(p); i += 4 {
+			over(p[i:i+4:i+4], color.RGBA(s))
+		}
+	})
+}
+
+type checkerboard struct {
+	size int
+	a, b color.RGBA
+}
+
+// CheckerboardBackground returns a Background of size x size squares
+// alternating between a and b.
+func CheckerboardBackground(size int, a, b color.Color) Background {
+	if size < 1 {
+		size = 1
+	}
+	return checkerboard{size, opaque(a), opaque(b)}
+}
+
+func (c checkerboard) Pixel() uint32 { return pixel(c.a) }
+
+func (c checkerboard) Filter(img *BGRA) {
+	r := img.Rect
+	parallelRows(r, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			p := img.Pix[img.PixOffset(r.Min.X, y):]
+			for x := 0; x < r.Dx(); x++ {
+				col := c.a
+				if (x/c.size+(y-r.Min.Y)/c.size)&1 == 1 {
+					col = c.b
+				}
+				over(p[4*x:4*x+4:4*x+4], col)
+			}
+		}
+	})
+}
+
+// QueryBackground asks the terminal connected to tty for its background
+// color using an OSC 11 query. tty should be in raw mode and nothing else
+// should be reading from it. An error is returned if the terminal does not
+// reply within timeout.
+func QueryBackground(tty *os.File, timeout time.Duration) (color.RGBA, error) {
+	if _, err := tty.WriteString("\033]11;?\033\\"); err != nil {
+		return color.RGBA{}, err
+	}
+	if err := tty.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return color.RGBA{}, err
+	}
+	defer tty.SetReadDeadline(time.Time{})
+
+	var reply []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := tty.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if i := bytes.Index(reply, []byte("\033]11;")); i >= 0 {
+			rest := reply[i:]
+			if end := bytes.IndexAny(rest, "\a\\"); end >= 0 {
+				return ParseOSC11(rest[:end+1])
+			}
+		}
+		if err != nil {
+			return color.RGBA{}, err
+		}
+		if len(reply) > 1024 {
+			return color.RGBA{}, errors.New("no OSC 11 reply")
+		}
+	}
+}
+
+// ParseOSC11 parses a terminal's reply to an OSC 11 query of the form
+// "ESC ] 11 ; rgb:RRRR/GGGG/BBBB" terminated by BEL or ST.
+func ParseOSC11(reply []byte) (color.RGBA, error) {
+	s := strings.TrimSuffix(string(reply), "\a")
+	s = strings.TrimSuffix(s, "\033\\")
+	s = strings.TrimPrefix(s, "\033]11;")
+	if !strings.HasPrefix(s, "rgb:") {
+		return color.RGBA{}, fmt.Errorf("invalid OSC 11 reply %q", reply)
+	}
+
+	parts := strings.Split(s[4:], "/")
+	if len(parts) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid OSC 11 reply %q", reply)
+	}
+	var v [3]uint8
+	for i, p := range parts {
+		if len(p) == 0 || len(p) > 4 {
+			return color.RGBA{}, fmt.Errorf("invalid OSC 11 reply %q", reply)
+		}
+		n, err := strconv.ParseUint(p, 16, 16)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid OSC 11 reply %q", reply)
+		}
+		// Scale from len(p)*4 bits to 8 bits.
+		max := uint64(1)<<(4*uint(len(p))) - 1
+		v[i] = uint8((n*255 + max/2) / max)
+	}
+
+	return color.RGBA{v[0], v[1], v[2], 255}, nil
+}
@@ -0,0 +1,235 @@
+package x
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"math"
+
+	"github.com/frizinak/zug/format"
+	"github.com/frizinak/zug/format/icc"
+)
+
+// Decoder decodes Images while enforcing format.Limits. The limits are
+// checked using image.DecodeConfig before decoding any pixels.
+type Decoder struct {
+	Limits format.Limits
+
+	// Size, if set, is the largest size the image will be displayed at.
+	// Formats that support it (see format.RegisterScaled) are decoded
+	// reduced by the largest power of two that keeps them at least this
+	// large, e.g.: JPEGs are scaled in the DCT domain. Formats that hold
+	// several sizes of the image (see format.RegisterSized), e.g.: icons,
+	// decode the one that best fits.
+	Size image.Point
+
+	// Profile is the ICC profile images are converted to, nil meaning
+	// icc.SRGB. Images without an embedded profile are assumed to be sRGB.
+	Profile *icc.Profile
+	// IgnoreICC disables color management, see Profile.
+	IgnoreICC bool
+}
+
+// DefaultDecoder is used by ImageRead and does not impose any limits.
+var DefaultDecoder = &Decoder{}
+
+// ImageRead decodes an image from r using DefaultDecoder.
+func ImageRead(r io.Reader) (Image, error) { return DefaultDecoder.Read(r) }
+
+// Read decodes an image from r. If the format supports multiple pages
+// (see format.RegisterPaged) and r contains more than one, the returned
+// Image will implement Pager.
+//
+// An error matching format.ErrLimit is returned if the image exceeds
+// d.Limits, unless Limits.Downsample is set and the format supports
+// decoding a reduced version (see format.RegisterScaled).
+//
+// Decoding errors are returned as a *format.DecodeError matching
+// format.ErrUnsupported or format.ErrTruncated where applicable.
+func (d *Decoder) Read(r io.Reader) (Image, error) {
+	data, err := d.Limits.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := d.read(data)
+	return img, format.WrapError(data, err)
+}
+
+func (d *Decoder) read(data []byte) (Image, error) {
+	header := data
+	if len(header) > format.SniffLen {
+		header = header[:format.SniffLen]
+	}
+	if f, ok := format.MatchVector(header); ok {
+		v, err := f.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return d.vector(v)
+	}
+
+	c, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if count, ok := format.LookupFrames(name); ok {
+		n, err := count(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := d.Limits.CheckFrames(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if p, ok := format.LookupPaged(name); ok {
+		ra := bytes.NewReader(data)
+		n, err := p.Pages(ra)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.Limits.CheckFrames(n); err != nil {
+			return nil, err
+		}
+		if n > 1 {
+			return newPagedImage(d, ra, data, p, n)
+		}
+	}
+
+	shift, err := d.shift(name, c.Width, c.Height)
+	if err != nil {
+		return nil, err
+	}
+	if shift != 0 {
+		dec, _ := format.LookupScaled(name)
+		_img, err := dec(bytes.NewReader(data), shift)
+		if err != nil {
+			return nil, err
+		}
+		return d.image(_img, data)
+	}
+	if dec, ok := format.LookupSized(name); ok && d.Size.X > 0 && d.Size.Y > 0 {
+		_img, err := dec(bytes.NewReader(data), d.Size.X, d.Size.Y)
+		if err != nil {
+			return nil, err
+		}
+		return d.image(_img, data)
+	}
+
+	_img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return d.image(_img, data)
+}
+
+// image converts img to an Image, applying the embedded ICC profile in data.
+func (d *Decoder) image(img image.Image, data []byte) (Image, error) {
+	t, err := d.transform(data)
+	if err != nil {
+		return nil, err
+	}
+	n := &nativeImage{in: ImageToBGRA(img)}
+	if t != nil {
+		b := n.in.Rect
+		parallelRows(b, func(y0, y1 int) {
+			o0, o1 := n.in.PixOffset(b.Min.X, y0), n.in.PixOffset(b.Min.X, y1)
+			t.Apply(n.in.Pix[o0:o1])
+		})
+	}
+	return n, nil
+}
+
+// page decodes the given page of a multi-page image. Pages can not be
+// decoded reduced, Limits.Downsample and Size do not apply to them.
+func (d *Decoder) page(p format.Paged, r io.ReaderAt, data []byte, page int) (Image, error) {
+	c, err := p.Config(r, page)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Limits.CheckPixels(c.Width, c.Height); err != nil {
+		return nil, err
+	}
+	_img, err := p.Decode(r, page)
+	if err != nil {
+		return nil, err
+	}
+	return d.image(_img, data)
+}
+
+// transform returns the icc.Transform for the profile embedded in data.
+// Errors extracting the profile, e.g.: one exceeding the size limit, are
+// returned, invalid and unsupported profiles are ignored.
+func (d *Decoder) transform(data []byte) (*icc.Transform, error) {
+	if d.IgnoreICC {
+		return nil, nil
+	}
+	dst := d.Profile
+	if dst == nil {
+		dst = icc.SRGB
+	}
+
+	raw, err := icc.Extract(data)
+	if err != nil {
+		return nil, err
+	}
+	src := icc.SRGB
+	if raw != nil {
+		if p, err := icc.Parse(raw); err == nil {
+			src = p
+		}
+	}
+	if src == dst {
+		return nil, nil
+	}
+	return icc.NewTransform(src, dst), nil
+}
+
+// shift returns the reduction an image of the given format and dimensions
+// should be decoded at, see Limits.Downsample and Decoder.Size.
+func (d *Decoder) shift(name string, w, h int) (uint, error) {
+	_, scaled := format.LookupScaled(name)
+	var shift uint
+	if err := d.Limits.CheckPixels(w, h); err != nil {
+		if !d.Limits.Downsample || !scaled {
+			return 0, err
+		}
+		shift = d.Limits.Shift(w, h)
+	}
+
+	if !scaled || d.Size.X <= 0 || d.Size.Y <= 0 {
+		return shift, nil
+	}
+	for w>>(shift+1) >= d.Size.X && h>>(shift+1) >= d.Size.Y {
+		shift++
+	}
+	return shift, nil
+}
+
+func (d *Decoder) vector(v format.Vector) (Image, error) {
+	w, h := v.Size()
+	err := d.Limits.CheckPixels(int(math.Ceil(w)), int(math.Ceil(h)))
+	if err == nil {
+		return NewVectorImage(v), nil
+	}
+	if !d.Limits.Downsample {
+		return nil, err
+	}
+
+	f := math.Sqrt(float64(d.Limits.MaxPixels) / (w * h))
+	return NewVectorImage(scaledVector{v, f}), nil
+}
+
+// scaledVector scales the intrinsic size of a Vector.
+type scaledVector struct {
+	format.Vector
+	f float64
+}
+
+func (s scaledVector) Size() (w, h float64) {
+	w, h = s.Vector.Size()
+	return math.Floor(w * s.f), math.Floor(h * s.f)
+}
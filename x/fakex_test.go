@@ -0,0 +1,401 @@
+package x
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+func init() {
+	// Connecting to the fake server logs missing authority info.
+	xgb.Logger = log.New(io.Discard, "", 0)
+}
+
+// Core requests counted by fakeX.
+const (
+	reqCreateWindow           = 1
+	reqChangeWindowAttributes = 2
+	reqDestroyWindow          = 4
+	reqMapWindow              = 8
+	reqUnmapWindow            = 10
+	reqConfigureWindow        = 12
+	reqCreatePixmap           = 53
+	reqFreePixmap             = 54
+	reqCreateGC               = 55
+	reqFreeGC                 = 60
+	reqCopyArea               = 62
+	reqPutImage               = 72
+)
+
+// RENDER requests counted by fakeX, offset by the major opcode of the
+// extension.
+const (
+	renderOpcode = 140
+
+	reqCreatePicture       = renderOpcode<<8 | 4
+	reqFreePicture         = renderOpcode<<8 | 7
+	reqComposite           = renderOpcode<<8 | 8
+	reqSetPictureTransform = renderOpcode<<8 | 28
+)
+
+// fakeX is an X server that accepts every request and counts them. It
+// reports extensions as missing unless enabled and answers the requests
+// that expect a reply with a generic error, except GetInputFocus which xgb
+// uses to synchronize, QueryVersion of enabled extensions and
+// GetWindowAttributes of known windows.
+type fakeX struct {
+	conn net.Conn
+	mu   sync.Mutex
+	reqs map[int]int
+	ext  map[string]byte
+
+	// children of each window from bottom to top, maintained by
+	// CreateWindow, DestroyWindow and ConfigureWindow.
+	children map[uint32][]uint32
+	parents  map[uint32]uint32
+
+	// windows created with CreateWindow or added with addWindow.
+	windows map[uint32]fakeWindow
+}
+
+// fakeWindow is the visual of a window known to fakeX and the value mask
+// of the request that created it.
+type fakeWindow struct {
+	depth    byte
+	visual   xproto.Visualid
+	colormap xproto.Colormap
+	mask     uint32
+}
+
+// addWindow makes wnd known so GetWindowAttributes reports its visual and
+// colormap.
+func (f *fakeX) addWindow(wnd xproto.Window, w fakeWindow) {
+	f.mu.Lock()
+	f.windows[uint32(wnd)] = w
+	f.mu.Unlock()
+}
+
+// window returns the window wnd after waiting for all pending requests.
+func (f *fakeX) window(t *TermWindow, wnd xproto.Window) (fakeWindow, bool) {
+	t.x.Sync()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, ok := f.windows[uint32(wnd)]
+	return w, ok
+}
+
+// enable makes the extension with the given name available using opcode
+// as its major opcode.
+func (f *fakeX) enable(name string, opcode byte) {
+	f.mu.Lock()
+	f.ext[name] = opcode
+	f.mu.Unlock()
+}
+
+// newFakeX returns a TermWindow connected to a fake X server with a single
+// screen of the given depths, the first visual of the first depth is the
+// root visual.
+func newFakeX(t *testing.T, formats []xproto.Format, depths ...xproto.DepthInfo) (*TermWindow, *fakeX) {
+	client, server := net.Pipe()
+	f := &fakeX{
+		conn:     server,
+		reqs:     make(map[int]int),
+		ext:      make(map[string]byte),
+		children: make(map[uint32][]uint32),
+		parents:  make(map[uint32]uint32),
+		windows:  make(map[uint32]fakeWindow),
+	}
+
+	for i := range depths {
+		depths[i].VisualsLen = uint16(len(depths[i].Visuals))
+	}
+	vendor := "zug"
+	setup := xproto.SetupInfo{
+		Status:                   1,
+		ProtocolMajorVersion:     11,
+		ResourceIdBase:           0x200000,
+		ResourceIdMask:           0x1fffff,
+		VendorLen:                uint16(len(vendor)),
+		MaximumRequestLength:     0xffff,
+		RootsLen:                 1,
+		PixmapFormatsLen:         byte(len(formats)),
+		BitmapFormatScanlineUnit: 32,
+		BitmapFormatScanlinePad:  32,
+		MinKeycode:               8,
+		MaxKeycode:               255,
+		Vendor:                   vendor,
+		PixmapFormats:            formats,
+		Roots: []xproto.ScreenInfo{{
+			Root:             1,
+			DefaultColormap:  2,
+			WidthInPixels:    1920,
+			HeightInPixels:   1080,
+			RootVisual:       depths[0].Visuals[0].VisualId,
+			RootDepth:        depths[0].Depth,
+			AllowedDepthsLen: byte(len(depths)),
+			AllowedDepths:    depths,
+		}},
+	}
+	b := setup.Bytes()
+	xgb.Put16(b[6:], uint16((len(b)-8)/4))
+	go f.serve(b)
+
+	c, err := xgb.NewConnNet(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := &TermWindow{x: c, wnd: 3, windows: make(map[string]*SubWindow)}
+	t.Cleanup(func() {
+		tw.Close()
+		server.Close()
+	})
+	return tw, f
+}
+
+// fakeDepth24 is the depth and pixmap formats of a typical 24-bit
+// TrueColor screen.
+func fakeDepth24() (xproto.DepthInfo, []xproto.Format) {
+	return xproto.DepthInfo{
+		Depth: 24,
+		Visuals: []xproto.VisualInfo{{
+			VisualId:        0x21,
+			Class:           xproto.VisualClassTrueColor,
+			BitsPerRgbValue: 8,
+			ColormapEntries: 256,
+			RedMask:         0xff0000,
+			GreenMask:       0xff00,
+			BlueMask:        0xff,
+		}},
+	}, []xproto.Format{
+		{Depth: 1, BitsPerPixel: 1, ScanlinePad: 32},
+		{Depth: 24, BitsPerPixel: 32, ScanlinePad: 32},
+		{Depth: 32, BitsPerPixel: 32, ScanlinePad: 32},
+	}
+}
+
+// fakeDepth32 is the ARGB visual of fakeDepth24 screens.
+func fakeDepth32() xproto.DepthInfo {
+	return xproto.DepthInfo{
+		Depth: 32,
+		Visuals: []xproto.VisualInfo{{
+			VisualId:        0x41,
+			Class:           xproto.VisualClassTrueColor,
+			BitsPerRgbValue: 8,
+			ColormapEntries: 256,
+			RedMask:         0xff0000,
+			GreenMask:       0xff00,
+			BlueMask:        0xff,
+		}},
+	}
+}
+
+func (f *fakeX) serve(setup []byte) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f.conn, head); err != nil {
+		return
+	}
+	auth := xgb.Pad(int(xgb.Get16(head[6:]))) + xgb.Pad(int(xgb.Get16(head[8:])))
+	if _, err := io.ReadFull(f.conn, make([]byte, auth)); err != nil {
+		return
+	}
+	if _, err := f.conn.Write(setup); err != nil {
+		return
+	}
+
+	var seq uint16
+	buf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(f.conn, buf[:4]); err != nil {
+			return
+		}
+		seq++
+		op, minor := int(buf[0]), int(buf[1])
+		n := int(xgb.Get16(buf[2:]))*4 - 4
+		if n < 0 {
+			// BIG-REQUESTS length.
+			if _, err := io.ReadFull(f.conn, buf[:4]); err != nil {
+				return
+			}
+			n = int(xgb.Get32(buf))*4 - 8
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(f.conn, body); err != nil {
+			return
+		}
+
+		key := op
+		if op >= 128 {
+			key = op<<8 | minor
+		}
+		f.mu.Lock()
+		f.reqs[key]++
+		var ext byte
+		var attr *fakeWindow
+		switch op {
+		case 98:
+			ext = f.ext[string(body[4:4+xgb.Get16(body)])]
+		case reqCreateWindow:
+			wnd, parent := xgb.Get32(body), xgb.Get32(body[4:])
+			f.parents[wnd] = parent
+			f.children[parent] = append(f.children[parent], wnd)
+			f.windows[wnd] = createdWindow(byte(minor), body)
+		case 3: // GetWindowAttributes
+			if w, ok := f.windows[xgb.Get32(body)]; ok {
+				attr = &w
+			}
+		case reqDestroyWindow:
+			f.unstack(xgb.Get32(body))
+		case reqConfigureWindow:
+			f.configure(body)
+		}
+		f.mu.Unlock()
+
+		reply := make([]byte, 32)
+		xgb.Put16(reply[2:], seq)
+		switch {
+		case attr != nil:
+			reply = append(reply, make([]byte, 12)...)
+			reply[0] = 1
+			xgb.Put32(reply[4:], 3)
+			xgb.Put32(reply[8:], uint32(attr.visual))
+			xgb.Put16(reply[12:], xproto.WindowClassInputOutput)
+			xgb.Put32(reply[28:], uint32(attr.colormap))
+		case op == 43: // GetInputFocus
+			reply[0] = 1
+		case op == 98: // QueryExtension
+			reply[0] = 1
+			if ext != 0 {
+				reply[8], reply[9] = 1, ext
+			}
+		case op >= 128 && minor == 0: // QueryVersion
+			reply[0] = 1
+		case op == 3, op == 14, op == 16, op == 20:
+			// GetWindowAttributes, GetGeometry, InternAtom, GetProperty
+			reply[0], reply[1] = 0, xproto.BadImplementation
+			reply[10] = byte(op)
+		default:
+			continue
+		}
+		if _, err := f.conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// createdWindow returns the window created by a CreateWindow request
+// body.
+func createdWindow(depth byte, body []byte) fakeWindow {
+	w := fakeWindow{
+		depth:  depth,
+		visual: xproto.Visualid(xgb.Get32(body[20:])),
+		mask:   xgb.Get32(body[24:]),
+	}
+	values := body[28:]
+	for bit := uint32(1); bit <= xproto.CwColormap; bit <<= 1 {
+		if w.mask&bit == 0 {
+			continue
+		}
+		if bit == xproto.CwColormap {
+			w.colormap = xproto.Colormap(xgb.Get32(values))
+		}
+		values = values[4:]
+	}
+	return w
+}
+
+// count returns the number of requests of each of the given kinds received
+// since the last call, after waiting for all pending requests.
+func (f *fakeX) count(t *TermWindow, kinds ...int) map[int]int {
+	t.x.Sync()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := make(map[int]int, len(kinds))
+	for _, k := range kinds {
+		m[k] = f.reqs[k]
+	}
+	f.reqs = make(map[int]int)
+	return m
+}
+
+// unstack removes wnd from the children of its parent and returns its
+// former index, -1 if unknown.
+func (f *fakeX) unstack(wnd uint32) int {
+	parent, ok := f.parents[wnd]
+	if !ok {
+		return -1
+	}
+	l := f.children[parent]
+	for i, c := range l {
+		if c == wnd {
+			f.children[parent] = append(l[:i], l[i+1:]...)
+			return i
+		}
+	}
+	return -1
+}
+
+// configure applies the sibling and stack mode of a ConfigureWindow
+// request body. Only the Above and Below modes are supported.
+func (f *fakeX) configure(body []byte) {
+	wnd, mask := xgb.Get32(body), xgb.Get16(body[4:])
+	var sibling uint32
+	mode := -1
+	values := body[8:]
+	for bit := uint16(1); bit <= xproto.ConfigWindowStackMode; bit <<= 1 {
+		if mask&bit == 0 {
+			continue
+		}
+		switch bit {
+		case xproto.ConfigWindowSibling:
+			sibling = xgb.Get32(values)
+		case xproto.ConfigWindowStackMode:
+			mode = int(xgb.Get32(values))
+		}
+		values = values[4:]
+	}
+	if mode != xproto.StackModeAbove && mode != xproto.StackModeBelow {
+		return
+	}
+	if f.unstack(wnd) == -1 {
+		return
+	}
+
+	parent := f.parents[wnd]
+	l := f.children[parent]
+	i := len(l)
+	if mode == xproto.StackModeBelow {
+		i = 0
+	}
+	if sibling != 0 {
+		for j, c := range l {
+			if c == sibling {
+				i = j
+				if mode == xproto.StackModeAbove {
+					i++
+				}
+			}
+		}
+	}
+	l = append(l, 0)
+	copy(l[i+1:], l[i:])
+	l[i] = wnd
+	f.children[parent] = l
+}
+
+// stacking returns the children of parent from bottom to top after
+// waiting for all pending requests.
+func (f *fakeX) stacking(t *TermWindow, parent xproto.Window) []xproto.Window {
+	t.x.Sync()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := make([]xproto.Window, len(f.children[uint32(parent)]))
+	for i, c := range f.children[uint32(parent)] {
+		l[i] = xproto.Window(c)
+	}
+	return l
+}
@@ -0,0 +1,286 @@
+package x
+
+import (
+	"image"
+	"math"
+)
+
+// Filter adjusts the pixels of an already scaled image in place.
+type Filter interface {
+	Filter(img *BGRA)
+}
+
+// FilterFunc is a func implementing Filter.
+type FilterFunc func(img *BGRA)
+
+func (f FilterFunc) Filter(img *BGRA) { f(img) }
+
+// Filters applies each Filter in order.
+type Filters []Filter
+
+func (f Filters) Filter(img *BGRA) {
+	for _, filter := range f {
+		filter.Filter(img)
+	}
+}
+
+// filterImage applies filters to the BGRA of an Image after it has been
+// resized.
+type filterImage struct {
+	Image
+	filter Filter
+	src    *BGRA
+	out    *BGRA
+}
+
+// NewFilterImage wraps img so that BGRA returns a copy with the given
+// filters applied. The result is cached until img is resized.
+func NewFilterImage(img Image, filters ...Filter) Image {
+	return &filterImage{Image: img, filter: Filters(filters)}
+}
+
+func (f *filterImage) BGRA() *BGRA {
+	src := f.Image.BGRA()
+	if f.out == nil || f.src != src {
+		f.src = src
+		f.out = applyFilter(src, f.filter)
+	}
+	return f.out
+}
+
+// applyFilter returns a filtered copy of img.
+func applyFilter(img *BGRA, f Filter) *BGRA {
+	out := NewBGRA(img.Rect)
+	BGRACopy(out, img, img.Rect)
+	f.Filter(out)
+	return out
+}
+
+// lutFilter maps each unpremultiplied color channel through lut.
+func lutFilter(lut *[256]uint8) Filter {
+	return FilterFunc(func(img *BGRA) {
+		rows(img, func(p []byte) {
+			for i := 0; i < len(p); i += 4 {
+				a := p[i+3]
+				switch a {
+				case 0:
+				case 255:
+					p[i], p[i+1], p[i+2] = lut[p[i]], lut[p[i+1]], lut[p[i+2]]
+				default:
+					for c := i; c < i+3; c++ {
+						v := (uint32(p[c])*255 + uint32(a)/2) / uint32(a)
+						if v > 255 {
+							v = 255
+						}
+						p[c] = uint8((uint32(lut[v])*uint32(a) + 127) / 255)
+					}
+				}
+			}
+		})
+	})
+}
+
+func newLUT(fn func(v float64) float64) *[256]uint8 {
+	var lut [256]uint8
+	for i := range lut {
+		v := math.Round(fn(float64(i)/255) * 255)
+		lut[i] = uint8(math.Max(0, math.Min(255, v)))
+	}
+	return &lut
+}
+
+// rows calls fn for each row of img in parallel.
+func rows(img *BGRA, fn func(p []byte)) {
+	b := img.Rect
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := img.PixOffset(b.Min.X, y)
+			fn(img.Pix[o : o+4*b.Dx()])
+		}
+	})
+}
+
+// Brightness adds v, in the range [-1, 1], to each channel.
+func Brightness(v float64) Filter {
+	return lutFilter(newLUT(func(c float64) float64 { return c + v }))
+}
+
+// Contrast scales the distance of each channel from the midpoint by v,
+// 1 leaving the image unchanged.
+func Contrast(v float64) Filter {
+	return lutFilter(newLUT(func(c float64) float64 { return (c-0.5)*v + 0.5 }))
+}
+
+// Gamma applies gamma correction, values above 1 brighten the image.
+func Gamma(g float64) Filter {
+	return lutFilter(newLUT(func(c float64) float64 { return math.Pow(c, 1/g) }))
+}
+
+// Invert inverts all colors.
+func Invert() Filter {
+	return lutFilter(newLUT(func(c float64) float64 { return 1 - c }))
+}
+
+// colorMatrix multiplies each pixel by m, which, having no offset, can be
+// applied to premultiplied colors directly.
+func colorMatrix(m [3][3]float64) Filter {
+	var fm [3][3]int32
+	for i := range m {
+		for j := range m[i] {
+			fm[i][j] = int32(math.Round(m[i][j] * 1024))
+		}
+	}
+
+	return FilterFunc(func(img *BGRA) {
+		rows(img, func(p []byte) {
+			for i := 0; i < len(p); i += 4 {
+				b, g, r, a := int32(p[i]), int32(p[i+1]), int32(p[i+2]), int32(p[i+3])
+				for c, m := range fm {
+					v := (m[0]*r + m[1]*g + m[2]*b + 512) >> 10
+					if v < 0 {
+						v = 0
+					} else if v > a {
+						v = a
+					}
+					p[i+2-c] = uint8(v)
+				}
+			}
+		})
+	})
+}
+
+// Saturation scales the saturation by s, 0 resulting in grayscale and 1
+// leaving the image unchanged.
+func Saturation(s float64) Filter {
+	const lr, lg, lb = 0.2126, 0.7152, 0.0722
+	return colorMatrix([3][3]float64{
+		{lr + (1-lr)*s, lg - lg*s, lb - lb*s},
+		{lr - lr*s, lg + (1-lg)*s, lb - lb*s},
+		{lr - lr*s, lg - lg*s, lb + (1-lb)*s},
+	})
+}
+
+// Grayscale removes all color.
+func Grayscale() Filter { return Saturation(0) }
+
+// Sepia applies a sepia tone.
+func Sepia() Filter {
+	return colorMatrix([3][3]float64{
+		{0.393, 0.769, 0.189},
+		{0.349, 0.686, 0.168},
+		{0.272, 0.534, 0.131},
+	})
+}
+
+// Pixelate replaces each size x size block with its average color.
+func Pixelate(size int) Filter {
+	return FilterFunc(func(img *BGRA) {
+		if size < 2 {
+			return
+		}
+		b := img.Rect
+		blocks := image.Rect(0, 0, b.Dx()*size, (b.Dy()+size-1)/size)
+		parallelRows(blocks, func(y0, y1 int) {
+			for by := b.Min.Y + y0*size; by < b.Min.Y+y1*size && by < b.Max.Y; by += size {
+				ey := by + size
+				if ey > b.Max.Y {
+					ey = b.Max.Y
+				}
+				for bx := b.Min.X; bx < b.Max.X; bx += size {
+					ex := bx + size
+					if ex > b.Max.X {
+						ex = b.Max.X
+					}
+					pixelate(img, image.Rect(bx, by, ex, ey))
+				}
+			}
+		})
+	})
+}
+
+func pixelate(img *BGRA, r image.Rectangle) {
+	var sum [4]uint32
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i, v := range p {
+			sum[i&3] += uint32(v)
+		}
+	}
+	n := uint32(r.Dx() * r.Dy())
+	var avg [4]uint8
+	for i := range avg {
+		avg[i] = uint8((sum[i] + n/2) / n)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i := range p {
+			p[i] = avg[i&3]
+		}
+	}
+}
+
+// Blur approximates a gaussian blur with the given radius using three box
+// blur passes.
+func Blur(radius int) Filter {
+	return FilterFunc(func(img *BGRA) {
+		if radius < 1 {
+			return
+		}
+		r := radius / 2
+		if r < 1 {
+			r = 1
+		}
+		tmp := NewBGRA(img.Rect)
+		for i := 0; i < 3; i++ {
+			boxBlur(tmp, img, r, false)
+			boxBlur(img, tmp, r, true)
+		}
+	})
+}
+
+// boxBlur blurs src into dst, which have the same bounds, horizontally or
+// vertically if vert is set. Edges are extended.
+func boxBlur(dst, src *BGRA, r int, vert bool) {
+	b := src.Rect
+	lines, length := b.Dy(), b.Dx()
+	step, lineStep := 4, src.Stride
+	dstStep, dstLineStep := 4, dst.Stride
+	if vert {
+		lines, length = length, lines
+		step, lineStep = lineStep, 4
+		dstStep, dstLineStep = dstLineStep, 4
+	}
+	n := uint32(2*r + 1)
+
+	parallelRows(image.Rect(0, 0, length, lines), func(l0, l1 int) {
+		for l := l0; l < l1; l++ {
+			base, dstBase := l*lineStep, l*dstLineStep
+			at := func(i int) int {
+				if i < 0 {
+					i = 0
+				} else if i >= length {
+					i = length - 1
+				}
+				return base + i*step
+			}
+
+			var sum [4]uint32
+			for i := -r; i <= r; i++ {
+				o := at(i)
+				for c := 0; c < 4; c++ {
+					sum[c] += uint32(src.Pix[o+c])
+				}
+			}
+			for i := 0; i < length; i++ {
+				o := dstBase + i*dstStep
+				for c := 0; c < 4; c++ {
+					dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+				}
+				add, sub := at(i+r+1), at(i-r)
+				for c := 0; c < 4; c++ {
+					sum[c] += uint32(src.Pix[add+c]) - uint32(src.Pix[sub+c])
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package x
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// row returns a BGRA of a single row of the given premultiplied colors.
+func row(c ...color.RGBA) *BGRA {
+	img := NewBGRA(image.Rect(0, 0, len(c), 1))
+	for x, c := range c {
+		img.SetRGBA(x, 0, c)
+	}
+	return img
+}
+
+func gray(v uint8) color.RGBA { return color.RGBA{v, v, v, 255} }
+
+func TestFilters(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		filter   Filter
+		src, exp []color.RGBA
+	}{
+		{
+			"brightness", Brightness(0.2),
+			[]color.RGBA{{100, 150, 200, 255}, {250, 0, 0, 255}, {0, 0, 0, 0}},
+			[]color.RGBA{{151, 201, 251, 255}, {255, 51, 51, 255}, {0, 0, 0, 0}},
+		},
+		{
+			"contrast", Contrast(1.5),
+			[]color.RGBA{{100, 150, 20, 255}},
+			[]color.RGBA{{86, 161, 0, 255}},
+		},
+		{
+			"gamma", Gamma(2),
+			[]color.RGBA{{64, 0, 255, 255}},
+			[]color.RGBA{{128, 0, 255, 255}},
+		},
+		{
+			"invert", Invert(),
+			// Half transparent white is unpremultiplied before inverting.
+			[]color.RGBA{{10, 200, 255, 255}, {64, 64, 64, 128}},
+			[]color.RGBA{{245, 55, 0, 255}, {64, 64, 64, 128}},
+		},
+		{
+			"saturation 1", Saturation(1),
+			[]color.RGBA{{10, 200, 255, 255}, {10, 20, 30, 40}},
+			[]color.RGBA{{10, 200, 255, 255}, {10, 20, 30, 40}},
+		},
+		{
+			"grayscale", Grayscale(),
+			[]color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}},
+			[]color.RGBA{gray(54), gray(182), gray(18)},
+		},
+		{
+			"sepia", Sepia(),
+			[]color.RGBA{gray(100), gray(255)},
+			[]color.RGBA{{135, 120, 94, 255}, {255, 255, 239, 255}},
+		},
+		{
+			"pixelate", Pixelate(2),
+			[]color.RGBA{gray(10), gray(21), gray(100)},
+			[]color.RGBA{gray(16), gray(16), gray(100)},
+		},
+		{
+			"blur", Blur(2),
+			[]color.RGBA{gray(0), gray(0), gray(255), gray(0), gray(0), gray(0), gray(0)},
+			[]color.RGBA{gray(38), gray(57), gray(66), gray(57), gray(28), gray(9), gray(0)},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			img := row(c.src...)
+			c.filter.Filter(img)
+			for x, exp := range c.exp {
+				if got := img.RGBAAt(x, 0); got != exp {
+					t.Errorf("pixel %d: %v, expected %v", x, got, exp)
+				}
+			}
+		})
+	}
+}
+
+// TestFilterSubImage checks filters only touch the bounds of a BGRA whose
+// stride differs from its width.
+func TestFilterSubImage(t *testing.T) {
+	for _, f := range []Filter{Blur(3), Pixelate(3), Invert(), Sepia()} {
+		t.Run(fmt.Sprintf("%T", f), func(t *testing.T) {
+			full := NewBGRA(image.Rect(0, 0, 16, 12))
+			for i := range full.Pix {
+				full.Pix[i] = uint8(i * 31)
+			}
+			for i := 3; i < len(full.Pix); i += 4 {
+				full.Pix[i] = 255
+			}
+			r := image.Rect(3, 2, 11, 9)
+			sub := full.SubImage(r).(*BGRA)
+			exp := NewBGRA(r)
+			BGRACopy(exp, sub, r)
+			orig := append([]byte{}, full.Pix...)
+
+			f.Filter(exp)
+			f.Filter(sub)
+			for y := 0; y < 12; y++ {
+				for x := 0; x < 16; x++ {
+					o := full.PixOffset(x, y)
+					got := full.Pix[o : o+4]
+					want := orig[o : o+4]
+					if (image.Point{x, y}.In(r)) {
+						e := exp.PixOffset(x, y)
+						want = exp.Pix[e : e+4]
+					}
+					if !bytes.Equal(got, want) {
+						t.Fatalf("pixel %d,%d: %v, expected %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFilterImage(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(rgba, rgba.Rect, image.NewUniform(gray(100)), image.Point{}, draw.Src)
+	src := NewImage(rgba)
+	img := NewFilterImage(src, Invert(), Brightness(-0.2))
+	out := img.BGRA()
+	if got, exp := out.RGBAAt(1, 1), gray(104); got != exp {
+		t.Errorf("pixel %v, expected %v", got, exp)
+	}
+	if src.BGRA() == out {
+		t.Fatal("source was filtered in place")
+	}
+	if img.BGRA() != out {
+		t.Error("result was not cached")
+	}
+}
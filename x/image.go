@@ -1,14 +1,24 @@
 package x
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"io"
+	"math"
 
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 
+	"github.com/frizinak/zug/format"
+	_ "github.com/frizinak/zug/format/farbfeld"
+	_ "github.com/frizinak/zug/format/ico"
+	_ "github.com/frizinak/zug/format/jpeg"
+	_ "github.com/frizinak/zug/format/pnm"
+	_ "github.com/frizinak/zug/format/qoi"
+	_ "github.com/frizinak/zug/format/svg"
+	_ "github.com/frizinak/zug/format/tiff"
 	_ "golang.org/x/image/bmp"
 	"golang.org/x/image/draw"
 	_ "golang.org/x/image/webp"
@@ -21,18 +31,23 @@ type Image interface {
 	BGRA() *BGRA
 }
 
+// Pager is implemented by Images that consist of multiple pages, e.g.:
+// multi-page tiffs.
+type Pager interface {
+	// Pages returns the total amount of pages.
+	Pages() int
+	// Page returns the zero-based index of the current page.
+	Page() int
+	// SetPage decodes and selects the given zero-based page.
+	SetPage(page int) error
+}
+
 type nativeImage struct {
 	in  *BGRA
 	out *BGRA
-}
-
-func ImageRead(r io.Reader) (Image, error) {
-	_img, _, err := image.Decode(r)
-	if err != nil {
-		return nil, err
-	}
 
-	return NewImage(_img), nil
+	// mipmaps[i] is in reduced by a factor of 2<<i, built on demand.
+	mipmaps []*BGRA
 }
 
 func NewImage(i image.Image) Image {
@@ -45,18 +60,57 @@ func (n *nativeImage) Reset() {
 	n.out = n.in
 }
 
+// Resize scales from the smallest mipmap level that is at least w x h.
 func (n *nativeImage) Resize(w, h int) {
+	src := n.Level(w, h)
 	n.out = NewBGRA(image.Rect(0, 0, w, h))
+	if src.Rect.Dx() == w && src.Rect.Dy() == h {
+		copy(n.out.Pix, src.Pix)
+		return
+	}
+
 	draw.ApproxBiLinear.Scale(
 		n.out,
 		n.out.Bounds(),
-		n.in,
-		n.in.Bounds(),
+		src,
+		src.Bounds(),
 		draw.Src,
 		nil,
 	)
 }
 
+// levelImage is implemented by Images that keep copies of themselves
+// reduced by powers of two.
+type levelImage interface {
+	// Level returns the smallest reduced copy that is at least w x h, the
+	// image itself if there is none.
+	Level(w, h int) *BGRA
+}
+
+// imageLevel returns a mipmap level of img, see levelImage, or img at full
+// resolution if it has none.
+func imageLevel(img Image, w, h int) *BGRA {
+	if l, ok := img.(levelImage); ok {
+		return l.Level(w, h)
+	}
+	img.Reset()
+	return img.BGRA()
+}
+
+func (n *nativeImage) Level(w, h int) *BGRA {
+	src := n.in
+	for i := 0; ; i++ {
+		sw, sh := (src.Rect.Dx()+1)/2, (src.Rect.Dy()+1)/2
+		if sw < w || sh < h || sw == src.Rect.Dx() && sh == src.Rect.Dy() {
+			return src
+		}
+		if i == len(n.mipmaps) {
+			n.mipmaps = append(n.mipmaps, halveBGRA(src))
+		}
+		src = n.mipmaps[i]
+	}
+}
+
 func (n *nativeImage) BGRA() *BGRA {
 	if n.out == nil {
 		return n.in
@@ -64,50 +118,124 @@ func (n *nativeImage) BGRA() *BGRA {
 	return n.out
 }
 
-func floatMinMax(v, min, max float32) float32 {
-	if v < min {
-		return min
+type vectorImage struct {
+	v    format.Vector
+	rect image.Rectangle
+	out  *BGRA
+}
+
+// NewVectorImage creates an Image that rasterizes v at exactly the size
+// passed to Resize instead of resampling pixels.
+func NewVectorImage(v format.Vector) Image {
+	w, h := v.Size()
+	return &vectorImage{
+		v:    v,
+		rect: image.Rect(0, 0, int(math.Ceil(w)), int(math.Ceil(h))),
+	}
+}
+
+func (v *vectorImage) Bounds() image.Rectangle { return v.rect }
+
+func (v *vectorImage) Reset() {
+	if v.out != nil && v.out.Rect.Size() != v.rect.Size() {
+		v.out = nil
 	}
-	if v > max {
-		return max
+}
+
+func (v *vectorImage) Resize(w, h int) {
+	if v.out != nil && v.out.Rect.Dx() == w && v.out.Rect.Dy() == h {
+		return
+	}
+	v.out = ImageToBGRA(v.v.Rasterize(w, h))
+}
+
+func (v *vectorImage) BGRA() *BGRA {
+	if v.out == nil {
+		v.Resize(v.rect.Dx(), v.rect.Dy())
 	}
-	return v
+	return v.out
 }
 
+type pagedImage struct {
+	Image
+	d     Decoder
+	r     io.ReaderAt
+	data  []byte
+	dec   format.Paged
+	pages int
+	page  int
+}
+
+func newPagedImage(
+	d *Decoder,
+	r io.ReaderAt,
+	data []byte,
+	dec format.Paged,
+	pages int,
+) (*pagedImage, error) {
+	p := &pagedImage{
+		d:     *d,
+		r:     r,
+		data:  data,
+		dec:   dec,
+		pages: pages,
+		page:  -1,
+	}
+	return p, p.SetPage(0)
+}
+
+func (p *pagedImage) Level(w, h int) *BGRA { return imageLevel(p.Image, w, h) }
+
+func (p *pagedImage) Pages() int { return p.pages }
+func (p *pagedImage) Page() int  { return p.page }
+
+func (p *pagedImage) SetPage(page int) error {
+	if page < 0 || page >= p.pages {
+		return fmt.Errorf("page %d out of range [0, %d)", page, p.pages)
+	}
+	if page == p.page {
+		return nil
+	}
+
+	img, err := p.d.page(p.dec, p.r, p.data, page)
+	if err != nil {
+		return format.WrapError(p.data, err)
+	}
+	p.Image = img
+	p.page = page
+	return nil
+}
+
+// halveBGRA returns src reduced by a factor of two using a box filter.
+func halveBGRA(src *BGRA) *BGRA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := NewBGRA(image.Rect(0, 0, (sw+1)/2, (sh+1)/2))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		r0 := src.Pix[2*y*src.Stride:]
+		r1 := r0
+		if 2*y+1 < sh {
+			r1 = src.Pix[(2*y+1)*src.Stride:]
+		}
+		d := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			o0, o1 := 8*x, 8*x+4
+			if 2*x+1 >= sw {
+				o1 = o0
+			}
+			for c := 0; c < 4; c++ {
+				v := uint(r0[o0+c]) + uint(r0[o1+c]) + uint(r1[o0+c]) + uint(r1[o1+c])
+				d[4*x+c] = uint8((v + 2) >> 2)
+			}
+		}
+	}
+	return dst
+}
+
+// ImageToBGRA converts i to a new BGRA with the same bounds, see Convert.
 func ImageToBGRA(i image.Image) *BGRA {
 	b := i.Bounds()
 	img := NewBGRA(b)
-
-	// Fast path
-	switch v := i.(type) {
-	case *image.RGBA:
-		RGBACopy(img, v, b)
-		return img
-	case *image.NRGBA:
-		NRGBACopy(img, v, b)
-		return img
-
-	case *image.RGBA64:
-		RGBA64Copy(img, v, b)
-		return img
-	case *image.NRGBA64:
-		NRGBA64Copy(img, v, b)
-		return img
-
-	case *image.Gray:
-		GrayCopy(img, v, b)
-		return img
-	case *image.Gray16:
-		Gray16Copy(img, v, b)
-		return img
-
-	case *image.YCbCr:
-		YCbCrCopy(img, v, b)
-		return img
-	}
-
-	// Slow path
-	draw.Draw(img, b, i, image.Point{}, draw.Src)
+	Convert(img, i, b)
 	return img
 }
 
@@ -132,7 +260,10 @@ func (i *BGRA) PixOffset(x, y int) int {
 	return (y-i.Rect.Min.Y)*i.Stride + (x-i.Rect.Min.X)*4
 }
 
-func (i *BGRA) At(x, y int) color.Color {
+// At returns the alpha-premultiplied color at x, y.
+func (i *BGRA) At(x, y int) color.Color { return i.RGBAAt(x, y) }
+
+func (i *BGRA) RGBAAt(x, y int) color.RGBA {
 	if !(image.Point{x, y}.In(i.Rect)) {
 		return color.RGBA{}
 	}
@@ -141,17 +272,48 @@ func (i *BGRA) At(x, y int) color.Color {
 	return color.RGBA{s[2], s[1], s[0], s[3]}
 }
 
+func (i *BGRA) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := i.RGBAAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// Set stores c converted to alpha-premultiplied 8-bit color.
 func (i *BGRA) Set(x, y int, c color.Color) {
+	i.SetRGBA(x, y, color.RGBAModel.Convert(c).(color.RGBA))
+}
+
+func (i *BGRA) SetRGBA(x, y int, c color.RGBA) {
 	if !(image.Point{x, y}.In(i.Rect)) {
 		return
 	}
 	o := i.PixOffset(x, y)
-	c1 := color.RGBAModel.Convert(c).(color.RGBA)
 	s := i.Pix[o : o+4 : o+4]
-	s[3] = c1.A
-	s[2] = c1.R
-	s[1] = c1.G
-	s[0] = c1.B
+	s[3] = c.A
+	s[2] = c.R
+	s[1] = c.G
+	s[0] = c.B
+}
+
+func (i *BGRA) SetRGBA64(x, y int, c color.RGBA64) {
+	i.SetRGBA(x, y, color.RGBA{
+		uint8(c.R >> 8),
+		uint8(c.G >> 8),
+		uint8(c.B >> 8),
+		uint8(c.A >> 8),
+	})
+}
+
+// Opaque reports whether all pixels are fully opaque.
+func (i *BGRA) Opaque() bool {
+	w := 4 * i.Rect.Dx()
+	for y, o := 0, 0; y < i.Rect.Dy(); y, o = y+1, o+i.Stride {
+		for x := 3; x < w; x += 4 {
+			if i.Pix[o+x] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 func (i *BGRA) SubImage(r image.Rectangle) image.Image {
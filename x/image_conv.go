@@ -2,107 +2,351 @@ package x
 
 import (
 	"image"
+	"image/color"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/draw"
 )
 
-// These functions only work for identical images (same Bounds) for a slight
-// performance increase.
+// These functions convert the pixels within b, which must lie within the
+// bounds of both dst and src. The results are identical to those of
+// draw.Draw(dst, b, src, b.Min, draw.Src). Large images are converted by
+// multiple goroutines, each handling a range of rows.
 
-func YCbCrCopy(dst *BGRA, src *image.YCbCr, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			yo := src.YOffset(x, y)
-			co := src.COffset(x, y)
-			o := dst.PixOffset(x, y)
-			_y := float32(src.Y[yo])
-			cr := float32(src.Cr[co]) - 128
-			cb := float32(src.Cb[co]) - 128
-			r := uint8(floatMinMax(_y+1.40200*cr, 0, 255))
-			g := uint8(floatMinMax(_y-0.34414*cb-0.71414*cr, 0, 255))
-			b := uint8(floatMinMax(_y+1.77200*cb, 0, 255))
-			dst.Pix[o+0] = b
-			dst.Pix[o+1] = g
-			dst.Pix[o+2] = r
-			dst.Pix[o+3] = 255
+// Convert converts the pixels of src within b to dst using the fast path for
+// the concrete type of src if there is one, all others with draw.Draw. In
+// all cases the result is identical to that of draw.Draw.
+func Convert(dst *BGRA, src image.Image, b image.Rectangle) {
+	switch v := src.(type) {
+	case *BGRA:
+		BGRACopy(dst, v, b)
+	case *image.RGBA:
+		RGBACopy(dst, v, b)
+	case *image.NRGBA:
+		NRGBACopy(dst, v, b)
+	case *image.RGBA64:
+		RGBA64Copy(dst, v, b)
+	case *image.NRGBA64:
+		NRGBA64Copy(dst, v, b)
+	case *image.Gray:
+		GrayCopy(dst, v, b)
+	case *image.Gray16:
+		Gray16Copy(dst, v, b)
+	case *image.Alpha:
+		AlphaCopy(dst, v, b)
+	case *image.YCbCr:
+		YCbCrCopy(dst, v, b)
+	case *image.NYCbCrA:
+		NYCbCrACopy(dst, v, b)
+	case *image.CMYK:
+		CMYKCopy(dst, v, b)
+	case *image.Paletted:
+		PalettedCopy(dst, v, b)
+	default:
+		draw.Draw(dst, b, src, b.Min, draw.Src)
+	}
+}
+
+// parallelMin is the amount of pixels below which rows are not split among
+// goroutines.
+const parallelMin = 1 << 16
+
+// parallelRows calls fn for consecutive ranges of rows covering b.
+func parallelRows(b image.Rectangle, fn func(y0, y1 int)) {
+	n := runtime.GOMAXPROCS(0)
+	if rows := b.Dy(); rows < n {
+		n = rows
+	}
+	if n <= 1 || b.Dx()*b.Dy() < parallelMin {
+		fn(b.Min.Y, b.Max.Y)
+		return
+	}
+
+	var wg sync.WaitGroup
+	per := (b.Dy() + n - 1) / n
+	for y := b.Min.Y; y < b.Max.Y; y += per {
+		y1 := y + per
+		if y1 > b.Max.Y {
+			y1 = b.Max.Y
 		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			fn(y0, y1)
+			wg.Done()
+		}(y, y1)
+	}
+	wg.Wait()
+}
+
+// ycbcr converts to 8-bit RGB the same way color.YCbCr.RGBA does, see
+// image/color for the derivation of the constants.
+func ycbcr(y, cb, cr uint8) (uint8, uint8, uint8) {
+	yy1 := int32(y) * 0x10101
+	cb1 := int32(cb) - 128
+	cr1 := int32(cr) - 128
+
+	r := yy1 + 91881*cr1
+	if uint32(r)&0xff000000 == 0 {
+		r >>= 16
+	} else {
+		r = ^(r >> 31)
+	}
+	g := yy1 - 22554*cb1 - 46802*cr1
+	if uint32(g)&0xff000000 == 0 {
+		g >>= 16
+	} else {
+		g = ^(g >> 31)
+	}
+	b := yy1 + 116130*cb1
+	if uint32(b)&0xff000000 == 0 {
+		b >>= 16
+	} else {
+		b = ^(b >> 31)
+	}
+
+	return uint8(r), uint8(g), uint8(b)
+}
+
+// ycbcr16 is ycbcr with 16-bit output.
+func ycbcr16(y, cb, cr uint8) (uint32, uint32, uint32) {
+	yy1 := int32(y) * 0x10101
+	cb1 := int32(cb) - 128
+	cr1 := int32(cr) - 128
+
+	r := yy1 + 91881*cr1
+	if uint32(r)&0xff000000 == 0 {
+		r >>= 8
+	} else {
+		r = ^(r >> 31) & 0xffff
+	}
+	g := yy1 - 22554*cb1 - 46802*cr1
+	if uint32(g)&0xff000000 == 0 {
+		g >>= 8
+	} else {
+		g = ^(g >> 31) & 0xffff
+	}
+	b := yy1 + 116130*cb1
+	if uint32(b)&0xff000000 == 0 {
+		b >>= 8
+	} else {
+		b = ^(b >> 31) & 0xffff
+	}
+
+	return uint32(r), uint32(g), uint32(b)
+}
+
+// chromaDiv returns the horizontal chroma subsampling factor of r.
+func chromaDiv(r image.YCbCrSubsampleRatio) int {
+	switch r {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		return 2
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return 4
 	}
+	return 1
+}
+
+func YCbCrCopy(dst *BGRA, src *image.YCbCr, b image.Rectangle) {
+	div := chromaDiv(src.SubsampleRatio)
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			yo := src.YOffset(b.Min.X, y)
+			co := src.COffset(b.Min.X, y) - b.Min.X/div
+			d := dst.Pix[o : o+4*b.Dx()]
+			for x := b.Min.X; x < b.Max.X; x, yo = x+1, yo+1 {
+				c := co + x/div
+				r, g, bl := ycbcr(src.Y[yo], src.Cb[c], src.Cr[c])
+				d[0], d[1], d[2], d[3] = bl, g, r, 255
+				d = d[4:]
+			}
+		}
+	})
+}
+
+func NYCbCrACopy(dst *BGRA, src *image.NYCbCrA, b image.Rectangle) {
+	div := chromaDiv(src.SubsampleRatio)
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			yo := src.YOffset(b.Min.X, y)
+			ao := src.AOffset(b.Min.X, y)
+			co := src.COffset(b.Min.X, y) - b.Min.X/div
+			d := dst.Pix[o : o+4*b.Dx()]
+			for x := b.Min.X; x < b.Max.X; x, yo, ao = x+1, yo+1, ao+1 {
+				c := co + x/div
+				r, g, bl := ycbcr16(src.Y[yo], src.Cb[c], src.Cr[c])
+				a := uint32(src.A[ao]) * 0x101
+				d[0] = uint8(bl * a / 0xffff >> 8)
+				d[1] = uint8(g * a / 0xffff >> 8)
+				d[2] = uint8(r * a / 0xffff >> 8)
+				d[3] = src.A[ao]
+				d = d[4:]
+			}
+		}
+	})
+}
+
+func BGRACopy(dst *BGRA, src *BGRA, b image.Rectangle) {
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			copy(dst.Pix[o:o+4*b.Dx()], src.Pix[src.PixOffset(b.Min.X, y):])
+		}
+	})
 }
 
 func GrayCopy(dst *BGRA, src *image.Gray, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			v := src.Pix[src.PixOffset(x, y)]
-			dst.Pix[o+0] = v
-			dst.Pix[o+1] = v
-			dst.Pix[o+2] = v
-			dst.Pix[o+3] = 255
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for i := 0; len(d) != 0; i++ {
+				v := s[i]
+				d[0], d[1], d[2], d[3] = v, v, v, 255
+				d = d[4:]
+			}
 		}
-	}
+	})
 }
 
 func Gray16Copy(dst *BGRA, src *image.Gray16, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			v := src.Pix[src.PixOffset(x, y)]
-			dst.Pix[o+0] = v
-			dst.Pix[o+1] = v
-			dst.Pix[o+2] = v
-			dst.Pix[o+3] = 255
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for i := 0; len(d) != 0; i += 2 {
+				v := s[i]
+				d[0], d[1], d[2], d[3] = v, v, v, 255
+				d = d[4:]
+			}
 		}
-	}
+	})
+}
+
+func AlphaCopy(dst *BGRA, src *image.Alpha, b image.Rectangle) {
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for i := 0; len(d) != 0; i++ {
+				v := s[i]
+				d[0], d[1], d[2], d[3] = v, v, v, v
+				d = d[4:]
+			}
+		}
+	})
 }
 
 func RGBACopy(dst *BGRA, src *image.RGBA, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			dst.Pix[o+0] = src.Pix[o+2]
-			dst.Pix[o+1] = src.Pix[o+1]
-			dst.Pix[o+2] = src.Pix[o+0]
-			dst.Pix[o+3] = src.Pix[o+3]
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for len(d) != 0 {
+				d[0], d[1], d[2], d[3] = s[2], s[1], s[0], s[3]
+				d, s = d[4:], s[4:]
+			}
 		}
-	}
+	})
 }
 
 func NRGBACopy(dst *BGRA, src *image.NRGBA, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			a := uint32(src.Pix[o+3])
-			dst.Pix[o+0] = uint8(a * (uint32(src.Pix[o+2]) << 8) / 0xffff)
-			dst.Pix[o+1] = uint8(a * (uint32(src.Pix[o+1]) << 8) / 0xffff)
-			dst.Pix[o+2] = uint8(a * (uint32(src.Pix[o+0]) << 8) / 0xffff)
-			dst.Pix[o+3] = src.Pix[o+3]
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for len(d) != 0 {
+				a := uint32(s[3]) * 0x101
+				d[0] = uint8(uint32(s[2]) * 0x101 * a / 0xffff >> 8)
+				d[1] = uint8(uint32(s[1]) * 0x101 * a / 0xffff >> 8)
+				d[2] = uint8(uint32(s[0]) * 0x101 * a / 0xffff >> 8)
+				d[3] = s[3]
+				d, s = d[4:], s[4:]
+			}
 		}
-	}
+	})
 }
 
 func RGBA64Copy(dst *BGRA, src *image.RGBA64, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			d := src.PixOffset(x, y)
-			dst.Pix[o+0] = src.Pix[d+4]
-			dst.Pix[o+1] = src.Pix[d+2]
-			dst.Pix[o+2] = src.Pix[d+0]
-			dst.Pix[o+3] = src.Pix[d+6]
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for len(d) != 0 {
+				d[0], d[1], d[2], d[3] = s[4], s[2], s[0], s[6]
+				d, s = d[4:], s[8:]
+			}
 		}
-	}
+	})
 }
 
 func NRGBA64Copy(dst *BGRA, src *image.NRGBA64, b image.Rectangle) {
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			o := dst.PixOffset(x, y)
-			d := src.PixOffset(x, y)
-			a := uint32(src.Pix[d+6])
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for len(d) != 0 {
+				a := uint32(s[6])<<8 | uint32(s[7])
+				r := uint32(s[0])<<8 | uint32(s[1])
+				g := uint32(s[2])<<8 | uint32(s[3])
+				bl := uint32(s[4])<<8 | uint32(s[5])
+				d[0] = uint8(bl * a / 0xffff >> 8)
+				d[1] = uint8(g * a / 0xffff >> 8)
+				d[2] = uint8(r * a / 0xffff >> 8)
+				d[3] = s[6]
+				d, s = d[4:], s[8:]
+			}
+		}
+	})
+}
 
-			dst.Pix[o+0] = uint8(a * (uint32(src.Pix[d+4]) << 8) / 0xffff)
-			dst.Pix[o+1] = uint8(a * (uint32(src.Pix[d+2]) << 8) / 0xffff)
-			dst.Pix[o+2] = uint8(a * (uint32(src.Pix[d+0]) << 8) / 0xffff)
+func CMYKCopy(dst *BGRA, src *image.CMYK, b image.Rectangle) {
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for len(d) != 0 {
+				w := 0xffff - uint32(s[3])*0x101
+				d[0] = uint8((0xffff - uint32(s[2])*0x101) * w / 0xffff >> 8)
+				d[1] = uint8((0xffff - uint32(s[1])*0x101) * w / 0xffff >> 8)
+				d[2] = uint8((0xffff - uint32(s[0])*0x101) * w / 0xffff >> 8)
+				d[3] = 255
+				d, s = d[4:], s[4:]
+			}
+		}
+	})
+}
 
-			dst.Pix[o+3] = src.Pix[d+6]
+func PalettedCopy(dst *BGRA, src *image.Paletted, b image.Rectangle) {
+	var lut [256][4]uint8
+	for i, c := range src.Palette {
+		if i == len(lut) {
+			break
 		}
+		r, g, bl, a := color.RGBAModel.Convert(c).RGBA()
+		lut[i] = [4]uint8{uint8(bl >> 8), uint8(g >> 8), uint8(r >> 8), uint8(a >> 8)}
 	}
+
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := dst.PixOffset(b.Min.X, y)
+			s := src.Pix[src.PixOffset(b.Min.X, y):]
+			d := dst.Pix[o : o+4*b.Dx()]
+			for i := 0; len(d) != 0; i++ {
+				c := &lut[s[i]]
+				d[0], d[1], d[2], d[3] = c[0], c[1], c[2], c[3]
+				d = d[4:]
+			}
+		}
+	})
 }
@@ -0,0 +1,254 @@
+package x
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// convCase creates a source image with random pixels of a type that has a
+// fast path in Convert.
+type convCase struct {
+	name string
+	new  func(r image.Rectangle, rnd *rand.Rand) image.Image
+}
+
+func randBytes(rnd *rand.Rand, b []byte) {
+	for i := range b {
+		b[i] = byte(rnd.Intn(256))
+	}
+}
+
+// premultiply clamps the color channels of n-byte pixels, stored big endian
+// with alpha last, to the alpha value.
+func premultiply(pix []byte, n int) {
+	w := n / 4
+	for i := 0; i+n <= len(pix); i += n {
+		a := pix[i+3*w : i+4*w]
+		for c := 0; c < 3; c++ {
+			v := pix[i+c*w : i+(c+1)*w]
+			if bytes.Compare(v, a) > 0 {
+				copy(v, a)
+			}
+		}
+	}
+}
+
+var convCases = []convCase{
+	{"BGRA", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := NewBGRA(r)
+		randBytes(rnd, img.Pix)
+		premultiply(img.Pix, 4)
+		return img
+	}},
+	{"RGBA", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewRGBA(r)
+		randBytes(rnd, img.Pix)
+		premultiply(img.Pix, 4)
+		return img
+	}},
+	{"NRGBA", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewNRGBA(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"RGBA64", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewRGBA64(r)
+		randBytes(rnd, img.Pix)
+		premultiply(img.Pix, 8)
+		return img
+	}},
+	{"NRGBA64", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewNRGBA64(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"Gray", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewGray(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"Gray16", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewGray16(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"Alpha", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewAlpha(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"Alpha16", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewAlpha16(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"CMYK", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		img := image.NewCMYK(r)
+		randBytes(rnd, img.Pix)
+		return img
+	}},
+	{"Paletted", func(r image.Rectangle, rnd *rand.Rand) image.Image {
+		p := make(color.Palette, 1+rnd.Intn(256))
+		for i := range p {
+			p[i] = color.NRGBA{
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+				uint8(rnd.Intn(256)),
+			}
+		}
+		img := image.NewPaletted(r, p)
+		for i := range img.Pix {
+			img.Pix[i] = uint8(rnd.Intn(len(p)))
+		}
+		return img
+	}},
+}
+
+func init() {
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	}
+	for _, ratio := range ratios {
+		ratio := ratio
+		convCases = append(
+			convCases,
+			convCase{"YCbCr" + ratio.String()[len("YCbCrSubsampleRatio"):], func(r image.Rectangle, rnd *rand.Rand) image.Image {
+				img := image.NewYCbCr(r, ratio)
+				randBytes(rnd, img.Y)
+				randBytes(rnd, img.Cb)
+				randBytes(rnd, img.Cr)
+				return img
+			}},
+			convCase{"NYCbCrA" + ratio.String()[len("YCbCrSubsampleRatio"):], func(r image.Rectangle, rnd *rand.Rand) image.Image {
+				img := image.NewNYCbCrA(r, ratio)
+				randBytes(rnd, img.Y)
+				randBytes(rnd, img.Cb)
+				randBytes(rnd, img.Cr)
+				randBytes(rnd, img.A)
+				return img
+			}},
+		)
+	}
+}
+
+// drawConvert is the reference conversion Convert must match.
+func drawConvert(src image.Image, b image.Rectangle) *BGRA {
+	dst := NewBGRA(b)
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+	return dst
+}
+
+// diffBGRA returns a description of the first pixel that differs.
+func diffBGRA(got, exp *BGRA) string {
+	b := exp.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g, e := got.RGBAAt(x, y), exp.RGBAAt(x, y)
+			if g != e {
+				return fmt.Sprintf("pixel %d,%d: got %v, expected %v", x, y, g, e)
+			}
+		}
+	}
+	return ""
+}
+
+func TestConvert(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// Large enough to be split among goroutines.
+	r := image.Rect(-3, 5, 509, 261)
+	for _, c := range convCases {
+		t.Run(c.name, func(t *testing.T) {
+			src := c.new(r, rnd)
+			got := NewBGRA(r)
+			Convert(got, src, r)
+			if d := diffBGRA(got, drawConvert(src, r)); d != "" {
+				t.Fatal(d)
+			}
+		})
+	}
+}
+
+func BenchmarkConvert(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	r := image.Rect(0, 0, 1920, 1080)
+	for _, c := range convCases {
+		src := c.new(r, rnd)
+		dst := NewBGRA(r)
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(4 * r.Dx() * r.Dy()))
+			for i := 0; i < b.N; i++ {
+				Convert(dst, src, r)
+			}
+		})
+		b.Run(c.name+"/draw", func(b *testing.B) {
+			b.SetBytes(int64(4 * r.Dx() * r.Dy()))
+			for i := 0; i < b.N; i++ {
+				draw.Draw(dst, r, src, r.Min, draw.Src)
+			}
+		})
+	}
+}
+
+// TestConvertRandom converts random sub-rectangles of sub-images, which
+// have a stride larger than their width and a non-zero origin.
+func TestConvertRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	type subImager interface {
+		SubImage(image.Rectangle) image.Image
+	}
+	rect := func(max int) image.Rectangle {
+		x, y := rnd.Intn(2*max)-max, rnd.Intn(2*max)-max
+		return image.Rect(x, y, x+1+rnd.Intn(max), y+1+rnd.Intn(max))
+	}
+	inset := func(r image.Rectangle) image.Rectangle {
+		x0, y0 := r.Min.X+rnd.Intn(r.Dx()), r.Min.Y+rnd.Intn(r.Dy())
+		return image.Rect(x0, y0, x0+1+rnd.Intn(r.Max.X-x0), y0+1+rnd.Intn(r.Max.Y-y0))
+	}
+
+	for i := 0; i < 2000; i++ {
+		c := convCases[rnd.Intn(len(convCases))]
+		full := rect(64)
+		if i%50 == 0 {
+			full = rect(600)
+		}
+		if strings.Contains(c.name, "YCbCr") {
+			// image.YCbCr.COffset rounds negative coordinates towards
+			// zero, which makes At panic on subsampled images.
+			full = full.Add(image.Pt(600, 600))
+		}
+		src := c.new(full, rnd)
+		if s, ok := src.(subImager); ok && rnd.Intn(4) != 0 {
+			src = s.SubImage(inset(full))
+		}
+		b := inset(src.Bounds())
+
+		got := NewBGRA(b)
+		Convert(got, src, b)
+		if d := diffBGRA(got, drawConvert(src, b)); d != "" {
+			t.Fatalf("%s %v of %v: %s", c.name, b, src.Bounds(), d)
+		}
+
+		// Converting into a sub-image must leave the surrounding pixels be.
+		dst := NewBGRA(b.Inset(-1))
+		randBytes(rnd, dst.Pix)
+		exp := NewBGRA(dst.Rect)
+		copy(exp.Pix, dst.Pix)
+		draw.Draw(exp, b, src, b.Min, draw.Src)
+		Convert(dst.SubImage(b).(*BGRA), src, b)
+		if !bytes.Equal(dst.Pix, exp.Pix) {
+			t.Fatalf("%s %v of %v: sub-image destination: %s", c.name, b, src.Bounds(), diffBGRA(dst, exp))
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package x
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"testing"
+	"testing/quick"
+
+	"github.com/frizinak/zug/format"
+	"github.com/frizinak/zug/format/icc"
+)
+
+// randRGBA64 returns a random alpha-premultiplied color.
+func randRGBA64(rnd *rand.Rand) color.RGBA64 {
+	a := uint16(rnd.Intn(0x10000))
+	c := func() uint16 { return uint16(rnd.Intn(int(a) + 1)) }
+	return color.RGBA64{c(), c(), c(), a}
+}
+
+// TestBGRAColor checks the BGRA accessors against image.RGBA, which stores
+// the same 8-bit premultiplied colors in a different order.
+func TestBGRAColor(t *testing.T) {
+	r := image.Rect(-2, 3, 5, 9)
+	sub := image.Rect(-1, 4, 3, 8)
+	f := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		img, exp := NewBGRA(r), image.NewRGBA(r)
+		bgra, rgba := img.SubImage(sub).(*BGRA), exp.SubImage(sub).(*image.RGBA)
+		for y := r.Min.Y - 1; y <= r.Max.Y; y++ {
+			for x := r.Min.X - 1; x <= r.Max.X; x++ {
+				var c color.Color
+				switch rnd.Intn(3) {
+				case 0:
+					v := randRGBA64(rnd)
+					bgra.SetRGBA64(x, y, v)
+					rgba.SetRGBA64(x, y, v)
+				case 1:
+					v := color.NRGBA64{
+						uint16(rnd.Intn(0x10000)),
+						uint16(rnd.Intn(0x10000)),
+						uint16(rnd.Intn(0x10000)),
+						uint16(rnd.Intn(0x10000)),
+					}
+					c = v
+				case 2:
+					c = color.Gray16{uint16(rnd.Intn(0x10000))}
+				}
+				if c != nil {
+					bgra.Set(x, y, c)
+					rgba.Set(x, y, c)
+				}
+			}
+		}
+		for y := r.Min.Y - 1; y <= r.Max.Y; y++ {
+			for x := r.Min.X - 1; x <= r.Max.X; x++ {
+				if bgra.RGBA64At(x, y) != rgba.RGBA64At(x, y) ||
+					bgra.RGBAAt(x, y) != rgba.RGBAAt(x, y) ||
+					bgra.At(x, y) != rgba.At(x, y) {
+					return false
+				}
+				if img.RGBA64At(x, y) != exp.RGBA64At(x, y) {
+					return false
+				}
+			}
+		}
+		return bgra.Opaque() == rgba.Opaque() && img.Opaque() == exp.Opaque()
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBGRAOpaque(t *testing.T) {
+	r := image.Rect(3, -4, 11, 2)
+	f := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		img, exp := NewBGRA(r), image.NewRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := color.RGBA{A: 0xff}
+				if rnd.Intn(64) == 0 {
+					c.A = uint8(rnd.Intn(0xff))
+				}
+				img.SetRGBA(x, y, c)
+				exp.SetRGBA(x, y, c)
+			}
+		}
+		sub := image.Rect(
+			r.Min.X+rnd.Intn(4), r.Min.Y+rnd.Intn(3),
+			r.Max.X-rnd.Intn(4), r.Max.Y-rnd.Intn(3),
+		)
+		return img.Opaque() == exp.Opaque() &&
+			img.SubImage(sub).(*BGRA).Opaque() == exp.SubImage(sub).(*image.RGBA).Opaque()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTransformLevel checks that mipmap levels of transformed images match
+// reducing the transformed image, which holds for even sizes.
+func TestTransformLevel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	src := NewBGRA(image.Rect(0, 0, 64, 32))
+	randBytes(rnd, src.Pix)
+	premultiply(src.Pix, 4)
+
+	for _, tr := range []Transform{
+		{},
+		{Rotate: 90},
+		{Rotate: 180, FlipH: true},
+		{Rotate: 270, FlipV: true},
+		{Crop: image.Rect(8, 4, 40, 28), Rotate: 90},
+	} {
+		img := NewTransformImage(NewImage(src), tr)
+		img.Reset()
+		exp := halveBGRA(halveBGRA(img.BGRA()))
+		b := exp.Rect
+		got := imageLevel(img, b.Dx(), b.Dy())
+		if got.Rect.Size() != b.Size() {
+			t.Errorf("%+v: level %v, expected %v", tr, got.Rect.Size(), b.Size())
+			continue
+		}
+		if d := diffBGRA(got, exp); d != "" {
+			t.Errorf("%+v: %s", tr, d)
+		}
+	}
+}
+
+// TestDecoderSize checks Decoder.Size picks the fitting image from an icon
+// file holding 16x16, 32x32 and 48x48 versions.
+func TestDecoderSize(t *testing.T) {
+	data, err := os.ReadFile("../format/ico/testdata/icon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ size, exp image.Point }{
+		{image.Point{}, image.Pt(48, 48)},
+		{image.Pt(10, 10), image.Pt(16, 16)},
+		{image.Pt(20, 30), image.Pt(32, 32)},
+		{image.Pt(64, 64), image.Pt(48, 48)},
+	} {
+		d := &Decoder{Size: c.size}
+		img, err := d.Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := img.BGRA().Rect.Size(); s != c.exp {
+			t.Errorf("size %v: decoded %v, expected %v", c.size, s, c.exp)
+		}
+	}
+}
+
+func TestDecoderFrames(t *testing.T) {
+	data, err := os.ReadFile("../format/ico/testdata/icon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		max int
+		err bool
+	}{{0, false}, {4, false}, {3, true}} {
+		d := &Decoder{Limits: format.Limits{MaxFrames: c.max}}
+		_, err := d.Read(bytes.NewReader(data))
+		if err != nil && !errors.Is(err, format.ErrLimit) {
+			t.Fatal(err)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("max %d: error %v", c.max, err)
+		}
+	}
+}
+
+// linearProfile returns an ICC profile with the sRGB primaries and a
+// linear transfer curve.
+func linearProfile(t *testing.T) *icc.Profile {
+	t.Helper()
+	xyz := [3][3]float64{
+		{0.4360747, 0.2225045, 0.0139322},
+		{0.3850649, 0.7168786, 0.0971045},
+		{0.1430804, 0.0606169, 0.7141733},
+	}
+	tags := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	b := make([]byte, 132+12*len(tags))
+	copy(b[16:], "RGB XYZ ")
+	copy(b[36:], "acsp")
+	binary.BigEndian.PutUint32(b[128:], uint32(len(tags)))
+	for i, sig := range tags {
+		var data []byte
+		if i < 3 {
+			data = make([]byte, 20)
+			copy(data, "XYZ ")
+			for j, v := range xyz[i] {
+				binary.BigEndian.PutUint32(data[8+4*j:], uint32(int32(v*65536)))
+			}
+		} else {
+			data = make([]byte, 12)
+			copy(data, "curv")
+		}
+		e := b[132+12*i:]
+		copy(e, sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(b)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(data)))
+		b = append(b, data...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	p, err := icc.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestDecoderPages checks every page is converted to Decoder.Profile.
+func TestDecoderPages(t *testing.T) {
+	data, err := os.ReadFile("../format/tiff/testdata/pages.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(d *Decoder) Pager {
+		img, err := d.Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.(Pager)
+	}
+	linear := read(&Decoder{Profile: linearProfile(t)})
+	plain := read(&Decoder{IgnoreICC: true})
+	for page := 0; page < plain.Pages(); page++ {
+		if err := linear.SetPage(page); err != nil {
+			t.Fatal(err)
+		}
+		if err := plain.SetPage(page); err != nil {
+			t.Fatal(err)
+		}
+		a, b := linear.(Image).BGRA(), plain.(Image).BGRA()
+		if bytes.Equal(a.Pix, b.Pix) {
+			t.Errorf("page %d was not converted", page)
+		}
+	}
+}
+
+// TestDecoderProfileLimit checks errors extracting a profile are returned
+// rather than ignored.
+func TestDecoderProfileLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(make([]byte, 5<<20))
+	w.Close()
+	chunk := append([]byte("iCCPicc\x00\x00"), z.Bytes()...)
+	iccp := make([]byte, 4, 4+len(chunk)+4)
+	binary.BigEndian.PutUint32(iccp, uint32(len(chunk)-4))
+	iccp = append(iccp, chunk...)
+	iccp = append(iccp, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(iccp[len(iccp)-4:], crc32.ChecksumIEEE(chunk))
+
+	// Insert after IHDR (8 byte signature, 25 byte chunk).
+	data := append(append(append([]byte{}, buf.Bytes()[:33]...), iccp...), buf.Bytes()[33:]...)
+	_, err := (&Decoder{}).Read(bytes.NewReader(data))
+	if !errors.Is(err, format.ErrLimit) {
+		t.Fatalf("error %v, expected a limit error", err)
+	}
+	if _, err := (&Decoder{IgnoreICC: true}).Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
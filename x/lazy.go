@@ -0,0 +1,188 @@
+package x
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+
+	"github.com/frizinak/zug/format"
+)
+
+// Loader is implemented by Images that are decoded asynchronously.
+// Their Bounds are known before the pixels are.
+type Loader interface {
+	// Loaded reports whether decoding finished and its error.
+	Loaded() (bool, error)
+	// Wait blocks until decoding finished.
+	Wait() error
+	// OnLoad registers fn to be called once decoding finished, fn is
+	// called immediately if it already has.
+	OnLoad(fn func(error))
+}
+
+// ReadAsync probes the image opened by open with image.DecodeConfig and
+// returns as soon as its dimensions are known, the full decode then
+// continues in the background. The returned Image implements Loader.
+// open is called twice, once for probing and once for decoding.
+//
+// Vector images are parsed synchronously.
+func (d *Decoder) ReadAsync(open func() (io.ReadCloser, error)) (Image, error) {
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, format.SniffLen)
+	header, _ := br.Peek(format.SniffLen)
+	if _, ok := format.MatchVector(header); ok {
+		return d.Read(br)
+	}
+
+	c, name, err := image.DecodeConfig(br)
+	if err != nil {
+		return nil, format.WrapError(header, err)
+	}
+
+	s, err := d.shift(name, c.Width, c.Height)
+	if err != nil {
+		return nil, err
+	}
+	w, h := (c.Width+(1<<s)-1)>>s, (c.Height+(1<<s)-1)>>s
+
+	l := &lazyImage{rect: image.Rect(0, 0, w, h), done: make(chan struct{})}
+	dec := *d
+	go l.load(&dec, open)
+	return l, nil
+}
+
+type lazyImage struct {
+	sem  sync.Mutex
+	rect image.Rectangle
+	img  Image
+	err  error
+	done chan struct{}
+	cbs  []func(error)
+}
+
+func (l *lazyImage) load(d *Decoder, open func() (io.ReadCloser, error)) {
+	var img Image
+	f, err := open()
+	if err == nil {
+		img, err = d.Read(f)
+		f.Close()
+	}
+
+	l.sem.Lock()
+	l.img, l.err = img, err
+	cbs := l.cbs
+	l.cbs = nil
+	l.sem.Unlock()
+	close(l.done)
+
+	for _, cb := range cbs {
+		cb(err)
+	}
+}
+
+func (l *lazyImage) Loaded() (bool, error) {
+	select {
+	case <-l.done:
+		return true, l.err
+	default:
+		return false, nil
+	}
+}
+
+func (l *lazyImage) Wait() error {
+	<-l.done
+	return l.err
+}
+
+func (l *lazyImage) OnLoad(fn func(error)) {
+	l.sem.Lock()
+	select {
+	case <-l.done:
+		l.sem.Unlock()
+		fn(l.err)
+		return
+	default:
+	}
+	l.cbs = append(l.cbs, fn)
+	l.sem.Unlock()
+}
+
+func (l *lazyImage) image() Image {
+	l.sem.Lock()
+	defer l.sem.Unlock()
+	return l.img
+}
+
+func (l *lazyImage) Bounds() image.Rectangle {
+	if img := l.image(); img != nil {
+		return img.Bounds()
+	}
+	return l.rect
+}
+
+func (l *lazyImage) Reset() {
+	if img := l.image(); img != nil {
+		img.Reset()
+	}
+}
+
+func (l *lazyImage) Resize(w, h int) {
+	if img := l.image(); img != nil {
+		img.Resize(w, h)
+	}
+}
+
+func (l *lazyImage) BGRA() *BGRA {
+	if img := l.image(); img != nil {
+		return img.BGRA()
+	}
+	return &BGRA{}
+}
+
+func (l *lazyImage) Level(w, h int) *BGRA {
+	if img := l.image(); img != nil {
+		return imageLevel(img, w, h)
+	}
+	return &BGRA{}
+}
+
+func (l *lazyImage) Pages() int {
+	if p, ok := l.image().(Pager); ok {
+		return p.Pages()
+	}
+	return 1
+}
+
+func (l *lazyImage) Page() int {
+	if p, ok := l.image().(Pager); ok {
+		return p.Page()
+	}
+	return 0
+}
+
+func (l *lazyImage) SetPage(page int) error {
+	if p, ok := l.image().(Pager); ok {
+		return p.SetPage(page)
+	}
+	if page == 0 {
+		return nil
+	}
+	return fmt.Errorf("page %d out of range [0, 1)", page)
+}
+
+// imageLoaded reports whether img can be drawn.
+func imageLoaded(img Image) bool {
+	l, ok := img.(Loader)
+	if !ok {
+		return true
+	}
+	done, err := l.Loaded()
+	return done && err == nil
+}
@@ -0,0 +1,196 @@
+package x
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/frizinak/zug/format"
+)
+
+// gatedOpen returns an open func for ReadAsync that serves probe on the
+// first call and blocks the next until release is closed, then returns
+// data or err.
+func gatedOpen(probe, data []byte, err error) (func() (io.ReadCloser, error), chan struct{}) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	return func() (io.ReadCloser, error) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			return io.NopCloser(bytes.NewReader(probe)), nil
+		}
+		<-release
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, release
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.SetNRGBA(1, 2, color.NRGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkPending checks that img behaves as an empty image of the given
+// bounds while it is being decoded.
+func checkPending(t *testing.T, img Image, bounds image.Rectangle) {
+	t.Helper()
+	if done, err := img.(Loader).Loaded(); done || err != nil {
+		t.Fatalf("loaded %t, %v before decoding", done, err)
+	}
+	if imageLoaded(img) {
+		t.Error("imageLoaded before decoding")
+	}
+	if b := img.Bounds(); b != bounds {
+		t.Errorf("bounds %v, expected %v", b, bounds)
+	}
+	img.Resize(10, 10)
+	if b := img.BGRA().Rect; !b.Empty() {
+		t.Errorf("BGRA %v before decoding", b)
+	}
+	if b := region(img, 2, 2, 1, 1, 8, 8).Rect; !b.Empty() {
+		t.Errorf("Region %v before decoding", b)
+	}
+	if b := imageLevel(img, 4, 4).Rect; !b.Empty() {
+		t.Errorf("Level %v before decoding", b)
+	}
+}
+
+func TestReadAsync(t *testing.T) {
+	data := testPNG(t, 40, 30)
+	open, release := gatedOpen(data, data, nil)
+	d := &Decoder{}
+	img, err := d.ReadAsync(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPending(t, img, image.Rect(0, 0, 40, 30))
+
+	loaded := make(chan error, 1)
+	img.(Loader).OnLoad(func(err error) { loaded <- err })
+
+	// Draw from other goroutines while decoding finishes.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				img.Bounds()
+				img.BGRA()
+				region(img, 1, 1, 0, 0, 40, 30)
+				img.(Loader).Loaded()
+			}
+		}()
+	}
+	close(release)
+	if err := img.(Loader).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := <-loaded; err != nil {
+		t.Errorf("OnLoad: %v", err)
+	}
+
+	if done, err := img.(Loader).Loaded(); !done || err != nil {
+		t.Fatalf("loaded %t, %v after Wait", done, err)
+	}
+	if !imageLoaded(img) {
+		t.Error("not imageLoaded after Wait")
+	}
+	if b := img.Bounds(); b != image.Rect(0, 0, 40, 30) {
+		t.Errorf("bounds %v", b)
+	}
+	img.Reset()
+	out := img.BGRA()
+	if out.Rect != image.Rect(0, 0, 40, 30) {
+		t.Fatalf("BGRA %v", out.Rect)
+	}
+	if c := out.RGBAAt(1, 2); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("pixel %v", c)
+	}
+	if b := region(img, 2, 2, 0, 0, 16, 16).Rect; b != image.Rect(0, 0, 16, 16) {
+		t.Errorf("Region %v", b)
+	}
+
+	called := false
+	img.(Loader).OnLoad(func(err error) { called = err == nil })
+	if !called {
+		t.Error("OnLoad not called immediately after loading")
+	}
+}
+
+func TestReadAsyncError(t *testing.T) {
+	data := testPNG(t, 40, 30)
+	errOpen := errors.New("open failed")
+	decodeErr := func(err error) bool {
+		var derr *format.DecodeError
+		return errors.As(err, &derr) && derr.Format == "png"
+	}
+	for _, c := range []struct {
+		name  string
+		data  []byte
+		err   error
+		match func(error) bool
+	}{
+		{"open", nil, errOpen, func(err error) bool { return err == errOpen }},
+		{"decode", data[:len(data)/2], nil, decodeErr},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			open, release := gatedOpen(data, c.data, c.err)
+			img, err := (&Decoder{}).ReadAsync(open)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPending(t, img, image.Rect(0, 0, 40, 30))
+
+			loaded := make(chan error, 1)
+			img.(Loader).OnLoad(func(err error) { loaded <- err })
+			close(release)
+
+			if err := img.(Loader).Wait(); !c.match(err) {
+				t.Errorf("Wait: %v", err)
+			}
+			if err := <-loaded; !c.match(err) {
+				t.Errorf("OnLoad: %v", err)
+			}
+			if done, err := img.(Loader).Loaded(); !done || !c.match(err) {
+				t.Errorf("loaded %t, %v", done, err)
+			}
+			if imageLoaded(img) {
+				t.Error("imageLoaded after failure")
+			}
+			if b := img.Bounds(); b != image.Rect(0, 0, 40, 30) {
+				t.Errorf("bounds %v after failure", b)
+			}
+			if b := img.BGRA().Rect; !b.Empty() {
+				t.Errorf("BGRA %v after failure", b)
+			}
+		})
+	}
+
+	// Errors probing the image are returned by ReadAsync itself.
+	open, _ := gatedOpen([]byte("hello world"), nil, nil)
+	if _, err := (&Decoder{}).ReadAsync(open); !errors.Is(err, format.ErrUnsupported) {
+		t.Errorf("probe: %v, expected %v", err, format.ErrUnsupported)
+	}
+}
@@ -0,0 +1,180 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrVisual is returned by NewPixelFormat for visuals other than
+// TrueColor, e.g. PseudoColor or StaticGray. DirectColor visuals store
+// pixels as color masks too, but look them up in a colormap that is not
+// necessarily linear.
+var ErrVisual = errors.New("unsupported visual class")
+
+// PixelFormat describes the layout of a pixel in a ZPixmap image as
+// expected by the X server.
+type PixelFormat struct {
+	Depth        byte
+	BitsPerPixel byte
+	ScanlinePad  byte
+	BigEndian    bool
+
+	Red, Green, Blue, Alpha uint32
+}
+
+// NewPixelFormat derives the PixelFormat for the given depth and visual
+// from the connection setup.
+func NewPixelFormat(
+	setup *xproto.SetupInfo,
+	depth byte,
+	visual xproto.VisualInfo,
+) (PixelFormat, error) {
+	p := PixelFormat{
+		Depth:     depth,
+		BigEndian: setup.ImageByteOrder == xproto.ImageOrderMSBFirst,
+		Red:       visual.RedMask,
+		Green:     visual.GreenMask,
+		Blue:      visual.BlueMask,
+	}
+	switch visual.Class {
+	case xproto.VisualClassTrueColor:
+	default:
+		return p, fmt.Errorf("%w: %d", ErrVisual, visual.Class)
+	}
+
+	for _, f := range setup.PixmapFormats {
+		if f.Depth == depth {
+			p.BitsPerPixel, p.ScanlinePad = f.BitsPerPixel, f.ScanlinePad
+			break
+		}
+	}
+	switch p.BitsPerPixel {
+	case 8, 16, 24, 32:
+	default:
+		return p, fmt.Errorf("unsupported bits per pixel: %d", p.BitsPerPixel)
+	}
+
+	if depth < 32 {
+		p.Alpha = 1<<depth - 1
+	} else {
+		p.Alpha = 1<<32 - 1
+	}
+	p.Alpha &^= p.Red | p.Green | p.Blue
+
+	return p, nil
+}
+
+// Native reports whether BGRA pixels can be sent as is.
+func (p PixelFormat) Native() bool {
+	return p.BitsPerPixel == 32 && !p.BigEndian &&
+		p.Red == 0xff0000 && p.Green == 0xff00 && p.Blue == 0xff &&
+		(p.Alpha == 0 || p.Alpha == 0xff000000)
+}
+
+// Stride returns the number of bytes in a scanline of width pixels.
+func (p PixelFormat) Stride(width int) int {
+	pad := int(p.ScanlinePad)
+	if pad == 0 {
+		pad = 8
+	}
+	n := width * int(p.BitsPerPixel)
+	return (n + pad - 1) / pad * pad / 8
+}
+
+// Encode converts img to p, dithering channels with less than 8 bits.
+func (p PixelFormat) Encode(img *BGRA) []byte {
+	b := img.Bounds()
+	if p.Native() && img.Stride == b.Dx()*4 {
+		return img.Pix[img.PixOffset(b.Min.X, b.Min.Y):]
+	}
+
+	stride := p.Stride(b.Dx())
+	buf := make([]byte, stride*b.Dy())
+	r, g, bl, a := newChannel(p.Red), newChannel(p.Green), newChannel(p.Blue), newChannel(p.Alpha)
+	bpp := int(p.BitsPerPixel) / 8
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			src := img.Pix[img.PixOffset(b.Min.X, y):]
+			dst := buf[(y-b.Min.Y)*stride:]
+			for x := 0; x < b.Dx(); x++ {
+				s := src[x*4 : x*4+4 : x*4+4]
+				d := bayer[y&3][x&3]
+				v := r.value(s[2], d) | g.value(s[1], d) | bl.value(s[0], d) | a.value(s[3], 0)
+				p.put(dst[x*bpp:], v)
+			}
+		}
+	})
+	return buf
+}
+
+func (p PixelFormat) put(b []byte, v uint32) {
+	switch p.BitsPerPixel {
+	case 8:
+		b[0] = byte(v)
+	case 16:
+		if p.BigEndian {
+			b[0], b[1] = byte(v>>8), byte(v)
+			return
+		}
+		b[0], b[1] = byte(v), byte(v>>8)
+	case 24:
+		if p.BigEndian {
+			b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+			return
+		}
+		b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+	case 32:
+		if p.BigEndian {
+			b[0], b[1], b[2], b[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+			return
+		}
+		b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+	}
+}
+
+// bayer is a 4x4 ordered dither matrix scaled to 0-255.
+var bayer = [4][4]uint32{
+	{0, 128, 32, 160},
+	{192, 64, 224, 96},
+	{48, 176, 16, 144},
+	{240, 112, 208, 80},
+}
+
+// channel maps an 8-bit value onto a color mask.
+type channel struct {
+	shift uint
+	bits  uint
+	max   uint32
+}
+
+func newChannel(mask uint32) channel {
+	if mask == 0 {
+		return channel{}
+	}
+	shift := uint(bits.TrailingZeros32(mask))
+	n := uint(bits.OnesCount32(mask))
+	return channel{shift: shift, bits: n, max: 1<<n - 1}
+}
+
+// value returns v scaled to the channel and shifted into place, d is the
+// dither threshold in the range 0-255.
+func (c channel) value(v byte, d uint32) uint32 {
+	if c.bits == 0 {
+		return 0
+	}
+	if c.bits >= 8 {
+		x := uint32(v) << (c.bits - 8)
+		x |= x >> 8
+		return x << c.shift
+	}
+
+	x := uint32(v) * c.max
+	q := x / 255
+	if (x-q*255) > d && q < c.max {
+		q++
+	}
+	return q << c.shift
+}
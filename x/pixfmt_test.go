@@ -0,0 +1,84 @@
+package x
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPixelFormatEncode(t *testing.T) {
+	var (
+		rgb  = PixelFormat{Red: 0xff0000, Green: 0xff00, Blue: 0xff}
+		bgr  = PixelFormat{Red: 0xff, Green: 0xff00, Blue: 0xff0000}
+		r565 = PixelFormat{Red: 0xf800, Green: 0x7e0, Blue: 0x1f}
+		r555 = PixelFormat{Red: 0x7c00, Green: 0x3e0, Blue: 0x1f}
+		r10  = PixelFormat{Red: 0x3ff00000, Green: 0xffc00, Blue: 0x3ff}
+		r332 = PixelFormat{Red: 0xe0, Green: 0x1c, Blue: 0x3}
+	)
+	layout := func(p PixelFormat, depth, bpp, pad byte, be bool) PixelFormat {
+		p.Depth, p.BitsPerPixel, p.ScanlinePad, p.BigEndian = depth, bpp, pad, be
+		if depth < 32 {
+			p.Alpha = 1<<depth - 1
+		} else {
+			p.Alpha = 1<<32 - 1
+		}
+		p.Alpha &^= p.Red | p.Green | p.Blue
+		return p
+	}
+	px := color.RGBA{0x12, 0x34, 0x56, 0xff}
+	half := color.RGBA{0x12, 0x34, 0x56, 0x80}
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	gray := color.RGBA{128, 128, 128, 255}
+
+	for _, c := range []struct {
+		name   string
+		format PixelFormat
+		w      int
+		pix    []color.RGBA
+		exp    []byte
+	}{
+		{"32 lsb", layout(rgb, 24, 32, 32, false), 2, []color.RGBA{px, red},
+			[]byte{0x56, 0x34, 0x12, 0xff, 0, 0, 0xff, 0xff}},
+		{"32 msb", layout(rgb, 24, 32, 32, true), 2, []color.RGBA{px, red},
+			[]byte{0, 0x12, 0x34, 0x56, 0, 0xff, 0, 0}},
+		{"32 argb msb", layout(rgb, 32, 32, 32, true), 1, []color.RGBA{half},
+			[]byte{0x80, 0x12, 0x34, 0x56}},
+		{"32 abgr lsb", layout(bgr, 32, 32, 32, false), 1, []color.RGBA{half},
+			[]byte{0x12, 0x34, 0x56, 0x80}},
+		{"30 lsb", layout(r10, 30, 32, 32, false), 2, []color.RGBA{{0xff, 0x12, 0, 0xff}, px},
+			[]byte{0x00, 0x20, 0xf1, 0x3f, 0x59, 0x41, 0x83, 0x04}},
+		{"24 lsb", layout(rgb, 24, 24, 32, false), 3, []color.RGBA{px, red, px},
+			[]byte{0x56, 0x34, 0x12, 0, 0, 0xff, 0x56, 0x34, 0x12, 0, 0, 0}},
+		{"24 msb", layout(rgb, 24, 24, 32, true), 1, []color.RGBA{px},
+			[]byte{0x12, 0x34, 0x56, 0}},
+		{"16 lsb", layout(r565, 16, 16, 32, false), 2, []color.RGBA{red, {255, 255, 255, 255}},
+			[]byte{0x00, 0xf8, 0xff, 0xff}},
+		{"16 msb", layout(r565, 16, 16, 32, true), 2, []color.RGBA{red, {0, 0, 0, 255}},
+			[]byte{0xf8, 0x00, 0x00, 0x00}},
+		// 128 lies between two levels and is dithered.
+		{"16 dither", layout(r565, 16, 16, 32, false), 4, []color.RGBA{
+			gray, gray, gray, gray,
+			gray, gray, gray, gray,
+		}, []byte{
+			0x10, 0x84, 0x10, 0x84, 0x10, 0x84, 0xef, 0x7b,
+			0xef, 0x7b, 0x10, 0x84, 0xef, 0x7b, 0x10, 0x84,
+		}},
+		{"15 lsb", layout(r555, 15, 16, 32, false), 2, []color.RGBA{red, gray},
+			[]byte{0x00, 0x7c, 0x10, 0x42}},
+		{"15 msb", layout(r555, 15, 16, 32, true), 2, []color.RGBA{red, gray},
+			[]byte{0x7c, 0x00, 0x42, 0x10}},
+		{"8", layout(r332, 8, 8, 32, false), 3, []color.RGBA{red, {0, 0, 0xff, 0xff}, {0xff, 0xff, 0xff, 0xff}},
+			[]byte{0xe0, 0x03, 0xff, 0}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			img := NewBGRA(image.Rect(0, 0, c.w, len(c.pix)/c.w))
+			for i, p := range c.pix {
+				img.SetRGBA(i%c.w, i/c.w, p)
+			}
+			if got := c.format.Encode(img); !bytes.Equal(got, c.exp) {
+				t.Errorf("got % x, expected % x", got, c.exp)
+			}
+		})
+	}
+}
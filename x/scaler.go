@@ -1,12 +1,66 @@
 package x
 
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
 type Dimensions struct {
 	W, H int
 }
 
+// Geometry describes how an image is placed in a window.
+//
+// A Scaler receives the size of the source image as Image and the
+// available space as Window, it returns the size to scale to as Image and
+// the size of the window to create as Window.
 type Geometry struct {
 	Image  Dimensions
 	Window Dimensions
+	// Crop, if not empty, is the region of the source image, in source
+	// pixels, that is scaled to Image instead of the entire image.
+	Crop image.Rectangle
+	// Offset of the window relative to the available space.
+	Offset image.Point
+}
+
+// Gravity aligns a box within another.
+type Gravity byte
+
+const (
+	GravityNorthWest Gravity = iota
+	GravityNorth
+	GravityNorthEast
+	GravityWest
+	GravityCenter
+	GravityEast
+	GravitySouthWest
+	GravitySouth
+	GravitySouthEast
+)
+
+// Offset returns the position of inner within outer.
+func (g Gravity) Offset(inner, outer Dimensions) image.Point {
+	var p image.Point
+	switch g % 3 {
+	case 1:
+		p.X = (outer.W - inner.W) / 2
+	case 2:
+		p.X = outer.W - inner.W
+	}
+	switch g / 3 {
+	case 1:
+		p.Y = (outer.H - inner.H) / 2
+	case 2:
+		p.Y = outer.H - inner.H
+	}
+	return p
 }
 
 type Scaler func(c Geometry) Geometry
@@ -38,23 +92,326 @@ func ScalerContain(upscale bool) Scaler {
 	}
 }
 
-type ScaleMethod byte
+// ScalerAlign positions the window returned by s within the available
+// space according to g.
+func ScalerAlign(s Scaler, g Gravity) Scaler {
+	return func(c Geometry) Geometry {
+		space := c.Window
+		c = s(c)
+		c.Offset = c.Offset.Add(g.Offset(c.Window, space))
+		return c
+	}
+}
+
+// ScalerCover scales the image so it covers the entire window, cropping
+// the part that does not fit as indicated by g.
+func ScalerCover(g Gravity) Scaler {
+	return func(c Geometry) Geometry {
+		if c.Image.W == 0 || c.Image.H == 0 || c.Window.W == 0 || c.Window.H == 0 {
+			return c
+		}
+
+		src := c.Image
+		ir := float64(src.W) / float64(src.H)
+		cr := float64(c.Window.W) / float64(c.Window.H)
+		crop := src
+		if ir > cr {
+			crop.W = int(float64(src.H) * cr)
+		} else {
+			crop.H = int(float64(src.W) / cr)
+		}
+		if crop.W < 1 {
+			crop.W = 1
+		}
+		if crop.H < 1 {
+			crop.H = 1
+		}
+
+		min := g.Offset(crop, src)
+		c.Crop = image.Rectangle{Min: min, Max: min.Add(image.Pt(crop.W, crop.H))}
+		c.Image = c.Window
+		return c
+	}
+}
+
+// ScalerStretch scales the image to the window ignoring its aspect ratio.
+func ScalerStretch() Scaler {
+	return func(c Geometry) Geometry {
+		c.Image = c.Window
+		return c
+	}
+}
+
+// ScalerZoom scales the image by f, cropping what does not fit in the
+// window.
+func ScalerZoom(f float64) Scaler {
+	return func(c Geometry) Geometry {
+		return scaleCrop(c, f, f)
+	}
+}
+
+// ScalerOriginal shows the image at its original size, cropping what does
+// not fit in the window.
+func ScalerOriginal() Scaler { return ScalerZoom(1) }
+
+// ScalerFitWidth scales the image to the width of the window, cropping
+// what does not fit vertically.
+func ScalerFitWidth() Scaler {
+	return func(c Geometry) Geometry {
+		if c.Image.W == 0 {
+			return c
+		}
+		f := float64(c.Window.W) / float64(c.Image.W)
+		return scaleCrop(c, f, f)
+	}
+}
+
+// ScalerFitHeight scales the image to the height of the window, cropping
+// what does not fit horizontally.
+func ScalerFitHeight() Scaler {
+	return func(c Geometry) Geometry {
+		if c.Image.H == 0 {
+			return c
+		}
+		f := float64(c.Window.H) / float64(c.Image.H)
+		return scaleCrop(c, f, f)
+	}
+}
+
+// ScalerInteger scales the image by the largest integer factor that fits
+// the window, or the smallest 1/n if it is larger than the window. Useful
+// for pixel art.
+func ScalerInteger() Scaler {
+	return func(c Geometry) Geometry {
+		if c.Image.W == 0 || c.Image.H == 0 {
+			return c
+		}
+		f := c.Window.W / c.Image.W
+		if fh := c.Window.H / c.Image.H; fh < f {
+			f = fh
+		}
+		if f >= 1 {
+			return scaleCrop(c, float64(f), float64(f))
+		}
+
+		n := 2
+		for (c.Image.W+n-1)/n > c.Window.W || (c.Image.H+n-1)/n > c.Window.H {
+			n++
+		}
+		return scaleCrop(c, 1/float64(n), 1/float64(n))
+	}
+}
+
+// scaleCrop scales the image by fx, fy and crops the source so the result
+// fits the window.
+func scaleCrop(c Geometry, fx, fy float64) Geometry {
+	w := int(float64(c.Image.W) * fx)
+	h := int(float64(c.Image.H) * fy)
+	crop := image.Rect(0, 0, c.Image.W, c.Image.H)
+	if w > c.Window.W {
+		w = c.Window.W
+		crop.Max.X = int(float64(w) / fx)
+	}
+	if h > c.Window.H {
+		h = c.Window.H
+		crop.Max.Y = int(float64(h) / fy)
+	}
+	if crop.Max.X != c.Image.W || crop.Max.Y != c.Image.H {
+		c.Crop = crop
+	}
+	c.Image = Dimensions{W: w, H: h}
+	c.Window = c.Image
+	return c
+}
+
+// ScaleMethod selects a registered Scaler. The ScaleZoom method carries
+// its percentage in the bits above the lowest byte, see ScaleMethodZoom.
+type ScaleMethod uint32
 
 const (
 	ScaleRatio ScaleMethod = iota
 	ScaleRatioUpscale
+	// ScaleCenter is ScaleRatio centered in the available space.
+	ScaleCenter
+	// ScaleCenterUpscale is ScaleRatioUpscale centered in the available
+	// space.
+	ScaleCenterUpscale
+	// ScaleCover covers the available space, cropping the image evenly.
+	ScaleCover
+	ScaleStretch
+	ScaleOriginal
+	ScaleFitWidth
+	ScaleFitHeight
+	ScaleInteger
+	// ScaleZoom scales the image by a percentage, cropping what does not
+	// fit. Without one, see ScaleMethodZoom, it is 100%.
+	ScaleZoom
 )
 
-var scalers = map[ScaleMethod]Scaler{
-	ScaleRatio:        ScalerContain(false),
-	ScaleRatioUpscale: ScalerContain(true),
+// maxZoom is the largest percentage, in tenths, a ScaleZoom method holds.
+const maxZoom = 1<<24 - 1
+
+// ScaleMethodZoom returns the ScaleZoom method for pct percent, rounded
+// to a tenth of a percent.
+func ScaleMethodZoom(pct float64) (ScaleMethod, error) {
+	if !(pct > 0) || math.IsInf(pct, 0) {
+		return 0, fmt.Errorf("invalid zoom percentage: %g", pct)
+	}
+	z := math.Round(pct * 10)
+	if z > maxZoom {
+		return 0, fmt.Errorf("zoom percentage too large: %g", pct)
+	}
+	if z < 1 {
+		z = 1
+	}
+	return ScaleZoom | ScaleMethod(z)<<8, nil
 }
 
-var last = ScaleRatioUpscale
+// zoom returns the percentage of a ScaleZoom method.
+func (s ScaleMethod) zoom() (float64, bool) {
+	if s&0xff != ScaleZoom {
+		return 0, false
+	}
+	if s>>8 == 0 {
+		return 100, true
+	}
+	return float64(s>>8) / 10, true
+}
+
+// ErrScalers is returned when registering more than 255 scalers.
+var ErrScalers = errors.New("too many scalers registered")
+
+var scalers = struct {
+	sync.RWMutex
+	m     map[ScaleMethod]Scaler
+	names map[string]ScaleMethod
+	last  ScaleMethod
+}{
+	m: map[ScaleMethod]Scaler{
+		ScaleRatio:         ScalerContain(false),
+		ScaleRatioUpscale:  ScalerContain(true),
+		ScaleCenter:        ScalerAlign(ScalerContain(false), GravityCenter),
+		ScaleCenterUpscale: ScalerAlign(ScalerContain(true), GravityCenter),
+		ScaleCover:         ScalerCover(GravityCenter),
+		ScaleStretch:       ScalerStretch(),
+		ScaleOriginal:      ScalerOriginal(),
+		ScaleFitWidth:      ScalerFitWidth(),
+		ScaleFitHeight:     ScalerFitHeight(),
+		ScaleInteger:       ScalerInteger(),
+	},
+	names: map[string]ScaleMethod{
+		"ratio":          ScaleRatio,
+		"ratio-upscale":  ScaleRatioUpscale,
+		"center":         ScaleCenter,
+		"center-upscale": ScaleCenterUpscale,
+		"cover":          ScaleCover,
+		"stretch":        ScaleStretch,
+		"original":       ScaleOriginal,
+		"fit-width":      ScaleFitWidth,
+		"fit-height":     ScaleFitHeight,
+		"integer":        ScaleInteger,
+	},
+	last: ScaleZoom,
+}
 
+// RegisterScaler registers s and returns its ScaleMethod. It panics when
+// all 255 ScaleMethods are in use, see TryRegisterScaler.
 func RegisterScaler(s Scaler) ScaleMethod {
-	n := last + 1
-	scalers[n] = s
-	last = n
+	n, err := TryRegisterScaler(s)
+	if err != nil {
+		panic(err)
+	}
 	return n
 }
+
+// TryRegisterScaler is RegisterScaler but returns ErrScalers instead of
+// panicking.
+func TryRegisterScaler(s Scaler) (ScaleMethod, error) {
+	scalers.Lock()
+	defer scalers.Unlock()
+	return registerScaler(s)
+}
+
+func registerScaler(s Scaler) (ScaleMethod, error) {
+	if scalers.last == math.MaxUint8 {
+		return 0, ErrScalers
+	}
+	n := scalers.last + 1
+	scalers.m[n] = s
+	scalers.last = n
+	return n, nil
+}
+
+// RegisterNamedScaler registers s so it can be looked up using
+// ScaleMethodByName.
+func RegisterNamedScaler(name string, s Scaler) (ScaleMethod, error) {
+	scalers.Lock()
+	defer scalers.Unlock()
+	if _, ok := scalers.names[name]; ok {
+		return 0, fmt.Errorf("scaler %q already registered", name)
+	}
+	n, err := registerScaler(s)
+	if err != nil {
+		return 0, err
+	}
+	scalers.names[name] = n
+	return n, nil
+}
+
+// ScaleMethodByName returns the ScaleMethod registered under name. Names
+// of the form "150%" return the ScaleZoom method for that percentage, see
+// ScaleMethodZoom.
+func ScaleMethodByName(name string) (ScaleMethod, error) {
+	scalers.RLock()
+	n, ok := scalers.names[name]
+	scalers.RUnlock()
+	if ok {
+		return n, nil
+	}
+	if !strings.HasSuffix(name, "%") {
+		return 0, fmt.Errorf("no such scaler: '%s'", name)
+	}
+
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(name, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zoom percentage: '%s'", name)
+	}
+	return ScaleMethodZoom(pct)
+}
+
+// ScaleMethodNames returns the sorted names of all named ScaleMethods.
+func ScaleMethodNames() []string {
+	scalers.RLock()
+	defer scalers.RUnlock()
+	names := make([]string, 0, len(scalers.names))
+	for n := range scalers.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the name s was registered with, or its percentage for
+// ScaleZoom methods.
+func (s ScaleMethod) String() string {
+	if pct, ok := s.zoom(); ok {
+		return strconv.FormatFloat(pct, 'f', -1, 64) + "%"
+	}
+	scalers.RLock()
+	defer scalers.RUnlock()
+	for name, n := range scalers.names {
+		if n == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("scaler(%d)", uint32(s))
+}
+
+func lookupScaler(s ScaleMethod) Scaler {
+	if pct, ok := s.zoom(); ok {
+		return ScalerZoom(pct / 100)
+	}
+	scalers.RLock()
+	defer scalers.RUnlock()
+	return scalers.m[s]
+}
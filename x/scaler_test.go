@@ -0,0 +1,98 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// restoreScalers restores the scaler registry when the test finishes.
+func restoreScalers(t *testing.T) {
+	scalers.Lock()
+	m, names, last := scalers.m, scalers.names, scalers.last
+	scalers.m = make(map[ScaleMethod]Scaler, len(m))
+	for k, v := range m {
+		scalers.m[k] = v
+	}
+	scalers.names = make(map[string]ScaleMethod, len(names))
+	for k, v := range names {
+		scalers.names[k] = v
+	}
+	scalers.Unlock()
+
+	t.Cleanup(func() {
+		scalers.Lock()
+		scalers.m, scalers.names, scalers.last = m, names, last
+		scalers.Unlock()
+	})
+}
+
+func TestScaleMethodByName(t *testing.T) {
+	restoreScalers(t)
+
+	n, err := ScaleMethodByName("cover")
+	if err != nil || n != ScaleCover {
+		t.Errorf("cover: got %v, %v", n, err)
+	}
+	for _, name := range []string{"", "nope", "%", "abc%", "-5%", "0%", "NaN%", "Inf%", "1e7%"} {
+		if _, err := ScaleMethodByName(name); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+
+	zoom, err := ScaleMethodByName("150%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"150%", "150.0%", "150.04%", "1.5e2%"} {
+		if n, err := ScaleMethodByName(name); err != nil || n != zoom {
+			t.Errorf("%q: got %v, %v, expected %v", name, n, err, zoom)
+		}
+	}
+	if s := zoom.String(); s != "150%" {
+		t.Errorf("String: got %q", s)
+	}
+	g := lookupScaler(zoom)(Geometry{
+		Image:  Dimensions{W: 100, H: 100},
+		Window: Dimensions{W: 1000, H: 1000},
+	})
+	if g.Image != (Dimensions{W: 150, H: 150}) {
+		t.Errorf("150%% of 100x100: %v", g.Image)
+	}
+	if n, err := ScaleMethodByName("12.5%"); err != nil || n == zoom || n.String() != "12.5%" {
+		t.Errorf("12.5%%: got %v, %v", n, err)
+	}
+	if s := ScaleZoom.String(); s != "100%" {
+		t.Errorf("ScaleZoom: got %q", s)
+	}
+}
+
+func TestScaleMethodByNameFull(t *testing.T) {
+	restoreScalers(t)
+
+	// Zooms do not use up ScaleMethods.
+	for i := 1; i <= 300; i++ {
+		if _, err := ScaleMethodByName(fmt.Sprintf("%d%%", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var err error
+	for i := 0; i < 300 && err == nil; i++ {
+		_, err = TryRegisterScaler(ScalerStretch())
+	}
+	if !errors.Is(err, ErrScalers) {
+		t.Fatalf("got %v, expected ErrScalers", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrScalers {
+				t.Errorf("RegisterScaler: got %v, expected a panic with ErrScalers", r)
+			}
+		}()
+		RegisterScaler(ScalerStretch())
+	}()
+	if _, err := ScaleMethodByName("1234.5%"); err != nil {
+		t.Error(err)
+	}
+}
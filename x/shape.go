@@ -0,0 +1,245 @@
+package x
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+
+	"github.com/jezek/xgb/shape"
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrShape is returned by Render when a Mask is set but the X server does
+// not support the SHAPE extension.
+var ErrShape = errors.New("SHAPE extension not available")
+
+// Mask selects the pixels of a subwindow that are shown, others show
+// whatever is behind the subwindow, e.g.: terminal text.
+type Mask interface {
+	// Mask returns the mask of a window of the given size, pixels with a
+	// non-zero alpha are shown. img is the scaled and filtered image before
+	// the Background is composited, or nil if it is scaled by the X
+	// server, see UseRender.
+	Mask(size Dimensions, img *BGRA) *image.Alpha
+}
+
+// MaskFunc is a func that implements Mask.
+type MaskFunc func(size Dimensions, img *BGRA) *image.Alpha
+
+func (m MaskFunc) Mask(size Dimensions, img *BGRA) *image.Alpha { return m(size, img) }
+
+// maskImage is implemented by Masks that depend on the pixels of the
+// image, which requires the image to be scaled on the CPU.
+type maskImage interface {
+	Mask
+	usesImage()
+}
+
+func shapeMask(size Dimensions, shown func(x, y float64) bool) *image.Alpha {
+	a := image.NewAlpha(image.Rect(0, 0, size.W, size.H))
+	parallelRows(a.Rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := a.Pix[y*a.Stride : y*a.Stride+size.W]
+			for x := range row {
+				if shown(float64(x)+0.5, float64(y)+0.5) {
+					row[x] = 255
+				}
+			}
+		}
+	})
+	return a
+}
+
+// RoundedMask returns a Mask of a rectangle with corners of the given
+// radius.
+func RoundedMask(radius int) Mask {
+	return MaskFunc(func(size Dimensions, img *BGRA) *image.Alpha {
+		w, h := float64(size.W), float64(size.H)
+		r := float64(radius)
+		if r > w/2 {
+			r = w / 2
+		}
+		if r > h/2 {
+			r = h / 2
+		}
+		clamp := func(v, min, max float64) float64 {
+			if v < min {
+				return min
+			}
+			if v > max {
+				return max
+			}
+			return v
+		}
+		return shapeMask(size, func(x, y float64) bool {
+			dx, dy := x-clamp(x, r, w-r), y-clamp(y, r, h-r)
+			return dx*dx+dy*dy <= r*r
+		})
+	})
+}
+
+// CircleMask returns a Mask of the circle, or ellipse for windows that are
+// not square, that fills the window.
+func CircleMask() Mask {
+	return MaskFunc(func(size Dimensions, img *BGRA) *image.Alpha {
+		rx, ry := float64(size.W)/2, float64(size.H)/2
+		return shapeMask(size, func(x, y float64) bool {
+			dx, dy := (x-rx)/rx, (y-ry)/ry
+			return dx*dx+dy*dy <= 1
+		})
+	})
+}
+
+type alphaMask uint8
+
+func (alphaMask) usesImage() {}
+
+func (t alphaMask) Mask(size Dimensions, img *BGRA) *image.Alpha {
+	a := image.NewAlpha(image.Rect(0, 0, size.W, size.H))
+	if img == nil {
+		return a
+	}
+	b := img.Rect.Sub(img.Rect.Min).Intersect(a.Rect)
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			dst := a.Pix[y*a.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				if src[x*4+3] > uint8(t) {
+					dst[x] = 255
+				}
+			}
+		}
+	})
+	return a
+}
+
+// AlphaMask returns a Mask that shows the pixels of the image with an
+// alpha above threshold.
+func AlphaMask(threshold uint8) Mask { return alphaMask(threshold) }
+
+// Mask returns the mask set by SetMask.
+func (w *SubWindow) Mask() Mask {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	return w.mask
+}
+
+// SetMask sets the bounding shape of the subwindow, it is recomputed
+// whenever the size of the window or the image changes. nil removes it.
+func (w *SubWindow) SetMask(m Mask) {
+	w.sem.Lock()
+	w.mask = m
+	w.shapeStale = true
+	w.change = true
+	w.sem.Unlock()
+}
+
+func (t *TermWindow) hasShape() bool {
+	t.shapeOnce.Do(func() {
+		t.shape = shape.Init(t.x) == nil
+	})
+	return t.shape
+}
+
+// applyShape sets the bounding shape of the window to w.mask.
+func (w *SubWindow) applyShape() {
+	if !w.is(stateCreated) {
+		return
+	}
+	if w.mask == nil {
+		if w.shaped {
+			shape.Mask(w.t.x, shape.SoSet, shape.SkBounding, w.wnd, 0, 0, 0)
+			w.shaped = false
+		}
+		return
+	}
+	if w.shaped && !w.shapeStale && w.shapeFor == w.wsize {
+		return
+	}
+	if !w.t.hasShape() {
+		w.err = ErrShape
+		return
+	}
+
+	size := w.wsize
+	r := image.Rect(0, 0, size.W, size.H)
+	m := w.mask.Mask(size, w.fg)
+	if m == nil || m.Rect.Size() != r.Size() {
+		a := image.NewAlpha(r)
+		if m != nil {
+			draw.Draw(a, r, m, m.Rect.Min, draw.Src)
+		}
+		m = a
+	}
+	pix, stride := w.t.bitmap(m)
+	pixmap, err := xproto.NewPixmapId(w.t.x)
+	if err != nil {
+		w.err = err
+		return
+	}
+	gc, err := xproto.NewGcontextId(w.t.x)
+	if err != nil {
+		w.err = err
+		return
+	}
+	xproto.CreatePixmap(w.t.x, 1, pixmap, xproto.Drawable(w.t.wnd), uint16(size.W), uint16(size.H))
+	defer xproto.FreePixmap(w.t.x, pixmap)
+	xproto.CreateGC(w.t.x, gc, xproto.Drawable(pixmap), 0, nil)
+	defer xproto.FreeGC(w.t.x, gc)
+
+	err = w.t.putRows(pix, stride, size.W, size.H, 0, xproto.Drawable(pixmap), gc, 1)
+	if err != nil {
+		w.err = err
+		return
+	}
+	shape.Mask(w.t.x, shape.SoSet, shape.SkBounding, w.wnd, 0, 0, pixmap)
+	w.shaped, w.shapeFor, w.shapeStale = true, size, false
+}
+
+// bitmap packs a into a depth 1 ZPixmap as laid out by the X server.
+func (t *TermWindow) bitmap(a *image.Alpha) ([]byte, int) {
+	setup := xproto.Setup(t.x)
+	pad := int(setup.BitmapFormatScanlinePad)
+	for _, f := range setup.PixmapFormats {
+		if f.Depth == 1 {
+			pad = int(f.ScanlinePad)
+			break
+		}
+	}
+	unit := int(setup.BitmapFormatScanlineUnit)
+	if unit == 0 {
+		unit = 8
+	}
+	if pad == 0 {
+		pad = unit
+	}
+	if unit > pad {
+		unit = pad
+	}
+	msbBit := setup.BitmapFormatBitOrder == xproto.ImageOrderMSBFirst
+	msbByte := setup.ImageByteOrder == xproto.ImageOrderMSBFirst
+
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	stride := (width + pad - 1) / pad * pad / 8
+	pix := make([]byte, stride*height)
+	for y := 0; y < height; y++ {
+		src := a.Pix[y*a.Stride : y*a.Stride+width]
+		dst := pix[y*stride:]
+		for x, v := range src {
+			if v == 0 {
+				continue
+			}
+			u, bit := x/unit, x%unit
+			if msbBit {
+				bit = unit - 1 - bit
+			}
+			byt := bit / 8
+			if msbByte {
+				byt = unit/8 - 1 - byt
+			}
+			dst[u*unit/8+byt] |= 1 << (bit % 8)
+		}
+	}
+	return pix, stride
+}
@@ -0,0 +1,106 @@
+package x
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+func TestBitmap(t *testing.T) {
+	const (
+		lsb = xproto.ImageOrderLSBFirst
+		msb = xproto.ImageOrderMSBFirst
+	)
+	for _, c := range []struct {
+		bitOrder, byteOrder byte
+		unit, pad           byte
+		width, x            int
+		stride, byt         int
+		bit                 byte
+	}{
+		{lsb, lsb, 32, 32, 10, 9, 4, 1, 0x02},
+		{msb, msb, 32, 32, 10, 9, 4, 1, 0x40},
+		{msb, lsb, 32, 32, 10, 9, 4, 2, 0x40},
+		{lsb, msb, 32, 32, 10, 9, 4, 2, 0x02},
+		{msb, lsb, 16, 16, 10, 9, 2, 0, 0x40},
+		{lsb, msb, 16, 16, 10, 9, 2, 0, 0x02},
+		{msb, msb, 8, 8, 17, 16, 3, 2, 0x80},
+		{lsb, lsb, 8, 8, 17, 16, 3, 2, 0x01},
+		{msb, lsb, 32, 16, 17, 16, 4, 3, 0x80},
+		{lsb, lsb, 32, 32, 33, 32, 8, 4, 0x01},
+	} {
+		name := fmt.Sprintf(
+			"bit=%d/byte=%d/unit=%d/pad=%d/x=%d",
+			c.bitOrder, c.byteOrder, c.unit, c.pad, c.x,
+		)
+		t.Run(name, func(t *testing.T) {
+			setup := xproto.SetupInfo{
+				ImageByteOrder:           c.byteOrder,
+				BitmapFormatBitOrder:     c.bitOrder,
+				BitmapFormatScanlineUnit: c.unit,
+				BitmapFormatScanlinePad:  32,
+				PixmapFormatsLen:         1,
+				PixmapFormats: []xproto.Format{
+					{Depth: 1, BitsPerPixel: 1, ScanlinePad: c.pad},
+				},
+			}
+			tw := &TermWindow{x: &xgb.Conn{SetupBytes: setup.Bytes()}}
+
+			// The pixel is on the second row to check the stride.
+			a := image.NewAlpha(image.Rect(0, 0, c.width, 2))
+			a.SetAlpha(c.x, 1, color.Alpha{1})
+			pix, stride := tw.bitmap(a)
+			if stride != c.stride {
+				t.Fatalf("stride %d, expected %d", stride, c.stride)
+			}
+			exp := make([]byte, 2*c.stride)
+			exp[c.stride+c.byt] = c.bit
+			if string(pix) != string(exp) {
+				t.Errorf("got % x, expected % x", pix, exp)
+			}
+		})
+	}
+}
+
+// TestMaskBeforeBackground checks that masks see the transparent pixels
+// of the image rather than the background composited behind them.
+func TestMaskBeforeBackground(t *testing.T) {
+	depth, formats := fakeDepth24()
+	tw, f := newFakeX(t, formats, depth)
+	f.enable("SHAPE", 129)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.SetNRGBA(1, 2, color.NRGBA{255, 0, 0, 255})
+	w := tw.SubWindow("a")
+	w.SetImage(NewImage(img))
+	w.SetBackground(SolidBackground(color.White))
+	w.SetGeometry(image.Rect(0, 0, 4, 4))
+
+	var mask *image.Alpha
+	w.SetMask(MaskFunc(func(size Dimensions, img *BGRA) *image.Alpha {
+		mask = AlphaMask(0).Mask(size, img)
+		return mask
+	}))
+	w.Show()
+	if err := w.Render(); err != nil {
+		t.Fatal(err)
+	}
+	if mask == nil {
+		t.Fatal("mask not computed")
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			exp := uint8(0)
+			if x == 1 && y == 2 {
+				exp = 255
+			}
+			if v := mask.AlphaAt(x, y).A; v != exp {
+				t.Errorf("mask %d,%d: %d, expected %d", x, y, v, exp)
+			}
+		}
+	}
+}
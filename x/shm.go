@@ -0,0 +1,141 @@
+package x
+
+import (
+	"strings"
+
+	"github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	// shmMin is the minimum size of a shared memory segment.
+	shmMin = 1 << 20
+	// shmShrink is the factor by which a segment may exceed the size
+	// needed before it is replaced by a smaller one.
+	shmShrink = 4
+)
+
+// shmSegment is a shared memory segment attached by both the X server and
+// this process.
+type shmSegment struct {
+	seg  shm.Seg
+	data []byte
+}
+
+// initSHM checks if the MIT-SHM extension is available and the X server
+// runs on this host.
+func (t *TermWindow) initSHM() bool {
+	if t.shmState == 0 {
+		t.shmState = -1
+		if localDisplay(t.display) && shm.Init(t.x) == nil {
+			if _, err := shm.QueryVersion(t.x).Reply(); err == nil {
+				t.shmState = 1
+			}
+		}
+	}
+	return t.shmState == 1
+}
+
+// localDisplay reports whether display, as in $DISPLAY, is reached through
+// a unix socket. Displays reached over TCP are treated as remote, even on
+// localhost as that is how ssh forwards X11.
+func localDisplay(display string) bool {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return false
+	}
+	if strings.HasPrefix(display, "/") {
+		return true
+	}
+	host := display[:i]
+	if j := strings.LastIndex(host, "/"); j >= 0 {
+		if host[:j] != "unix" {
+			return false
+		}
+		host = host[j+1:]
+	}
+	return host == "" || host == "unix"
+}
+
+// shmAttach returns a segment of at least size bytes, replacing the
+// current one if it is too small or much larger than needed.
+func (t *TermWindow) shmAttach(size int) *shmSegment {
+	n := shmMin
+	for n < size {
+		n <<= 1
+	}
+	if t.shm != nil && len(t.shm.data) >= size && len(t.shm.data) <= shmShrink*n {
+		return t.shm
+	}
+	t.shmDetach()
+
+	id, data, err := shmAlloc(n)
+	if err != nil {
+		t.shmState = -1
+		return nil
+	}
+	defer shmRemove(id)
+
+	seg, err := shm.NewSegId(t.x)
+	if err == nil {
+		err = shm.AttachChecked(t.x, seg, uint32(id), true).Check()
+	}
+	if err != nil {
+		// Most likely a remote connection.
+		shmFree(data)
+		t.shmState = -1
+		return nil
+	}
+
+	t.shm = &shmSegment{seg: seg, data: data}
+	return t.shm
+}
+
+func (t *TermWindow) shmDetach() {
+	if t.shm == nil {
+		return
+	}
+	shm.Detach(t.x, t.shm.seg)
+	xproto.GetInputFocus(t.x).Reply()
+	shmFree(t.shm.data)
+	t.shm = nil
+}
+
+// putImageSHM uploads pix using shared memory and reports whether shared
+// memory was used.
+func (t *TermWindow) putImageSHM(
+	pix []byte,
+	width, height int,
+	pixMap xproto.Drawable,
+	gc xproto.Gcontext,
+	depth byte,
+) (bool, error) {
+	t.shmMu.Lock()
+	defer t.shmMu.Unlock()
+	if !t.initSHM() {
+		return false, nil
+	}
+	s := t.shmAttach(len(pix))
+	if s == nil {
+		return false, nil
+	}
+
+	copy(s.data, pix)
+	// Checking also waits for the server to read the segment before it is
+	// reused.
+	err := shm.PutImageChecked(
+		t.x,
+		pixMap,
+		gc,
+		uint16(width), uint16(height),
+		0, 0,
+		uint16(width), uint16(height),
+		0, 0,
+		depth,
+		xproto.ImageFormatZPixmap,
+		0,
+		s.seg,
+		0,
+	).Check()
+	return true, err
+}
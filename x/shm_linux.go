@@ -0,0 +1,46 @@
+//go:build linux && (amd64 || arm || arm64 || loong64 || mips64 || mips64le || riscv64)
+// +build linux
+// +build amd64 arm arm64 loong64 mips64 mips64le riscv64
+
+package x
+
+import (
+	"reflect"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ipcPrivate = 0
+	ipcCreat   = 01000
+	ipcRmid    = 0
+)
+
+// shmAlloc creates and attaches a System V shared memory segment.
+func shmAlloc(size int) (int, []byte, error) {
+	id, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate, uintptr(size), ipcCreat|0600)
+	if errno != 0 {
+		return 0, nil, errno
+	}
+
+	addr, _, errno := syscall.Syscall(syscall.SYS_SHMAT, id, 0, 0)
+	if errno != 0 {
+		shmRemove(int(id))
+		return 0, nil, errno
+	}
+
+	var b []byte
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	h.Data, h.Len, h.Cap = addr, size, size
+	return int(id), b, nil
+}
+
+// shmRemove marks the segment for removal once all processes detached.
+func shmRemove(id int) {
+	syscall.Syscall(syscall.SYS_SHMCTL, uintptr(id), ipcRmid, 0)
+}
+
+// shmFree detaches a segment returned by shmAlloc.
+func shmFree(b []byte) {
+	syscall.Syscall(syscall.SYS_SHMDT, uintptr(unsafe.Pointer(&b[0])), 0, 0)
+}
@@ -0,0 +1,14 @@
+//go:build !linux || !(amd64 || arm || arm64 || loong64 || mips64 || mips64le || riscv64)
+// +build !linux !amd64,!arm,!arm64,!loong64,!mips64,!mips64le,!riscv64
+
+package x
+
+import "errors"
+
+func shmAlloc(size int) (int, []byte, error) {
+	return 0, nil, errors.New("shared memory not supported")
+}
+
+func shmRemove(id int) {}
+
+func shmFree(b []byte) {}
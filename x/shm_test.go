@@ -0,0 +1,79 @@
+package x
+
+import (
+	"testing"
+)
+
+func TestLocalDisplay(t *testing.T) {
+	for display, exp := range map[string]bool{
+		":0":                         true,
+		":1.0":                       true,
+		"unix:0":                     true,
+		"unix/:0":                    true,
+		"/tmp/launch-abc/org.x:0":    true,
+		"localhost:10.0":             false,
+		"127.0.0.1:0":                false,
+		"tcp/localhost:0":            false,
+		"remote.example.com:0.0":     false,
+		"[::1]:0":                    false,
+		"":                           false,
+		"garbage":                    false,
+		"inet6/remote.example.com:1": false,
+	} {
+		if got := localDisplay(display); got != exp {
+			t.Errorf("%q: got %t, expected %t", display, got, exp)
+		}
+	}
+}
+
+// MIT-SHM requests counted by fakeX.
+const (
+	shmOpcode = 130
+
+	reqShmAttach = shmOpcode<<8 | 1
+	reqShmDetach = shmOpcode<<8 | 2
+)
+
+func TestSHMSegmentSize(t *testing.T) {
+	if _, b, err := shmAlloc(shmMin); err != nil {
+		t.Skip("shared memory not available:", err)
+	} else {
+		shmFree(b)
+	}
+
+	depth, formats := fakeDepth24()
+	tw, f := newFakeX(t, formats, depth)
+	f.enable("MIT-SHM", shmOpcode)
+	tw.display = ":0"
+
+	tw.shmMu.Lock()
+	defer tw.shmMu.Unlock()
+	if !tw.initSHM() {
+		t.Fatal("MIT-SHM not initialized")
+	}
+	for _, c := range []struct {
+		need, size int
+		attached   bool
+	}{
+		{100, shmMin, true},
+		{shmMin, shmMin, false},
+		{9 << 20, 16 << 20, true},
+		{3 << 20, 16 << 20, false},
+		{2 << 20, 2 << 20, true},
+		{100, 2 << 20, false},
+		{9 << 20, 16 << 20, true},
+		{100, shmMin, true},
+	} {
+		s := tw.shmAttach(c.need)
+		if s == nil {
+			t.Fatalf("need %d: no segment", c.need)
+		}
+		if len(s.data) != c.size {
+			t.Errorf("need %d: segment of %d bytes, expected %d", c.need, len(s.data), c.size)
+		}
+		n := f.count(tw, reqShmAttach)[reqShmAttach]
+		if attached := n != 0; attached != c.attached {
+			t.Errorf("need %d: attached %t, expected %t", c.need, attached, c.attached)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package x
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// The stacking order of subwindows is kept by the TermWindow so it
+// survives X windows being recreated. Windows that do not exist on the
+// server are skipped when restacking.
+
+func (t *TermWindow) stackIndex(w *SubWindow) int {
+	for i, s := range t.stack {
+		if s == w {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *TermWindow) stackRemove(w *SubWindow) {
+	if i := t.stackIndex(w); i != -1 {
+		t.stack = append(t.stack[:i], t.stack[i+1:]...)
+	}
+}
+
+func (t *TermWindow) stackInsert(i int, w *SubWindow) {
+	t.stack = append(t.stack, nil)
+	copy(t.stack[i+1:], t.stack[i:])
+	t.stack[i] = w
+}
+
+// place moves the X window of w to its position in the stack relative to
+// the closest sibling that exists on the server.
+func (t *TermWindow) place(w *SubWindow) {
+	if w.stacked == 0 {
+		return
+	}
+	i := t.stackIndex(w)
+	for _, s := range t.stack[i+1:] {
+		if s.stacked != 0 {
+			t.configureStack(w.stacked, s.stacked, xproto.StackModeBelow)
+			return
+		}
+	}
+	for j := i - 1; j >= 0; j-- {
+		if s := t.stack[j]; s.stacked != 0 {
+			t.configureStack(w.stacked, s.stacked, xproto.StackModeAbove)
+			return
+		}
+	}
+}
+
+func (t *TermWindow) configureStack(wnd, sibling xproto.Window, mode uint32) {
+	xproto.ConfigureWindow(
+		t.x,
+		wnd,
+		xproto.ConfigWindowSibling|xproto.ConfigWindowStackMode,
+		[]uint32{uint32(sibling), mode},
+	)
+}
+
+// stackCreated registers the X window of w and moves it into place.
+func (t *TermWindow) stackCreated(w *SubWindow, wnd xproto.Window) {
+	t.stackSem.Lock()
+	w.stacked = wnd
+	t.place(w)
+	t.stackSem.Unlock()
+}
+
+func (t *TermWindow) stackDestroyed(w *SubWindow) {
+	t.stackSem.Lock()
+	w.stacked = 0
+	t.stackSem.Unlock()
+}
+
+// move places w at index i of the stack.
+func (t *TermWindow) move(w *SubWindow, i int) {
+	t.stackSem.Lock()
+	defer t.stackSem.Unlock()
+	t.stackRemove(w)
+	if i < 0 || i > len(t.stack) {
+		i = len(t.stack)
+	}
+	t.stackInsert(i, w)
+	t.place(w)
+}
+
+// moveRel places w directly above or below the subwindow named sibling.
+func (t *TermWindow) moveRel(w *SubWindow, sibling string, above bool) error {
+	t.sem.RLock()
+	s, ok := t.windows[sibling]
+	t.sem.RUnlock()
+	if !ok {
+		return fmt.Errorf("no such subwindow: '%s'", sibling)
+	}
+	if s == w {
+		return nil
+	}
+
+	t.stackSem.Lock()
+	defer t.stackSem.Unlock()
+	t.stackRemove(w)
+	i := t.stackIndex(s)
+	if above {
+		i++
+	}
+	t.stackInsert(i, w)
+	t.place(w)
+	return nil
+}
+
+// Restack orders the named subwindows from bottom to top, above all
+// subwindows that are not named.
+func (t *TermWindow) Restack(names ...string) error {
+	t.sem.RLock()
+	ws := make([]*SubWindow, 0, len(names))
+	for _, n := range names {
+		w, ok := t.windows[n]
+		if !ok {
+			t.sem.RUnlock()
+			return fmt.Errorf("no such subwindow: '%s'", n)
+		}
+		ws = append(ws, w)
+	}
+	t.sem.RUnlock()
+
+	t.stackSem.Lock()
+	defer t.stackSem.Unlock()
+	for _, w := range ws {
+		t.stackRemove(w)
+	}
+	t.stack = append(t.stack, ws...)
+	t.placeAll()
+	return nil
+}
+
+// placeAll moves the X windows of all subwindows into stacking order, from
+// the top down so every window is placed below one already in place.
+func (t *TermWindow) placeAll() {
+	var above xproto.Window
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		w := t.stack[i]
+		if w.stacked == 0 {
+			continue
+		}
+		if above == 0 {
+			xproto.ConfigureWindow(
+				t.x,
+				w.stacked,
+				xproto.ConfigWindowStackMode,
+				[]uint32{xproto.StackModeAbove},
+			)
+		} else {
+			t.configureStack(w.stacked, above, xproto.StackModeBelow)
+		}
+		above = w.stacked
+	}
+}
+
+// Stack returns the names of all subwindows from bottom to top.
+func (t *TermWindow) Stack() []string {
+	t.stackSem.Lock()
+	defer t.stackSem.Unlock()
+	names := make([]string, len(t.stack))
+	for i, w := range t.stack {
+		names[i] = w.name
+	}
+	return names
+}
+
+// Raise moves the subwindow above all others.
+func (w *SubWindow) Raise() { w.t.move(w, -1) }
+
+// Lower moves the subwindow below all others.
+func (w *SubWindow) Lower() { w.t.move(w, 0) }
+
+// Above moves the subwindow directly above the subwindow named sibling.
+func (w *SubWindow) Above(sibling string) error { return w.t.moveRel(w, sibling, true) }
+
+// Below moves the subwindow directly below the subwindow named sibling.
+func (w *SubWindow) Below(sibling string) error { return w.t.moveRel(w, sibling, false) }
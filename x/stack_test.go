@@ -0,0 +1,69 @@
+package x
+
+import (
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// TestStack checks the order of the X windows after each way of
+// restacking subwindows.
+func TestStack(t *testing.T) {
+	depth, formats := fakeDepth24()
+	tw, f := newFakeX(t, formats, depth)
+
+	names := make(map[xproto.Window]string)
+	for _, n := range []string{"a", "b", "c", "d"} {
+		w := tw.SubWindow(n)
+		w.SetImage(NewImage(image.NewRGBA(image.Rect(0, 0, 8, 8))))
+		w.SetGeometry(image.Rect(0, 0, 8, 8))
+		w.Show()
+		if err := w.Render(); err != nil {
+			t.Fatal(err)
+		}
+		names[w.wnd] = n
+	}
+	win := func(n string) *SubWindow {
+		tw.sem.RLock()
+		defer tw.sem.RUnlock()
+		return tw.windows[n]
+	}
+
+	check := func(step, exp string) {
+		t.Helper()
+		var got []string
+		for _, wnd := range f.stacking(tw, tw.wnd) {
+			got = append(got, names[wnd])
+		}
+		if s := fmt.Sprint(got); s != exp {
+			t.Errorf("%s: X stacking %s, expected %s", step, s, exp)
+		}
+		if s := fmt.Sprint(tw.Stack()); s != exp {
+			t.Errorf("%s: stack %s, expected %s", step, s, exp)
+		}
+	}
+
+	check("create", "[a b c d]")
+	win("a").Raise()
+	check("raise", "[b c d a]")
+	win("d").Lower()
+	check("lower", "[d b c a]")
+	if err := win("a").Below("b"); err != nil {
+		t.Fatal(err)
+	}
+	check("below", "[d a b c]")
+	if err := win("d").Above("b"); err != nil {
+		t.Fatal(err)
+	}
+	check("above", "[a b d c]")
+	if err := tw.Restack("b", "d", "a"); err != nil {
+		t.Fatal(err)
+	}
+	check("restack", "[c b d a]")
+	if err := tw.Restack("a", "b", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	check("restack all", "[a b c d]")
+}
@@ -0,0 +1,151 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrGeometry is returned by Render when (part of) a subwindow lies beyond
+// the coordinates the X server can address.
+var ErrGeometry = errors.New("geometry exceeds X11 coordinate limits")
+
+const (
+	minCoord = -1 << 15
+	maxCoord = 1<<15 - 1
+	// maxSize is the largest window dimension of which every pixel can be
+	// drawn to using 16-bit coordinates and maxCoord sized tiles.
+	maxSize = 2 * maxCoord
+)
+
+// tile is a pixmap holding part of the image of a subwindow.
+type tile struct {
+	r      image.Rectangle
+	pixmap xproto.Pixmap
+}
+
+// tileRects splits r into rectangles no larger than maxCoord.
+func tileRects(r image.Rectangle) []image.Rectangle {
+	var rects []image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y += maxCoord {
+		for x := r.Min.X; x < r.Max.X; x += maxCoord {
+			rects = append(rects, image.Rect(x, y, x+maxCoord, y+maxCoord).Intersect(r))
+		}
+	}
+	return rects
+}
+
+// clipGeometry clips the size of a window at p to what can be drawn. The
+// returned size is empty if the window can not be created.
+func clipGeometry(p image.Point, size Dimensions) (Dimensions, error) {
+	if p.X < minCoord || p.X > maxCoord || p.Y < minCoord || p.Y > maxCoord {
+		return Dimensions{}, fmt.Errorf("%w: window position %d,%d", ErrGeometry, p.X, p.Y)
+	}
+	var err error
+	if size.W > maxSize || size.H > maxSize {
+		err = fmt.Errorf("%w: window size %dx%d clipped", ErrGeometry, size.W, size.H)
+	}
+	if size.W > maxSize {
+		size.W = maxSize
+	}
+	if size.H > maxSize {
+		size.H = maxSize
+	}
+	return size, err
+}
+
+// createTiles uploads the part of w.img that fits size.
+func (w *SubWindow) createTiles(size Dimensions) error {
+	if w.vis.fmtErr != nil {
+		return w.vis.fmtErr
+	}
+	if w.gc != 0 && w.gcDepth != w.vis.depth.Depth {
+		xproto.FreeGC(w.t.x, w.gc)
+		w.gc = 0
+	}
+
+	w.tilesFor = size
+	r := w.img.Rect.Intersect(image.Rect(0, 0, size.W, size.H))
+	for _, tr := range tileRects(r) {
+		pixmap, err := xproto.NewPixmapId(w.t.x)
+		if err != nil {
+			return err
+		}
+		xproto.CreatePixmap(
+			w.t.x,
+			w.vis.depth.Depth,
+			pixmap,
+			xproto.Drawable(w.t.wnd),
+			uint16(tr.Dx()),
+			uint16(tr.Dy()),
+		)
+		w.tiles = append(w.tiles, tile{r: tr, pixmap: pixmap})
+
+		if w.gc == 0 {
+			gc, err := xproto.NewGcontextId(w.t.x)
+			if err != nil {
+				return err
+			}
+			w.gc, w.gcDepth = gc, w.vis.depth.Depth
+			xproto.CreateGC(w.t.x, w.gc, xproto.Drawable(pixmap), 0, nil)
+		}
+
+		err = w.t.putImage(
+			w.vis.format,
+			w.img.SubImage(tr).(*BGRA),
+			xproto.Drawable(pixmap),
+			w.gc,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tilesCover reports whether the tiles hold all of w.img that is visible
+// in a window of the given size.
+func (w *SubWindow) tilesCover(size Dimensions) bool {
+	r := w.img.Rect.Intersect(image.Rect(0, 0, size.W, size.H))
+	return r.In(w.img.Rect.Intersect(image.Rect(0, 0, w.tilesFor.W, w.tilesFor.H)))
+}
+
+func freePixmaps(t *TermWindow, tiles []tile) {
+	for _, tile := range tiles {
+		xproto.FreePixmap(t.x, tile.pixmap)
+	}
+}
+
+func (w *SubWindow) freeTiles() {
+	freePixmaps(w.t, w.tiles)
+	if w.gc != 0 {
+		xproto.FreeGC(w.t.x, w.gc)
+	}
+	w.tiles, w.gc = nil, 0
+}
+
+// copyTiles draws the tiles that intersect the width x height area of the
+// window.
+func (w *SubWindow) copyTiles(width, height int) {
+	area := image.Rect(0, 0, width, height)
+	for _, t := range w.tiles {
+		r := t.r.Intersect(area)
+		if r.Empty() {
+			continue
+		}
+		xproto.CopyArea(
+			w.t.x,
+			xproto.Drawable(t.pixmap),
+			xproto.Drawable(w.wnd),
+			w.gc,
+			0,
+			0,
+			int16(r.Min.X),
+			int16(r.Min.Y),
+			uint16(r.Dx()),
+			uint16(r.Dy()),
+		)
+	}
+}
@@ -0,0 +1,238 @@
+package x
+
+import (
+	"fmt"
+	"image"
+)
+
+// Transform describes geometric transformations applied to an Image
+// before it is scaled: Crop first, then the flips, then Rotate.
+type Transform struct {
+	// Crop, if not empty, is the region of the source image to show.
+	Crop image.Rectangle
+	// FlipH mirrors horizontally, FlipV vertically.
+	FlipH, FlipV bool
+	// Rotate clockwise by 0, 90, 180 or 270 degrees.
+	Rotate int
+}
+
+// Validate checks whether t.Rotate is a multiple of 90 in [0, 360).
+func (t Transform) Validate() error {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+		return nil
+	}
+	return fmt.Errorf("invalid rotation %d, should be 0, 90, 180 or 270", t.Rotate)
+}
+
+func (t Transform) swap() bool { return t.Rotate == 90 || t.Rotate == 270 }
+
+func (t Transform) orients() bool { return t.FlipH || t.FlipV || t.Rotate != 0 }
+
+// transformImage applies a Transform to an Image. Flips and rotations are
+// applied to the scaled image, crops to the full resolution source.
+type transformImage struct {
+	src Image
+	t   Transform
+
+	full    *BGRA
+	cropped Image
+	out     *BGRA
+
+	// level is the oriented copy of the mipmap level lsrc.
+	lsrc  *BGRA
+	level *BGRA
+}
+
+// NewTransformImage wraps img so that its Bounds, Resize and BGRA reflect
+// the transformed image.
+func NewTransformImage(img Image, t Transform) Image {
+	return &transformImage{src: img, t: t}
+}
+
+// base returns the Image to resize, i.e.: the cropped source.
+func (t *transformImage) base() Image {
+	if t.t.Crop.Empty() || !imageLoaded(t.src) {
+		return t.src
+	}
+
+	src := t.src
+	if l, ok := src.(*lazyImage); ok {
+		src = l.image()
+	}
+	if v, ok := src.(*vectorImage); ok {
+		if c, ok := t.cropped.(*cropVector); !ok || c.v != v {
+			t.cropped = newCropVector(v, t.t.Crop)
+		}
+		return t.cropped
+	}
+
+	t.src.Reset()
+	full := t.src.BGRA()
+	if full != t.full || t.cropped == nil {
+		t.full = full
+		t.cropped = NewImage(full.SubImage(t.t.Crop.Add(full.Rect.Min)))
+	}
+	return t.cropped
+}
+
+// cropVector is a cropped vector image, the crop is rasterized at the size
+// passed to Resize instead of being cut from the intrinsic size.
+type cropVector struct {
+	v    *vectorImage
+	crop image.Rectangle
+	out  *BGRA
+}
+
+func newCropVector(v *vectorImage, crop image.Rectangle) *cropVector {
+	return &cropVector{v: v, crop: crop.Intersect(v.rect)}
+}
+
+func (c *cropVector) Bounds() image.Rectangle {
+	return image.Rect(0, 0, c.crop.Dx(), c.crop.Dy())
+}
+
+func (c *cropVector) Reset() {
+	if c.out != nil && c.out.Rect.Size() != c.crop.Size() {
+		c.out = nil
+	}
+}
+
+func (c *cropVector) Resize(w, h int) {
+	if c.out != nil && c.out.Rect.Dx() == w && c.out.Rect.Dy() == h {
+		return
+	}
+	c.out = c.Region(
+		float64(w)/float64(c.crop.Dx()),
+		float64(h)/float64(c.crop.Dy()),
+		0,
+		0,
+		w,
+		h,
+	)
+}
+
+func (c *cropVector) BGRA() *BGRA {
+	if c.out == nil {
+		c.Resize(c.crop.Dx(), c.crop.Dy())
+	}
+	return c.out
+}
+
+func (t *transformImage) Bounds() image.Rectangle {
+	b := t.src.Bounds()
+	if !t.t.Crop.Empty() {
+		b = t.t.Crop.Add(b.Min).Intersect(b)
+	}
+	w, h := b.Dx(), b.Dy()
+	if t.t.swap() {
+		w, h = h, w
+	}
+	return image.Rect(0, 0, w, h)
+}
+
+func (t *transformImage) Reset() {
+	t.base().Reset()
+	t.out = nil
+}
+
+func (t *transformImage) Resize(w, h int) {
+	if t.t.swap() {
+		w, h = h, w
+	}
+	t.base().Resize(w, h)
+	t.out = nil
+}
+
+func (t *transformImage) BGRA() *BGRA {
+	if t.out != nil {
+		return t.out
+	}
+	img := t.base().BGRA()
+	if t.t.orients() {
+		img = orient(img, t.t)
+	}
+	t.out = img
+	return img
+}
+
+// Level orients a mipmap level of the cropped source, the last one is
+// cached.
+func (t *transformImage) Level(w, h int) *BGRA {
+	if t.t.swap() {
+		w, h = h, w
+	}
+	src := imageLevel(t.base(), w, h)
+	if !t.t.orients() {
+		return src
+	}
+	if src != t.lsrc {
+		t.lsrc, t.level = src, orient(src, t.t)
+	}
+	return t.level
+}
+
+// unorient maps a point of the transformed image to the cropped source,
+// the inverse of orient.
+func (t *transformImage) unorient(x, y float64) (float64, float64) {
+	b := t.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	if t.t.swap() {
+		w, h = h, w
+	}
+	u, v := x, y
+	switch t.t.Rotate {
+	case 90:
+		u, v = y, h-x
+	case 180:
+		u, v = w-x, h-y
+	case 270:
+		u, v = w-y, x
+	}
+	if t.t.FlipH {
+		u = w - u
+	}
+	if t.t.FlipV {
+		v = h - v
+	}
+	return u, v
+}
+
+// orient flips and rotates src.
+func orient(src *BGRA, t Transform) *BGRA {
+	sb := src.Rect
+	w, h := sb.Dx(), sb.Dy()
+	dw, dh := w, h
+	if t.swap() {
+		dw, dh = h, w
+	}
+	dst := NewBGRA(image.Rect(0, 0, dw, dh))
+
+	parallelRows(dst.Rect, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.Pix[dst.PixOffset(0, y):]
+			for x := 0; x < dw; x++ {
+				var u, v int
+				switch t.Rotate {
+				case 90:
+					u, v = y, h-1-x
+				case 180:
+					u, v = w-1-x, h-1-y
+				case 270:
+					u, v = w-1-y, x
+				default:
+					u, v = x, y
+				}
+				if t.FlipH {
+					u = w - 1 - u
+				}
+				if t.FlipV {
+					v = h - 1 - v
+				}
+				o := src.PixOffset(sb.Min.X+u, sb.Min.Y+v)
+				copy(d[4*x:4*x+4], src.Pix[o:o+4])
+			}
+		}
+	})
+	return dst
+}
@@ -0,0 +1,310 @@
+package x
+
+import (
+	"image"
+	"math"
+
+	"github.com/frizinak/zug/format"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// regionImage is implemented by Images that can resample a region of
+// themselves without scaling the entire image first.
+type regionImage interface {
+	// Region returns the w x h pixels, scaled by zx horizontally and zy
+	// vertically, whose top left corner is at x, y in source pixels.
+	Region(zx, zy, x, y float64, w, h int) *BGRA
+}
+
+// region renders a region of img, see regionImage.
+func region(img Image, zx, zy, x, y float64, w, h int) *BGRA {
+	if r, ok := img.(regionImage); ok {
+		return r.Region(zx, zy, x, y, w, h)
+	}
+	img.Reset()
+	return renderRegion(img.BGRA(), zx, zy, x, y, w, h)
+}
+
+// renderRegion resamples only the part of src that is visible in a w x h
+// destination.
+func renderRegion(src *BGRA, zx, zy, x, y float64, w, h int) *BGRA {
+	dst := NewBGRA(image.Rect(0, 0, w, h))
+	min := src.Rect.Min
+	sr := image.Rect(
+		int(math.Floor(x))-1,
+		int(math.Floor(y))-1,
+		int(math.Ceil(x+float64(w)/zx))+1,
+		int(math.Ceil(y+float64(h)/zy))+1,
+	).Add(min).Intersect(src.Rect)
+
+	s2d := f64.Aff3{
+		zx, 0, -(float64(min.X) + x) * zx,
+		0, zy, -(float64(min.Y) + y) * zy,
+	}
+	var s draw.Transformer = draw.ApproxBiLinear
+	if zx >= 4 && zy >= 4 {
+		s = draw.NearestNeighbor
+	}
+	s.Transform(dst, s2d, src, sr, draw.Src, nil)
+	return dst
+}
+
+// Region resamples from the smallest mipmap level that is at least as
+// large as the zoomed image.
+func (n *nativeImage) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	b := n.in.Rect
+	src := n.Level(
+		int(math.Ceil(float64(b.Dx())*zx)),
+		int(math.Ceil(float64(b.Dy())*zy)),
+	)
+	fx := float64(src.Rect.Dx()) / float64(b.Dx())
+	fy := float64(src.Rect.Dy()) / float64(b.Dy())
+	return renderRegion(src, zx/fx, zy/fy, x*fx, y*fy, w, h)
+}
+
+// Region rasterizes only the visible part of the zoomed image if the
+// vector supports it, see format.RegionVector.
+func (v *vectorImage) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	if _, ok := v.v.(format.RegionVector); !ok {
+		v.Reset()
+		return renderRegion(v.BGRA(), zx, zy, x, y, w, h)
+	}
+	return v.region(zx, zy, x, y, w, h, v.rect)
+}
+
+// region rasterizes the part of the zoomed image visible in a w x h
+// destination, leaving pixels outside of clip, in source coordinates,
+// transparent. Vectors that are not a format.RegionVector are rasterized
+// entirely at the zoomed size.
+func (v *vectorImage) region(zx, zy, x, y float64, w, h int, clip image.Rectangle) *BGRA {
+	full := image.Rect(
+		0,
+		0,
+		int(math.Ceil(float64(v.rect.Dx())*zx)),
+		int(math.Ceil(float64(v.rect.Dy())*zy)),
+	)
+	visible := image.Rect(
+		int(math.Round(float64(clip.Min.X)*zx)),
+		int(math.Round(float64(clip.Min.Y)*zy)),
+		int(math.Ceil(float64(clip.Max.X)*zx)),
+		int(math.Ceil(float64(clip.Max.Y)*zy)),
+	).Intersect(full)
+	o := image.Pt(int(math.Round(x*zx)), int(math.Round(y*zy)))
+	dst := NewBGRA(image.Rect(0, 0, w, h).Add(o))
+	if r := dst.Rect.Intersect(visible); !r.Empty() {
+		var src image.Image
+		if rv, ok := v.v.(format.RegionVector); ok {
+			src = rv.RasterizeRegion(full.Dx(), full.Dy(), r)
+		} else {
+			src = v.v.Rasterize(full.Dx(), full.Dy())
+		}
+		Convert(dst, src, r)
+	}
+	dst.Rect = dst.Rect.Sub(o)
+	return dst
+}
+
+// Region renders the region from the vector, offset by the crop.
+func (c *cropVector) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	return c.v.region(
+		zx,
+		zy,
+		x+float64(c.crop.Min.X),
+		y+float64(c.crop.Min.Y),
+		w,
+		h,
+		c.crop,
+	)
+}
+
+// Region maps the region through the orientation, renders it from the
+// cropped source and orients the result.
+func (t *transformImage) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	bzx, bzy, bw, bh := zx, zy, w, h
+	if t.t.swap() {
+		bzx, bzy, bw, bh = zy, zx, h, w
+	}
+	u0, v0 := t.unorient(x, y)
+	u1, v1 := t.unorient(x+float64(w)/zx, y+float64(h)/zy)
+	img := region(t.base(), bzx, bzy, math.Min(u0, u1), math.Min(v0, v1), bw, bh)
+	if t.t.orients() {
+		img = orient(img, t.t)
+	}
+	return img
+}
+
+func (l *lazyImage) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	if img := l.image(); img != nil {
+		return region(img, zx, zy, x, y, w, h)
+	}
+	return &BGRA{}
+}
+
+func (p *pagedImage) Region(zx, zy, x, y float64, w, h int) *BGRA {
+	return region(p.Image, zx, zy, x, y, w, h)
+}
+
+// viewportSize returns the size of the window showing the zoomed image.
+func (w *SubWindow) viewportSize() Dimensions {
+	b := w.view.Bounds()
+	d := Dimensions{
+		W: int(math.Ceil(float64(b.Dx()) * w.zoom)),
+		H: int(math.Ceil(float64(b.Dy()) * w.zoom)),
+	}
+	if d.W > w.geom.Dx() {
+		d.W = w.geom.Dx()
+	}
+	if d.H > w.geom.Dy() {
+		d.H = w.geom.Dy()
+	}
+	return d
+}
+
+// clampPan keeps the viewport within the image.
+func (w *SubWindow) clampPan() {
+	if w.view == nil || w.zoom <= 0 {
+		return
+	}
+	b, d := w.view.Bounds(), w.viewportSize()
+	maxX := float64(b.Dx()) - float64(d.W)/w.zoom
+	maxY := float64(b.Dy()) - float64(d.H)/w.zoom
+	w.panX = math.Max(0, math.Min(w.panX, maxX))
+	w.panY = math.Max(0, math.Min(w.panY, maxY))
+}
+
+// Zoom returns the zoom factor set by SetZoom, 0 if the image is fitted by
+// the scaler.
+func (w *SubWindow) Zoom() float64 {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	return w.zoom
+}
+
+// SetZoom shows the image at the given zoom factor, 1 being one window
+// pixel per image pixel, keeping the center of the viewport in place.
+// Only the visible part of the image is resampled. A zoom of 0 returns to
+// fitting the entire image using the scaler.
+func (w *SubWindow) SetZoom(zoom float64) {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if w.zoom <= 0 || zoom <= 0 || w.view == nil {
+		w.setZoom(zoom, image.Point{})
+		return
+	}
+	d := w.viewportSize()
+	w.setZoom(zoom, image.Pt(d.W/2, d.H/2))
+}
+
+// ZoomAt zooms while keeping the image pixel at the given window pixel in
+// place, e.g.: under the mouse cursor.
+func (w *SubWindow) ZoomAt(zoom float64, at image.Point) {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	w.setZoom(zoom, at)
+}
+
+func (w *SubWindow) setZoom(zoom float64, at image.Point) {
+	if zoom < 0 {
+		zoom = 0
+	}
+	if w.zoom > 0 && zoom > 0 {
+		x, y := w.windowToSource(at)
+		w.panX = x - float64(at.X)/zoom
+		w.panY = y - float64(at.Y)/zoom
+	} else {
+		w.panX, w.panY = 0, 0
+	}
+	w.zoom = zoom
+	w.clampPan()
+	w.img = nil
+	w.change = true
+}
+
+// Pan moves the viewport by the given amount of window pixels, clamped so
+// it stays within the image.
+func (w *SubWindow) Pan(dx, dy int) {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if w.zoom <= 0 {
+		return
+	}
+	w.panTo(w.panX+float64(dx)/w.zoom, w.panY+float64(dy)/w.zoom)
+}
+
+// PanTo moves the top left corner of the viewport to the given image
+// pixel, clamped so it stays within the image.
+func (w *SubWindow) PanTo(x, y float64) {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	w.panTo(x, y)
+}
+
+func (w *SubWindow) panTo(x, y float64) {
+	w.panX, w.panY = x, y
+	w.clampPan()
+	w.img = nil
+	w.change = true
+}
+
+// Viewport returns the part of the (transformed) image that is visible.
+func (w *SubWindow) Viewport() image.Rectangle {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if w.view == nil {
+		return image.Rectangle{}
+	}
+	if w.zoom <= 0 {
+		return w.view.Bounds()
+	}
+	d := w.viewportSize()
+	return image.Rect(
+		int(math.Floor(w.panX)),
+		int(math.Floor(w.panY)),
+		int(math.Ceil(w.panX+float64(d.W)/w.zoom)),
+		int(math.Ceil(w.panY+float64(d.H)/w.zoom)),
+	)
+}
+
+// WindowToSource maps a pixel in the window, relative to the geometry set
+// by SetGeometry, to a pixel of the (transformed) image.
+func (w *SubWindow) WindowToSource(p image.Point) image.Point {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	x, y := w.windowToSource(p)
+	return image.Pt(int(math.Floor(x)), int(math.Floor(y)))
+}
+
+// SourceToWindow maps a pixel of the (transformed) image to a pixel in the
+// window, relative to the geometry set by SetGeometry.
+func (w *SubWindow) SourceToWindow(p image.Point) image.Point {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	sx, sy, tx, ty := w.mapping()
+	return image.Pt(
+		int(math.Floor((float64(p.X)-tx)/sx)),
+		int(math.Floor((float64(p.Y)-ty)/sy)),
+	)
+}
+
+func (w *SubWindow) windowToSource(p image.Point) (float64, float64) {
+	sx, sy, tx, ty := w.mapping()
+	return float64(p.X)*sx + tx, float64(p.Y)*sy + ty
+}
+
+// mapping returns the scale, in image pixels per window pixel, and the
+// image pixel at the top left corner of the subwindow's geometry. It is
+// renderTransform taking the offset of the scaled window into account.
+func (w *SubWindow) mapping() (sx, sy, tx, ty float64) {
+	if w.view == nil || w.view.Bounds().Empty() {
+		return 1, 1, 0, 0
+	}
+	_, g := w.geometry()
+	if g.Image.W == 0 || g.Image.H == 0 {
+		return 1, 1, 0, 0
+	}
+	sx, sy, tx, ty = w.renderTransform(g)
+	tx -= float64(g.Offset.X) * sx
+	ty -= float64(g.Offset.Y) * sy
+	return
+}
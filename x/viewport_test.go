@@ -0,0 +1,207 @@
+package x
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/frizinak/zug/format"
+)
+
+// TestTransformRegion compares rendering a region of a transformed image
+// with rendering it from the fully transformed image. Zooms of 4 and more
+// use nearest neighbour sampling which makes them exact, as long as no
+// pixel center falls on the edge of a source pixel.
+func TestTransformRegion(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	src := NewBGRA(image.Rect(0, 0, 40, 30))
+	randBytes(rnd, src.Pix)
+	premultiply(src.Pix, 4)
+
+	for _, tr := range []Transform{
+		{},
+		{FlipH: true},
+		{FlipV: true},
+		{Rotate: 90},
+		{Rotate: 180},
+		{Rotate: 270, FlipH: true},
+		{Rotate: 90, FlipV: true},
+		{Crop: image.Rect(5, 3, 33, 27), Rotate: 270},
+	} {
+		for _, c := range []struct {
+			zx, zy, x, y float64
+			w, h         int
+		}{
+			{4, 4, 0, 0, 17, 13},
+			{4, 5, 2.3, 1.65, 23, 19},
+			{6, 4, 7.1, 3.4, 40, 30},
+		} {
+			t.Run(fmt.Sprintf("%+v/%v", tr, c), func(t *testing.T) {
+				img := NewTransformImage(NewImage(src), tr)
+				got := region(img, c.zx, c.zy, c.x, c.y, c.w, c.h)
+				img.Reset()
+				exp := renderRegion(img.BGRA(), c.zx, c.zy, c.x, c.y, c.w, c.h)
+				if got.Rect != exp.Rect {
+					t.Fatalf("bounds %v, expected %v", got.Rect, exp.Rect)
+				}
+				if d := diffBGRA(got, exp); d != "" {
+					t.Fatal(d)
+				}
+			})
+		}
+	}
+}
+
+// testVector has a distinct opaque color for each pixel of a raster.
+type testVector struct{ w, h float64 }
+
+func (v testVector) Size() (float64, float64) { return v.w, v.h }
+
+func (v testVector) Rasterize(w, h int) image.Image {
+	return v.RasterizeRegion(w, h, image.Rect(0, 0, w, h))
+}
+
+func (v testVector) RasterizeRegion(w, h int, r image.Rectangle) image.Image {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x>>8 | y>>8<<4), 255})
+		}
+	}
+	return img
+}
+
+func TestVectorRegion(t *testing.T) {
+	v := testVector{30, 20}
+	img := NewVectorImage(v)
+	const zoom = 8
+	full := ImageToBGRA(v.Rasterize(30*zoom, 20*zoom))
+
+	for _, c := range []struct {
+		x, y float64
+		w, h int
+	}{
+		{0, 0, 100, 80},
+		{10, 5, 120, 60},
+		{25.5, 17.25, 100, 50},
+	} {
+		got := region(img, zoom, zoom, c.x, c.y, c.w, c.h)
+		if got.Rect != image.Rect(0, 0, c.w, c.h) {
+			t.Fatalf("%v: bounds %v", c, got.Rect)
+		}
+		o := image.Pt(int(c.x*zoom), int(c.y*zoom))
+		for y := 0; y < c.h; y++ {
+			for x := 0; x < c.w; x++ {
+				var e color.RGBA
+				if p := image.Pt(x, y).Add(o); p.In(full.Rect) {
+					e = full.RGBAAt(p.X, p.Y)
+				}
+				if g := got.RGBAAt(x, y); g != e {
+					t.Fatalf("%v: pixel %d,%d: got %v, expected %v", c, x, y, g, e)
+				}
+			}
+		}
+	}
+
+	// Vectors that can only be rasterized entirely are resampled.
+	img = NewVectorImage(struct{ format.Vector }{v})
+	if got := region(img, zoom, zoom, 10, 5, 120, 60); got.Rect != image.Rect(0, 0, 120, 60) {
+		t.Fatalf("bounds %v", got.Rect)
+	}
+}
+
+// TestCropVector checks that a cropped vector is rasterized at the zoomed
+// size rather than cropped from its intrinsic size and resampled.
+func TestCropVector(t *testing.T) {
+	v := testVector{30, 20}
+	const zoom = 8
+	full := ImageToBGRA(v.Rasterize(30*zoom, 20*zoom))
+	crop := image.Rect(10, 5, 20, 15)
+
+	for _, vec := range []format.Vector{v, struct{ format.Vector }{v}} {
+		img := NewTransformImage(NewVectorImage(vec), Transform{Crop: crop})
+		if b := img.Bounds(); b != image.Rect(0, 0, 10, 10) {
+			t.Fatalf("bounds %v", b)
+		}
+
+		img.Resize(10*zoom, 10*zoom)
+		got := img.BGRA()
+		// Part of the region lies below the crop and should stay empty.
+		reg := region(img, zoom, zoom, 2, 4, 40, 60)
+		for y := 0; y < 10*zoom; y++ {
+			for x := 0; x < 10*zoom; x++ {
+				e := full.RGBAAt(x+crop.Min.X*zoom, y+crop.Min.Y*zoom)
+				if g := got.RGBAAt(x, y); g != e {
+					t.Fatalf("%T: pixel %d,%d: got %v, expected %v", vec, x, y, g, e)
+				}
+			}
+		}
+		for y := 0; y < 60; y++ {
+			for x := 0; x < 40; x++ {
+				var e color.RGBA
+				if y < 48 {
+					e = full.RGBAAt(x+(crop.Min.X+2)*zoom, y+(crop.Min.Y+4)*zoom)
+				}
+				if g := reg.RGBAAt(x, y); g != e {
+					t.Fatalf("%T: region pixel %d,%d: got %v, expected %v", vec, x, y, g, e)
+				}
+			}
+		}
+	}
+}
+
+// TestMapping checks WindowToSource and SourceToWindow for geometries that
+// crop the image or offset the window.
+func TestMapping(t *testing.T) {
+	depth, formats := fakeDepth24()
+	tw, _ := newFakeX(t, formats, depth)
+	w := tw.SubWindow("a")
+	w.SetImage(NewImage(image.NewRGBA(image.Rect(0, 0, 400, 300))))
+
+	type pair struct{ window, source image.Point }
+	for _, c := range []struct {
+		scaler ScaleMethod
+		zoom   float64
+		width  int
+		pairs  []pair
+	}{
+		// 200x150 at 0,0.
+		{ScaleRatio, 0, 200, []pair{
+			{image.Pt(0, 0), image.Pt(0, 0)},
+			{image.Pt(100, 100), image.Pt(200, 200)},
+		}},
+		// 200x150 at 0,25.
+		{ScaleCenterUpscale, 0, 200, []pair{
+			{image.Pt(0, 25), image.Pt(0, 0)},
+			{image.Pt(100, 100), image.Pt(200, 150)},
+		}},
+		// 300x300 from 50,0 scaled to 200x200.
+		{ScaleCover, 0, 200, []pair{
+			{image.Pt(0, 0), image.Pt(50, 0)},
+			{image.Pt(100, 100), image.Pt(200, 150)},
+		}},
+		// 400x100 from 0,0 scaled to 800x200.
+		{ScaleFitWidth, 0, 800, []pair{
+			{image.Pt(0, 0), image.Pt(0, 0)},
+			{image.Pt(100, 50), image.Pt(50, 25)},
+		}},
+		{ScaleCover, 2, 200, []pair{
+			{image.Pt(0, 0), image.Pt(0, 0)},
+			{image.Pt(100, 50), image.Pt(50, 25)},
+		}},
+	} {
+		w.SetGeometry(image.Rect(0, 0, c.width, 200))
+		w.SetScaler(c.scaler)
+		w.SetZoom(c.zoom)
+		for _, p := range c.pairs {
+			if got := w.WindowToSource(p.window); got != p.source {
+				t.Errorf("scaler %d zoom %g: window %v maps to %v, expected %v", c.scaler, c.zoom, p.window, got, p.source)
+			}
+			if got := w.SourceToWindow(p.source); got != p.window {
+				t.Errorf("scaler %d zoom %g: source %v maps to %v, expected %v", c.scaler, c.zoom, p.source, got, p.window)
+			}
+		}
+	}
+}
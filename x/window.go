@@ -2,24 +2,230 @@ package x
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"sync"
 
 	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/bigreq"
+	"github.com/jezek/xgb/render"
 	"github.com/jezek/xgb/xproto"
 )
 
+// initWindows selects the visual, depth and colormap of the terminal
+// window so subwindows can be created as its children, falling back to
+// those of the root window.
 func (t *TermWindow) initWindows() {
+	t.sem.Lock()
+	defer t.sem.Unlock()
 	if t.depth.Depth != 0 {
 		return
 	}
 
-	setup := xproto.Setup(t.x)
-	screen := setup.DefaultScreen(t.x)
-	depth := screen.AllowedDepths[0]
-	visual := depth.Visuals[0]
-	t.depth = depth
-	t.visual = visual
+	screen := xproto.Setup(t.x).DefaultScreen(t.x)
+	id, cmap := screen.RootVisual, screen.DefaultColormap
+	if attr, err := xproto.GetWindowAttributes(t.x, t.wnd).Reply(); err == nil {
+		if _, _, ok := FindVisual(screen, attr.Visual); ok {
+			id, cmap = attr.Visual, attr.Colormap
+		}
+	}
+
+	depth, visual, ok := FindVisual(screen, id)
+	if !ok {
+		depth = screen.AllowedDepths[0]
+		visual = depth.Visuals[0]
+	}
+	if visual.Class != xproto.VisualClassTrueColor {
+		// E.g.: DirectColor, whose colormap can not be relied upon.
+		if d, v, ok := findTrueColor(screen, depth.Depth); ok {
+			depth, visual, cmap = d, v, 0
+		}
+	}
+	t.depth, t.visual = depth, visual
+	t.colormap, t.ownCmap = cmap, false
+	if cmap == 0 {
+		t.colormap, t.ownCmap = t.createColormap(screen, visual.VisualId)
+	}
+	t.format, t.fmtErr = NewPixelFormat(xproto.Setup(t.x), depth.Depth, visual)
+}
+
+func (t *TermWindow) createColormap(
+	screen *xproto.ScreenInfo,
+	visual xproto.Visualid,
+) (xproto.Colormap, bool) {
+	cmap, err := xproto.NewColormapId(t.x)
+	if err != nil {
+		return 0, false
+	}
+	err = xproto.CreateColormapChecked(
+		t.x,
+		xproto.ColormapAllocNone,
+		cmap,
+		screen.Root,
+		visual,
+	).Check()
+	if err != nil {
+		return 0, false
+	}
+	return cmap, true
+}
+
+// FindVisual returns the depth and info of the given visual on screen.
+func FindVisual(
+	screen *xproto.ScreenInfo,
+	id xproto.Visualid,
+) (xproto.DepthInfo, xproto.VisualInfo, bool) {
+	for _, depth := range screen.AllowedDepths {
+		for _, visual := range depth.Visuals {
+			if visual.VisualId == id {
+				return depth, visual, true
+			}
+		}
+	}
+	return xproto.DepthInfo{}, xproto.VisualInfo{}, false
+}
+
+// findTrueColor returns a TrueColor visual of the given depth or, if
+// there is none, of the largest depth.
+func findTrueColor(
+	screen *xproto.ScreenInfo,
+	depth byte,
+) (xproto.DepthInfo, xproto.VisualInfo, bool) {
+	var bd xproto.DepthInfo
+	var bv xproto.VisualInfo
+	found := false
+	for _, d := range screen.AllowedDepths {
+		for _, v := range d.Visuals {
+			if v.Class != xproto.VisualClassTrueColor {
+				continue
+			}
+			if d.Depth == depth {
+				return d, v, true
+			}
+			// Skip 32-bit visuals with an alpha channel.
+			if d.Depth < 32 && (!found || d.Depth > bd.Depth) {
+				bd, bv, found = d, v, true
+			}
+			break
+		}
+	}
+	return bd, bv, found
+}
+
+// UseARGB makes subwindows use a 32-bit TrueColor visual with an alpha
+// channel so, when a compositor is running, transparent images show the
+// terminal behind them. Returns false and keeps using the default visual
+// if the X server has no such visual.
+func (t *TermWindow) UseARGB() bool {
+	t.initWindows()
+	ok, changed := t.useARGB()
+	if changed {
+		for _, w := range t.windowList() {
+			w.sem.Lock()
+			w.img, w.change = nil, true
+			w.sem.Unlock()
+		}
+	}
+	return ok
+}
+
+func (t *TermWindow) useARGB() (ok, changed bool) {
+	t.sem.Lock()
+	defer t.sem.Unlock()
+	if t.argb {
+		return true, false
+	}
+
+	screen := xproto.Setup(t.x).DefaultScreen(t.x)
+	for _, depth := range screen.AllowedDepths {
+		if depth.Depth != 32 {
+			continue
+		}
+		for _, visual := range depth.Visuals {
+			if visual.Class != xproto.VisualClassTrueColor {
+				continue
+			}
+
+			cmap, ok := t.createColormap(screen, visual.VisualId)
+			if !ok {
+				return false, false
+			}
+			if t.ownCmap {
+				xproto.FreeColormap(t.x, t.colormap)
+			}
+
+			t.depth, t.visual, t.argb = depth, visual, true
+			t.colormap, t.ownCmap = cmap, true
+			t.format, t.fmtErr = NewPixelFormat(xproto.Setup(t.x), depth.Depth, visual)
+			return true, true
+		}
+	}
+
+	return false, false
+}
+
+// visualState is the visual subwindows are created with and how their
+// images are sent to the X server.
+type visualState struct {
+	depth    xproto.DepthInfo
+	visual   xproto.VisualInfo
+	colormap xproto.Colormap
+	argb     bool
+	format   PixelFormat
+	fmtErr   error
+	// render is nil if RENDER is unavailable or disabled.
+	render *renderFormats
+}
+
+// visualState returns a copy of the fields changed by UseARGB and
+// UseRender, SubWindows use it while drawing instead of locking t.sem for
+// every access.
+func (t *TermWindow) visualState() visualState {
+	t.renderOnce.Do(t.initRender)
+	t.sem.RLock()
+	defer t.sem.RUnlock()
+	v := visualState{
+		depth:    t.depth,
+		visual:   t.visual,
+		colormap: t.colormap,
+		argb:     t.argb,
+		format:   t.format,
+		fmtErr:   t.fmtErr,
+	}
+	if !t.noRender {
+		v.render = t.render
+	}
+	return v
+}
+
+// windowList returns all subwindows, which can then be locked without
+// holding t.sem.
+func (t *TermWindow) windowList() []*SubWindow {
+	t.sem.RLock()
+	defer t.sem.RUnlock()
+	l := make([]*SubWindow, 0, len(t.windows))
+	for _, w := range t.windows {
+		l = append(l, w)
+	}
+	return l
+}
+
+// Visual returns the id and depth of the visual used for subwindows. This
+// is the visual of the terminal window unless UseARGB succeeded.
+func (t *TermWindow) Visual() (xproto.Visualid, byte) {
+	t.initWindows()
+	t.sem.RLock()
+	defer t.sem.RUnlock()
+	return t.visual.VisualId, t.depth.Depth
+}
+
+// PixelFormat returns the layout images are converted to before they are
+// sent to the X server, or an error if the visual is not supported.
+func (t *TermWindow) PixelFormat() (PixelFormat, error) {
+	t.initWindows()
+	t.sem.RLock()
+	defer t.sem.RUnlock()
+	return t.format, t.fmtErr
 }
 
 // DelWindow will close a subwindow by name. Identical to calling Close on
@@ -27,23 +233,25 @@ func (t *TermWindow) initWindows() {
 func (t *TermWindow) DelWindow(name string) {
 	t.sem.RLock()
 	w := t.windows[name]
+	t.sem.RUnlock()
 	if w != nil {
 		w.Close()
 	}
-	t.sem.RUnlock()
 }
 
 func (t *TermWindow) DelAllWindows() {
-	t.sem.RLock()
-	l := make([]string, 0, len(t.windows))
-	t.sem.RUnlock()
-	for _, name := range l {
-		t.DelWindow(name)
+	for _, w := range t.windowList() {
+		w.Close()
 	}
 }
 
 func (t *TermWindow) delWindow(name string) {
 	t.sem.Lock()
+	if w, ok := t.windows[name]; ok {
+		t.stackSem.Lock()
+		t.stackRemove(w)
+		t.stackSem.Unlock()
+	}
 	delete(t.windows, name)
 	t.sem.Unlock()
 }
@@ -56,6 +264,9 @@ func (t *TermWindow) SubWindow(name string) *SubWindow {
 	w, ok := t.windows[name]
 	if !ok {
 		w = &SubWindow{t: t, name: name, geom: image.Rectangle{}}
+		t.stackSem.Lock()
+		t.stack = append(t.stack, w)
+		t.stackSem.Unlock()
 	}
 
 	t.windows[name] = w
@@ -91,16 +302,17 @@ func (t *TermWindow) processEvent(event xgb.Event) error {
 	if event == nil {
 		return nil
 	}
+	var err error
 	switch event.(type) {
 	case xproto.ExposeEvent:
-		t.sem.RLock()
-		for _, w := range t.windows {
-			w.Render()
+		for _, w := range t.windowList() {
+			if rerr := w.Render(); rerr != nil && err == nil {
+				err = rerr
+			}
 		}
-		t.sem.RUnlock()
 	}
 
-	return nil
+	return err
 }
 
 type state byte
@@ -120,12 +332,61 @@ type SubWindow struct {
 	geom   image.Rectangle
 	src    Image
 	img    *BGRA
-	pixmap xproto.Pixmap
+	drawn  Geometry
+	tiles  []tile
 	gc     xproto.Gcontext
 	scaler ScaleMethod
+	filter Filters
+	bg     Background
+
+	// tilesFor is the window size the tiles were clipped to.
+	tilesFor Dimensions
+	gcDepth  byte
+
+	// vis is the visualState of the TermWindow when drawImage was last
+	// called.
+	vis visualState
+
+	// Attributes of the created X window.
+	wpos    image.Point
+	wsize   Dimensions
+	wback   uint32
+	wvisual xproto.Visualid
+	// unmapped is set while the window is unmapped because it can not be
+	// placed, see unmapWindow.
+	unmapped bool
+
+	transform Transform
+	view      Image
+
+	zoom       float64
+	panX, panY float64
+
+	// rsrc holds the mipmap level rlevel of the image on the X server when
+	// using RENDER, rdst the window it is composited onto.
+	rsrc   render.Picture
+	rlevel *BGRA
+	rdst   render.Picture
+	rsize  Dimensions
+	// stale is set when rsrc no longer matches the image.
+	stale bool
 
 	change bool
 	closed bool
+	// err is the last upload error, returned by Render.
+	err error
+
+	// stacked is the X window registered in the stack, guarded by
+	// TermWindow.stackSem.
+	stacked xproto.Window
+
+	mask Mask
+	// fg is img before the background was composited, masks are computed
+	// from it.
+	fg         *BGRA
+	shaped     bool
+	shapeFor   Dimensions
+	shapeStale bool
 }
 
 func (w *SubWindow) Closed() bool { return w.closed }
@@ -135,18 +396,11 @@ func (w *SubWindow) Closed() bool { return w.closed }
 func (w *SubWindow) Close() {
 	w.sem.Lock()
 	w.closed = true
-	w.src = nil
+	w.src, w.view = nil, nil
 
-	if w.is(stateCreated) {
-		xproto.DestroyWindow(w.t.x, w.wnd)
-		w.wnd = 0
-	}
-	if w.pixmap != 0 {
-		xproto.FreePixmap(w.t.x, w.pixmap)
-	}
-	if w.gc != 0 {
-		xproto.FreeGC(w.t.x, w.gc)
-	}
+	w.destroyWindow()
+	w.freeTiles()
+	w.freeRender()
 	w.sem.Unlock()
 
 	w.t.delWindow(w.name)
@@ -160,7 +414,7 @@ func (w *SubWindow) Show() {
 	}
 
 	w.state |= stateMapped
-	if w.is(stateCreated) {
+	if w.is(stateCreated) && !w.unmapped {
 		xproto.MapWindow(w.t.x, w.wnd)
 	}
 
@@ -175,39 +429,29 @@ func (w *SubWindow) Hide() {
 	}
 
 	w.state &= ^stateMapped
-	if w.is(stateCreated) {
+	if w.is(stateCreated) && !w.unmapped {
 		xproto.UnmapWindow(w.t.x, w.wnd)
 	}
 }
 
-func (w *SubWindow) ToTop() {
-	w.sem.Lock()
-	defer w.sem.Unlock()
-	if !w.is(stateCreated) || !w.is(stateMapped) {
-		return
-	}
-
-	w.windowID(true)
-	w.draw()
+// ToTop is Raise.
+func (w *SubWindow) ToTop() { w.Raise() }
 
-	// Does nothing:
-	//   xproto.CirculateWindow(w.t.x, xproto.CirculateRaiseLowest, w.wnd)
-	// Or
-	//   xproto.UnmapWindowChecked(w.t.x, w.wnd).Check()
-	//   xproto.MapWindowChecked(w.t.x, w.wnd).Check()
-}
-
-func (w *SubWindow) Render() {
+// Render draws the subwindow if needed and returns any error that occurred
+// while uploading the image to the X server since the last call.
+func (w *SubWindow) Render() error {
 	w.sem.Lock()
 	defer w.sem.Unlock()
 	if w.closed {
-		return
+		return nil
 	}
-	if !w.is(stateMapped) {
-		return
+	if w.is(stateMapped) {
+		w.draw()
 	}
 
-	w.draw()
+	err := w.err
+	w.err = nil
+	return err
 }
 
 func (w *SubWindow) DryScale(
@@ -241,17 +485,166 @@ func (w *SubWindow) SetScaler(s ScaleMethod) {
 	w.sem.Unlock()
 }
 
-func (w *SubWindow) SetImage(img Image) {
+// Transform returns the transform set by SetTransform.
+func (w *SubWindow) Transform() Transform {
 	w.sem.Lock()
-	w.src = img
+	defer w.sem.Unlock()
+	return w.transform
+}
+
+// SetTransform sets the crop, flips and rotation applied to the image
+// before it is scaled. Scalers and DryScale see the transformed size.
+func (w *SubWindow) SetTransform(t Transform) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	w.sem.Lock()
+	if t != w.transform {
+		w.transform = t
+		w.setView()
+	}
+	w.sem.Unlock()
+	return nil
+}
+
+// setView updates the transformed view of src.
+func (w *SubWindow) setView() {
+	w.view = w.src
+	if w.src != nil && w.transform != (Transform{}) {
+		w.view = NewTransformImage(w.src, w.transform)
+	}
+	w.clampPan()
+	w.img = nil
+	w.stale = true
+	w.change = true
+}
+
+// Background returns the background set by SetBackground.
+func (w *SubWindow) Background() Background {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	return w.bg
+}
+
+// SetBackground sets the background transparent images are composited
+// against, e.g.: SolidBackground with the color returned by
+// QueryBackground. nil leaves transparent pixels black on a white window.
+func (w *SubWindow) SetBackground(bg Background) {
+	w.sem.Lock()
+	w.bg = bg
 	w.img = nil
 	w.change = true
+	w.sem.Unlock()
+}
+
+// Filters returns the filters set by SetFilters.
+func (w *SubWindow) Filters() Filters {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	return w.filter
+}
+
+// SetFilters sets the filters applied to the image after it has been
+// scaled. Changing them does not decode the image again.
+func (w *SubWindow) SetFilters(f ...Filter) {
+	w.sem.Lock()
+	w.filter = f
+	w.img = nil
+	w.change = true
+	w.sem.Unlock()
+}
+
+// SetImage sets the image to display. If img implements Loader, it is
+// drawn as soon as it has finished loading while its Bounds can already be
+// used for geometry calculations.
+func (w *SubWindow) SetImage(img Image) {
+	w.sem.Lock()
+	w.src = img
+	w.setView()
 	if w.geom == (image.Rectangle{}) {
-		b := w.src.Bounds()
+		b := w.view.Bounds()
 		w.geom = image.Rect(0, 0, b.Dx(), b.Dy())
 	}
 
 	w.sem.Unlock()
+
+	if l, ok := img.(Loader); ok {
+		l.OnLoad(func(error) { w.loaded(img) })
+	}
+}
+
+func (w *SubWindow) loaded(img Image) {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if w.closed || w.src != img {
+		return
+	}
+
+	w.img = nil
+	w.stale = true
+	w.change = true
+	w.draw()
+}
+
+// Wait blocks until the current image has finished loading and returns
+// the decoding error, if any.
+func (w *SubWindow) Wait() error {
+	w.sem.Lock()
+	l, ok := w.src.(Loader)
+	w.sem.Unlock()
+	if !ok {
+		return nil
+	}
+	return l.Wait()
+}
+
+// Pages returns the amount of pages in the current image, which is 1
+// for images that do not implement Pager.
+func (w *SubWindow) Pages() int {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if p, ok := w.src.(Pager); ok {
+		return p.Pages()
+	}
+	if w.src == nil {
+		return 0
+	}
+	return 1
+}
+
+// Page returns the zero-based index of the current page.
+func (w *SubWindow) Page() int {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if p, ok := w.src.(Pager); ok {
+		return p.Page()
+	}
+	return 0
+}
+
+// SetPage selects the given zero-based page of the current image.
+func (w *SubWindow) SetPage(page int) error {
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	p, ok := w.src.(Pager)
+	if !ok {
+		if page == 0 && w.src != nil {
+			return nil
+		}
+		return fmt.Errorf("page %d out of range [0, 1)", page)
+	}
+
+	if page == p.Page() {
+		return nil
+	}
+	if err := p.SetPage(page); err != nil {
+		return err
+	}
+
+	w.img = nil
+	w.stale = true
+	w.change = true
+	return nil
 }
 
 // SetGeometryTerminal in terminal units (columns and lines).
@@ -281,6 +674,7 @@ func (w *SubWindow) SetGeometry(r image.Rectangle) {
 
 	w.change = w.change || w.geom != r
 	w.geom = r
+	w.clampPan()
 }
 
 // GeometryTerminal returns the geometry in terminal units (columns and lines).
@@ -306,16 +700,11 @@ func (w *SubWindow) Geometry() Geometry {
 
 func (w *SubWindow) is(s state) bool { return w.state&s != 0 }
 
-func (w *SubWindow) windowID(new bool) {
+func (w *SubWindow) windowID() {
 	if w.wnd != 0 {
-		if !new {
-			return
-		}
-		xproto.DestroyWindow(w.t.x, w.wnd)
-		w.wnd = 0
+		return
 	}
 
-	w.state &= ^stateCreated
 	wnd, _ := xproto.NewWindowId(w.t.x)
 	w.wnd = wnd
 }
@@ -325,6 +714,8 @@ func (w *SubWindow) calcGeomTerminal(chr Dimensions, g Geometry) Geometry {
 	g.Image.H /= chr.H
 	g.Window.W /= chr.W
 	g.Window.H /= chr.H
+	g.Offset.X /= chr.W
+	g.Offset.Y /= chr.H
 
 	return g
 }
@@ -333,12 +724,12 @@ func (w *SubWindow) calcGeom(width, height int, s ScaleMethod) (in, out Geometry
 	if w.src == nil {
 		return
 	}
-	scaler := scalers[s]
+	scaler := lookupScaler(s)
 	if scaler == nil {
 		panic("invalid scaler")
 	}
 
-	b := w.src.Bounds()
+	b := w.view.Bounds()
 	in = Geometry{
 		Image:  Dimensions{W: b.Dx(), H: b.Dy()},
 		Window: Dimensions{W: width, H: height},
@@ -349,90 +740,214 @@ func (w *SubWindow) calcGeom(width, height int, s ScaleMethod) (in, out Geometry
 }
 
 func (w *SubWindow) geometry() (bool, Geometry) {
+	if w.zoom > 0 && w.view != nil {
+		d := w.viewportSize()
+		return true, Geometry{Image: d, Window: d}
+	}
 	in, out := w.calcGeom(w.geom.Dx(), w.geom.Dy(), w.scaler)
 	return in.Image != out.Image, out
 }
 
 func (w *SubWindow) drawImage() {
+	w.vis = w.t.visualState()
 	change, geom := w.geometry()
 	renderable := geom.Window.W != 0 && geom.Window.H != 0 &&
-		geom.Image.W != 0 && geom.Image.H != 0
+		geom.Image.W != 0 && geom.Image.H != 0 &&
+		imageLoaded(w.src)
+
+	if renderable {
+		if level, ok := w.useRender(geom); ok {
+			w.drawRender(geom, level)
+			return
+		}
+	}
+	w.freeRender()
 
 	actualChange := w.img == nil
 	if renderable && !actualChange {
 		b := w.img.Rect
-		actualChange = b.Dx() != geom.Image.W || b.Dy() != geom.Image.H
+		actualChange = b.Dx() != geom.Image.W || b.Dy() != geom.Image.H ||
+			geom.Crop != w.drawn.Crop
 	}
+	w.drawn = geom
 
 	if renderable && actualChange {
-		w.src.Reset()
-		if change {
-			w.src.Resize(geom.Image.W, geom.Image.H)
+		switch {
+		case w.zoom > 0:
+			w.img = region(w.view, w.zoom, w.zoom, w.panX, w.panY, geom.Image.W, geom.Image.H)
+		case !geom.Crop.Empty():
+			c := geom.Crop
+			w.img = region(
+				w.view,
+				float64(geom.Image.W)/float64(c.Dx()),
+				float64(geom.Image.H)/float64(c.Dy()),
+				float64(c.Min.X),
+				float64(c.Min.Y),
+				geom.Image.W,
+				geom.Image.H,
+			)
+		default:
+			w.view.Reset()
+			if change {
+				w.view.Resize(geom.Image.W, geom.Image.H)
+			}
+			w.img = w.view.BGRA()
+		}
+		if len(w.filter) != 0 {
+			w.img = applyFilter(w.img, w.filter)
+		}
+		w.fg = w.img
+		if w.bg != nil && !w.img.Opaque() {
+			w.img = applyFilter(w.img, w.bg)
 		}
-		w.img = w.src.BGRA()
 	}
 
-	if w.is(stateCreated) {
-		xproto.DestroyWindow(w.t.x, w.wnd)
-		w.wnd = 0
-		if actualChange {
-			xproto.FreePixmap(w.t.x, w.pixmap)
-			xproto.FreeGC(w.t.x, w.gc)
-			w.pixmap, w.gc = 0, 0
+	if !renderable {
+		w.destroyWindow()
+		return
+	}
+
+	pos, size := w.clipGeometry(geom)
+	if size.W == 0 || size.H == 0 {
+		w.unmapWindow()
+		return
+	}
+
+	if actualChange || len(w.tiles) == 0 || !w.tilesCover(size) {
+		// Upload before freeing the old tiles so the window keeps showing
+		// them until the new ones are copied.
+		old := w.tiles
+		w.tiles = nil
+		if err := w.createTiles(size); err != nil {
+			freePixmaps(w.t, w.tiles)
+			w.tiles = nil
+			w.err = err
 		}
-		w.state &= ^stateCreated
+		freePixmaps(w.t, old)
+		w.shapeStale = true
 	}
+	w.window(pos, size)
+	w.applyShape()
+}
 
-	if !renderable {
+// clipGeometry returns the position and size of the window for geom, the
+// size is empty if it can not be created.
+func (w *SubWindow) clipGeometry(geom Geometry) (image.Point, Dimensions) {
+	pos := w.geom.Min.Add(geom.Offset)
+	size, err := clipGeometry(pos, geom.Window)
+	if err != nil {
+		w.err = err
+	}
+	return pos, size
+}
+
+// window creates the X window or, if it exists, moves and resizes it.
+func (w *SubWindow) window(pos image.Point, size Dimensions) {
+	back := w.backPixel()
+	if w.is(stateCreated) && w.wvisual == w.vis.visual.VisualId {
+		if back != w.wback {
+			xproto.ChangeWindowAttributes(w.t.x, w.wnd, xproto.CwBackPixel, []uint32{back})
+			w.wback = back
+		}
+		if pos != w.wpos || size != w.wsize {
+			xproto.ConfigureWindow(
+				w.t.x,
+				w.wnd,
+				xproto.ConfigWindowX|xproto.ConfigWindowY|
+					xproto.ConfigWindowWidth|xproto.ConfigWindowHeight,
+				[]uint32{
+					uint32(int32(pos.X)),
+					uint32(int32(pos.Y)),
+					uint32(size.W),
+					uint32(size.H),
+				},
+			)
+			w.wpos, w.wsize = pos, size
+		}
+		if w.unmapped {
+			w.unmapped = false
+			if w.is(stateMapped) {
+				xproto.MapWindow(w.t.x, w.wnd)
+			}
+		}
+		return
+	}
+
+	w.destroyWindow()
+	w.createWindow(pos, size, back)
+	if w.is(stateMapped) {
+		xproto.MapWindow(w.t.x, w.wnd)
+	}
+}
+
+// unmapWindow hides the X window while it can not be placed, keeping it
+// so it can be reconfigured once it can.
+func (w *SubWindow) unmapWindow() {
+	if !w.is(stateCreated) || w.unmapped {
+		return
+	}
+	if w.is(stateMapped) {
+		xproto.UnmapWindow(w.t.x, w.wnd)
+	}
+	w.unmapped = true
+}
+
+// destroyWindow destroys the X window, keeping the uploaded image.
+func (w *SubWindow) destroyWindow() {
+	if !w.is(stateCreated) {
 		return
 	}
+	w.freeRenderTarget()
+	w.t.stackDestroyed(w)
+	xproto.DestroyWindow(w.t.x, w.wnd)
+	w.wnd = 0
+	w.state &= ^stateCreated
+	w.unmapped = false
+}
+
+func (w *SubWindow) backPixel() uint32 {
+	switch {
+	case w.bg != nil && w.vis.argb:
+		return 0xff000000 | w.bg.Pixel()
+	case w.bg != nil:
+		return w.bg.Pixel()
+	case w.vis.argb:
+		return 0
+	}
+	return 0xffffff
+}
+
+func (w *SubWindow) createWindow(pos image.Point, size Dimensions, back uint32) {
+	mask := uint32(xproto.CwBackPixel | xproto.CwEventMask)
+	values := []uint32{back, xproto.EventMaskExposure}
+	if w.vis.colormap != 0 {
+		// A border pixel and colormap are required when the visual differs
+		// from the parent's.
+		mask |= xproto.CwBorderPixel | xproto.CwColormap
+		values = []uint32{back, 0, xproto.EventMaskExposure, uint32(w.vis.colormap)}
+	}
 
-	w.windowID(false)
+	w.windowID()
 	xproto.CreateWindow(
 		w.t.x,
-		w.t.depth.Depth,
+		w.vis.depth.Depth,
 		w.wnd,
 		w.t.wnd,
-		int16(w.geom.Min.X),
-		int16(w.geom.Min.Y),
-		uint16(geom.Window.W),
-		uint16(geom.Window.H),
+		int16(pos.X),
+		int16(pos.Y),
+		uint16(size.W),
+		uint16(size.H),
 		0,
 		xproto.WindowClassInputOutput,
-		w.t.visual.VisualId,
-		xproto.CwBackPixel|xproto.CwEventMask,
-		[]uint32{0xffffff, xproto.EventMaskExposure},
+		w.vis.visual.VisualId,
+		mask,
+		values,
 	)
 	w.state |= stateCreated
-
-	if actualChange || w.pixmap == 0 || w.gc == 0 {
-		width, height := uint16(w.img.Rect.Dx()), uint16(w.img.Rect.Dy())
-		pixmap, _ := xproto.NewPixmapId(w.t.x)
-		gc, _ := xproto.NewGcontextId(w.t.x)
-
-		w.pixmap = pixmap
-		w.gc = gc
-		xproto.CreatePixmap(
-			w.t.x,
-			w.t.depth.Depth,
-			w.pixmap,
-			xproto.Drawable(w.wnd),
-			width,
-			height,
-		)
-
-		xproto.CreateGC(w.t.x, w.gc, xproto.Drawable(w.pixmap), 0, nil)
-		w.t.putImage(
-			w.img,
-			xproto.Drawable(w.pixmap),
-			w.gc,
-			w.t.depth.Depth,
-		)
-	}
-
-	if w.is(stateMapped) {
-		xproto.MapWindow(w.t.x, w.wnd)
-	}
+	w.shaped, w.unmapped = false, false
+	w.t.stackCreated(w, w.wnd)
+	w.wpos, w.wsize, w.wback = pos, size, back
+	w.wvisual = w.vis.visual.VisualId
 }
 
 func (w *SubWindow) draw() {
@@ -440,7 +955,7 @@ func (w *SubWindow) draw() {
 		return
 	}
 
-	width, height := uint16(w.geom.Dx()), uint16(w.geom.Dy())
+	width, height := w.geom.Dx(), w.geom.Dy()
 	if width == 0 || height == 0 {
 		return
 	}
@@ -449,56 +964,151 @@ func (w *SubWindow) draw() {
 		w.change = false
 		w.drawImage()
 	}
-	if w.pixmap == 0 {
+	if w.rsrc != 0 {
+		w.composite()
 		return
 	}
-
-	xproto.CopyArea(
-		w.t.x,
-		xproto.Drawable(w.pixmap),
-		xproto.Drawable(w.wnd),
-		w.gc,
-		0,
-		0,
-		0,
-		0,
-		width,
-		height,
-	)
+	w.copyTiles(width, height)
 }
 
-const maxuint16 = 1<<16 - 1
-
+// putImage converts img to p and uploads it to pixMap which must be of
+// depth p.Depth.
 func (t *TermWindow) putImage(
+	p PixelFormat,
 	img *BGRA,
 	pixMap xproto.Drawable,
 	gc xproto.Gcontext,
-	depth byte,
-) {
+) error {
 	b := img.Bounds()
 	width, height := b.Dx(), b.Dy()
 	if width == 0 || height == 0 {
-		return
+		return nil
+	}
+
+	depth := p.Depth
+	pix := p.Encode(img)
+	if ok, err := t.putImageSHM(pix, width, height, pixMap, gc, depth); ok {
+		return err
+	}
+
+	max := t.maxRequest() - putImageHeader
+	if s := p.Stride(width); s <= max {
+		return t.putRows(pix, s, width, height, 0, pixMap, gc, depth)
 	}
 
-	s := width * 4
-	lines := maxuint16 / s
+	// A single row does not fit in a request, upload in columns.
+	pad := int(p.ScanlinePad)
+	if pad == 0 {
+		pad = 8
+	}
+	cols := max * 8 / pad * pad / int(p.BitsPerPixel)
+	for x := 0; x < width; x += cols {
+		r := image.Rect(x, 0, x+cols, height).Add(b.Min).Intersect(b)
+		pix := p.Encode(img.SubImage(r).(*BGRA))
+		s := p.Stride(r.Dx())
+		if err := t.putRows(pix, s, r.Dx(), height, x, pixMap, gc, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putRows uploads pix in as few requests as possible.
+func (t *TermWindow) putRows(
+	pix []byte,
+	stride, width, height, x int,
+	pixMap xproto.Drawable,
+	gc xproto.Gcontext,
+	depth byte,
+) error {
+	lines := (t.maxRequest() - putImageHeader) / stride
+	cookies := make([]xproto.PutImageCookie, 0, (height+lines-1)/lines)
 	for y := 0; y < height; y += lines {
-		from, till := y*s, (y+lines)*s
-		if y+lines > height {
-			till = len(img.Pix)
-			lines = height - y
+		n := lines
+		if y+n > height {
+			n = height - y
+		}
+		cookies = append(cookies, t.putImageRequest(
+			pixMap,
+			gc,
+			width, n,
+			x, y,
+			depth,
+			pix[y*stride:(y+n)*stride],
+		))
+	}
+
+	for _, c := range cookies {
+		if err := c.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putImageHeader is the size of a PutImage request without data, including
+// the extended length field of BIG-REQUESTS.
+const putImageHeader = 28
+
+// maxRequest returns the maximum request size in bytes, enabling the
+// BIG-REQUESTS extension if available.
+func (t *TermWindow) maxRequest() int {
+	t.reqOnce.Do(func() {
+		t.maxReq = int(xproto.Setup(t.x).MaximumRequestLength) * 4
+		if err := bigreq.Init(t.x); err != nil {
+			return
+		}
+		r, err := bigreq.Enable(t.x).Reply()
+		if err != nil {
+			return
 		}
-		xproto.PutImageChecked(
+		t.maxReq, t.bigReq = int(r.MaximumRequestLength)*4, true
+	})
+	return t.maxReq
+}
+
+// putImageRequest sends a checked ZPixmap PutImage request, using the
+// BIG-REQUESTS encoding if data does not fit a regular request.
+func (t *TermWindow) putImageRequest(
+	pixMap xproto.Drawable,
+	gc xproto.Gcontext,
+	width, height, x, y int,
+	depth byte,
+	data []byte,
+) xproto.PutImageCookie {
+	size := xgb.Pad(putImageHeader - 4 + len(data))
+	if size/4 <= maxuint16 || !t.bigReq {
+		return xproto.PutImageChecked(
 			t.x,
 			xproto.ImageFormatZPixmap,
 			pixMap,
 			gc,
 			uint16(width),
-			uint16(lines),
-			0, int16(y),
+			uint16(height),
+			int16(x), int16(y),
 			0, depth,
-			img.Pix[from:till],
+			data,
 		)
 	}
+
+	size += 4
+	buf := make([]byte, size)
+	buf[0] = 72 // PutImage opcode
+	buf[1] = xproto.ImageFormatZPixmap
+	// A length of 0 indicates the 32-bit length that follows.
+	xgb.Put32(buf[4:], uint32(size/4))
+	xgb.Put32(buf[8:], uint32(pixMap))
+	xgb.Put32(buf[12:], uint32(gc))
+	xgb.Put16(buf[16:], uint16(width))
+	xgb.Put16(buf[18:], uint16(height))
+	xgb.Put16(buf[20:], uint16(int16(x)))
+	xgb.Put16(buf[22:], uint16(int16(y)))
+	buf[25] = depth
+	copy(buf[putImageHeader:], data)
+
+	cookie := t.x.NewCookie(true, false)
+	t.x.NewRequest(buf, cookie)
+	return xproto.PutImageCookie{Cookie: cookie}
 }
+
+const maxuint16 = 1<<16 - 1
@@ -0,0 +1,344 @@
+package x
+
+import (
+	"image"
+	"testing"
+
+	"github.com/jezek/xgb/render"
+	"github.com/jezek/xgb/xproto"
+)
+
+// useFakeRender makes t draw using RENDER on a fakeX server.
+func useFakeRender(t *testing.T, tw *TermWindow) {
+	tw.x.ExtLock.Lock()
+	tw.x.Extensions["RENDER"] = renderOpcode
+	tw.x.ExtLock.Unlock()
+
+	tw.initWindows()
+	format, err := NewPixelFormat(xproto.Setup(tw.x), 32, xproto.VisualInfo{
+		Class:     xproto.VisualClassTrueColor,
+		RedMask:   0xff0000,
+		GreenMask: 0xff00,
+		BlueMask:  0xff,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.renderOnce.Do(func() {})
+	tw.render = &renderFormats{
+		argb32:  1,
+		visuals: map[xproto.Visualid]render.Pictformat{tw.visual.VisualId: 2},
+		format:  format,
+	}
+}
+
+type reqCounts map[int]int
+
+func (r reqCounts) check(t *testing.T, what string, exp reqCounts) {
+	t.Helper()
+	for k, n := range exp {
+		if r[k] != n {
+			t.Errorf("%s: request %#x: got %d, expected %d", what, k, r[k], n)
+		}
+	}
+}
+
+// windowRequests are the requests that create, destroy or change the
+// window.
+var windowRequests = []int{
+	reqCreateWindow,
+	reqDestroyWindow,
+	reqMapWindow,
+	reqUnmapWindow,
+	reqConfigureWindow,
+	reqChangeWindowAttributes,
+}
+
+func TestSubWindowRequests(t *testing.T) {
+	kinds := append([]int{
+		reqCreatePixmap,
+		reqFreePixmap,
+		reqCreateGC,
+		reqFreeGC,
+		reqCopyArea,
+		reqPutImage,
+		reqCreatePicture,
+		reqFreePicture,
+		reqComposite,
+		reqSetPictureTransform,
+	}, windowRequests...)
+
+	for _, mode := range []string{"cpu", "render"} {
+		t.Run(mode, func(t *testing.T) {
+			depth, formats := fakeDepth24()
+			tw, f := newFakeX(t, formats, depth)
+			if mode == "render" {
+				useFakeRender(t, tw)
+			}
+
+			w := tw.SubWindow("a")
+			w.SetImage(NewImage(image.NewRGBA(image.Rect(0, 0, 400, 300))))
+			w.SetScaler(ScaleRatio)
+			// Too large for the first mipmap level, so resizing reuses the
+			// uploaded image.
+			w.SetGeometry(image.Rect(10, 10, 250, 190))
+			w.Show()
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			first := reqCounts(f.count(tw, kinds...))
+			first.check(t, "create", reqCounts{reqCreateWindow: 1, reqMapWindow: 1})
+
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			redraw := reqCounts(f.count(tw, kinds...))
+			exp := reqCounts{}
+			for _, k := range kinds {
+				exp[k] = 0
+			}
+			if mode == "render" {
+				exp[reqComposite] = 1
+			} else {
+				exp[reqCopyArea] = 1
+			}
+			redraw.check(t, "redraw", exp)
+
+			w.SetGeometry(image.Rect(20, 30, 420, 330))
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			resize := reqCounts(f.count(tw, kinds...))
+			resize.check(t, "resize", reqCounts{
+				reqCreateWindow:    0,
+				reqDestroyWindow:   0,
+				reqMapWindow:       0,
+				reqConfigureWindow: 1,
+			})
+			if mode == "render" {
+				resize.check(t, "resize", reqCounts{
+					reqPutImage:            0,
+					reqCreatePixmap:        0,
+					reqCreatePicture:       0,
+					reqFreePicture:         0,
+					reqSetPictureTransform: 1,
+				})
+			}
+
+			w.SetZoom(2)
+			w.Pan(15, 10)
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			pan := reqCounts(f.count(tw, kinds...))
+			pan.check(t, "pan", reqCounts{
+				reqCreateWindow:    0,
+				reqDestroyWindow:   0,
+				reqConfigureWindow: 0,
+			})
+			if mode == "render" {
+				pan.check(t, "pan", reqCounts{
+					reqPutImage:            0,
+					reqCreatePicture:       0,
+					reqFreePicture:         0,
+					reqSetPictureTransform: 1,
+				})
+			}
+			w.SetZoom(0)
+
+			// Beyond the coordinates X can address and back.
+			w.SetGeometry(image.Rect(1<<16, 0, 1<<16+400, 300))
+			if err := w.Render(); err == nil {
+				t.Error("no error for unaddressable geometry")
+			}
+			w.SetGeometry(image.Rect(20, 30, 420, 330))
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			moved := reqCounts(f.count(tw, kinds...))
+			moved.check(t, "move", reqCounts{
+				reqCreateWindow:  0,
+				reqDestroyWindow: 0,
+				reqUnmapWindow:   1,
+				reqMapWindow:     1,
+			})
+			if mode == "render" {
+				moved.check(t, "move", reqCounts{
+					reqPutImage:      0,
+					reqCreatePicture: 0,
+					reqFreePicture:   0,
+				})
+
+				// Only the source picture is replaced.
+				w.SetImage(NewImage(image.NewRGBA(image.Rect(0, 0, 300, 400))))
+				if err := w.Render(); err != nil {
+					t.Fatal(err)
+				}
+				reqCounts(f.count(tw, kinds...)).check(t, "image", reqCounts{
+					reqCreateWindow:  0,
+					reqCreatePicture: 1,
+					reqFreePicture:   1,
+				})
+			}
+		})
+	}
+}
+
+func TestRenderLevel(t *testing.T) {
+	depth, formats := fakeDepth24()
+	tw, f := newFakeX(t, formats, depth)
+	useFakeRender(t, tw)
+	kinds := []int{reqCreatePicture, reqFreePicture, reqPutImage}
+
+	w := tw.SubWindow("a")
+	w.SetImage(NewImage(image.NewRGBA(image.Rect(0, 0, 1024, 768))))
+	w.SetScaler(ScaleRatio)
+	level := func(what string, exp image.Point) {
+		t.Helper()
+		if err := w.Render(); err != nil {
+			t.Fatal(err)
+		}
+		w.sem.Lock()
+		got := image.Point{}
+		if w.rlevel != nil {
+			got = w.rlevel.Rect.Size()
+		}
+		w.sem.Unlock()
+		if got != exp {
+			t.Errorf("%s: uploaded %v, expected %v", what, got, exp)
+		}
+	}
+
+	w.SetGeometry(image.Rect(0, 0, 200, 150))
+	w.Show()
+	level("fit", image.Pt(256, 192))
+	f.count(tw)
+
+	w.SetGeometry(image.Rect(0, 0, 240, 180))
+	level("same level", image.Pt(256, 192))
+	reqCounts(f.count(tw, kinds...)).check(t, "same level", reqCounts{
+		reqCreatePicture: 0,
+		reqPutImage:      0,
+	})
+
+	w.SetZoom(1)
+	level("zoom", image.Pt(1024, 768))
+	reqCounts(f.count(tw, kinds...)).check(t, "zoom", reqCounts{
+		reqCreatePicture: 1,
+		reqFreePicture:   1,
+	})
+
+	w.SetZoom(0)
+	w.SetTransform(Transform{Rotate: 90})
+	level("rotated", image.Pt(192, 256))
+	f.count(tw)
+
+	// Images without mipmaps are shrunk on the CPU.
+	w.SetTransform(Transform{})
+	w.SetImage(struct{ Image }{NewImage(image.NewRGBA(image.Rect(0, 0, 1024, 768)))})
+	level("no levels", image.Point{})
+	reqCounts(f.count(tw, kinds...)).check(t, "no levels", reqCounts{
+		reqCreatePicture: 0,
+	})
+}
+
+func TestInitWindowsDirectColor(t *testing.T) {
+	depth, formats := fakeDepth24()
+	direct := depth.Visuals[0]
+	direct.Class = xproto.VisualClassDirectColor
+	depth.Visuals = append([]xproto.VisualInfo{direct}, depth.Visuals[0])
+	depth.Visuals[1].VisualId++
+	tw, _ := newFakeX(t, formats, depth)
+
+	if _, err := NewPixelFormat(xproto.Setup(tw.x), 24, direct); err == nil {
+		t.Error("no error for DirectColor visual")
+	}
+
+	id, d := tw.Visual()
+	if id != depth.Visuals[1].VisualId || d != 24 {
+		t.Errorf("visual %#x depth %d, expected TrueColor visual %#x", id, d, depth.Visuals[1].VisualId)
+	}
+	if _, err := tw.PixelFormat(); err != nil {
+		t.Error(err)
+	}
+	if !tw.ownCmap {
+		t.Error("no colormap created for TrueColor visual")
+	}
+}
+
+func TestInitWindowsARGBParent(t *testing.T) {
+	depth, formats := fakeDepth24()
+	argb := fakeDepth32()
+	tw, f := newFakeX(t, formats, depth, argb)
+	const cmap = 0x50
+	f.addWindow(tw.wnd, fakeWindow{
+		depth:    32,
+		visual:   argb.Visuals[0].VisualId,
+		colormap: cmap,
+	})
+
+	id, d := tw.Visual()
+	if id != argb.Visuals[0].VisualId || d != 32 {
+		t.Fatalf("visual %#x depth %d, expected the visual of the parent %#x", id, d, argb.Visuals[0].VisualId)
+	}
+	if tw.ownCmap {
+		t.Error("colormap created instead of using the one of the parent")
+	}
+
+	w := tw.SubWindow("a")
+	w.SetImage(NewImage(image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	w.SetGeometry(image.Rect(0, 0, 64, 64))
+	w.Show()
+	if err := w.Render(); err != nil {
+		t.Fatal(err)
+	}
+	w.sem.Lock()
+	wnd := w.wnd
+	w.sem.Unlock()
+
+	// A child whose depth differs from the root needs an explicit border
+	// pixel and colormap or CreateWindow fails with BadMatch.
+	c, ok := f.window(tw, wnd)
+	if !ok {
+		t.Fatal("no window created")
+	}
+	if c.depth != 32 || c.visual != argb.Visuals[0].VisualId {
+		t.Errorf("window depth %d visual %#x, expected 32 %#x", c.depth, c.visual, argb.Visuals[0].VisualId)
+	}
+	need := uint32(xproto.CwBorderPixel | xproto.CwColormap)
+	if c.mask&need != need || c.colormap != cmap {
+		t.Errorf("window mask %#x colormap %#x, expected border pixel and colormap %#x", c.mask, c.colormap, cmap)
+	}
+}
+
+func TestUseARGBWhileDrawing(t *testing.T) {
+	depth, formats := fakeDepth24()
+	tw, _ := newFakeX(t, formats, depth, fakeDepth32())
+
+	w := tw.SubWindow("a")
+	w.SetImage(NewImage(image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	w.Show()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			w.SetGeometry(image.Rect(0, 0, 32+i, 32+i))
+			w.Render()
+		}
+	}()
+	tw.UseRender(false)
+	if !tw.UseARGB() {
+		t.Fatal("no ARGB visual")
+	}
+	tw.UseRender(true)
+	<-done
+
+	if err := w.Render(); err != nil {
+		t.Fatal(err)
+	}
+	w.sem.Lock()
+	defer w.sem.Unlock()
+	if exp := fakeDepth32().Visuals[0].VisualId; w.wvisual != exp {
+		t.Errorf("window visual %#x, expected %#x", w.wvisual, exp)
+	}
+}
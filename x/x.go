@@ -15,18 +15,46 @@ import (
 )
 
 type TermWindow struct {
+	// sem guards windows and the fields describing the visual. It may be
+	// locked while holding the sem of a SubWindow but not the other way
+	// around.
 	sem sync.RWMutex
 
 	x   *xgb.Conn
 	wnd xproto.Window
+	// display is the display x is connected to, as in $DISPLAY.
+	display string
 
 	console   console.Console
 	hintsAtom xproto.Atom
 
 	windows map[string]*SubWindow
-
-	depth  xproto.DepthInfo
-	visual xproto.VisualInfo
+	// stack holds subwindows from bottom to top.
+	stack    []*SubWindow
+	stackSem sync.Mutex
+
+	depth    xproto.DepthInfo
+	visual   xproto.VisualInfo
+	colormap xproto.Colormap
+	ownCmap  bool
+	argb     bool
+	format   PixelFormat
+	fmtErr   error
+
+	shapeOnce sync.Once
+	shape     bool
+
+	renderOnce sync.Once
+	render     *renderFormats
+	noRender   bool
+
+	reqOnce sync.Once
+	maxReq  int
+	bigReq  bool
+
+	shmMu    sync.Mutex
+	shm      *shmSegment
+	shmState int
 }
 
 func NewFromEnv() (*TermWindow, error) { return New(os.Getenv("WINDOWID")) }
@@ -45,12 +73,19 @@ func New(windowID string) (*TermWindow, error) {
 	return &TermWindow{
 		x:       x,
 		wnd:     xproto.Window(wnd),
+		display: os.Getenv("DISPLAY"),
 		windows: make(map[string]*SubWindow),
 	}, nil
 }
 
 func (t *TermWindow) Close() {
 	t.DelAllWindows()
+	t.shmMu.Lock()
+	t.shmDetach()
+	t.shmMu.Unlock()
+	if t.ownCmap {
+		xproto.FreeColormap(t.x, t.colormap)
+	}
 	t.x.Close()
 }
 
@@ -0,0 +1,294 @@
+package x
+
+import (
+	"math"
+
+	"github.com/jezek/xgb/render"
+	"github.com/jezek/xgb/xproto"
+)
+
+// renderFormats holds the picture formats used with the RENDER extension.
+type renderFormats struct {
+	argb32  render.Pictformat
+	visuals map[xproto.Visualid]render.Pictformat
+	// format is the wire format of argb32 pixmaps.
+	format PixelFormat
+}
+
+func (t *TermWindow) initRender() {
+	if err := render.Init(t.x); err != nil {
+		return
+	}
+	// Transforms and filters were added in 0.6.
+	v, err := render.QueryVersion(t.x, 0, 11).Reply()
+	if err != nil || (v.MajorVersion == 0 && v.MinorVersion < 6) {
+		return
+	}
+	r, err := render.QueryPictFormats(t.x).Reply()
+	if err != nil {
+		return
+	}
+
+	f := &renderFormats{visuals: make(map[xproto.Visualid]render.Pictformat)}
+	for _, info := range r.Formats {
+		d := info.Direct
+		if info.Type == render.PictTypeDirect && info.Depth == 32 &&
+			d.AlphaShift == 24 && d.AlphaMask == 0xff &&
+			d.RedShift == 16 && d.RedMask == 0xff &&
+			d.GreenShift == 8 && d.GreenMask == 0xff &&
+			d.BlueShift == 0 && d.BlueMask == 0xff {
+			f.argb32 = info.Id
+			break
+		}
+	}
+	if f.argb32 == 0 {
+		return
+	}
+	for _, s := range r.Screens {
+		for _, d := range s.Depths {
+			for _, v := range d.Visuals {
+				f.visuals[v.Visual] = v.Format
+			}
+		}
+	}
+
+	f.format, err = NewPixelFormat(xproto.Setup(t.x), 32, xproto.VisualInfo{
+		Class:     xproto.VisualClassTrueColor,
+		RedMask:   0xff0000,
+		GreenMask: 0xff00,
+		BlueMask:  0xff,
+	})
+	if err != nil {
+		return
+	}
+	t.render = f
+}
+
+// UseRender enables or disables scaling and compositing on the X server
+// using the RENDER extension, it is enabled by default. Returns whether
+// RENDER will be used.
+func (t *TermWindow) UseRender(enable bool) bool {
+	t.renderOnce.Do(t.initRender)
+	t.sem.Lock()
+	t.noRender = !enable
+	t.sem.Unlock()
+	for _, w := range t.windowList() {
+		w.sem.Lock()
+		w.change = true
+		w.sem.Unlock()
+	}
+	return enable && t.render != nil
+}
+
+// rasterImage reports whether img has a fixed resolution, vector images
+// are rasterized at the requested size instead.
+func rasterImage(img Image) bool {
+	switch i := img.(type) {
+	case *vectorImage:
+		return false
+	case *lazyImage:
+		return rasterImage(i.image())
+	case *transformImage:
+		return rasterImage(i.src)
+	}
+	return true
+}
+
+// useRender reports whether the image can be scaled and composited by the
+// X server and returns the mipmap level to upload for geom. Filters,
+// backgrounds other than SolidBackground and AlphaMask are only applied to
+// the scaled image on the CPU, as is shrinking by more than a factor of two
+// which RENDER filters alias.
+func (w *SubWindow) useRender(geom Geometry) (*BGRA, bool) {
+	f := w.vis.render
+	if f == nil || len(w.filter) != 0 || !rasterImage(w.view) {
+		return nil, false
+	}
+	if _, ok := w.bg.(solid); w.bg != nil && !ok {
+		return nil, false
+	}
+	if _, ok := w.mask.(maskImage); ok {
+		return nil, false
+	}
+	b := w.view.Bounds()
+	if b.Dx() > maxCoord || b.Dy() > maxCoord {
+		return nil, false
+	}
+	if _, ok := f.visuals[w.vis.visual.VisualId]; !ok {
+		return nil, false
+	}
+
+	sx, sy, _, _ := w.renderTransform(geom)
+	width := int(math.Ceil(float64(b.Dx()) / sx))
+	height := int(math.Ceil(float64(b.Dy()) / sy))
+	level := imageLevel(w.view, width, height)
+	if level.Rect.Dx() > 2*width || level.Rect.Dy() > 2*height {
+		return nil, false
+	}
+	return level, true
+}
+
+// drawRender creates or reconfigures the window for geom, uploading the
+// mipmap level only if it changed.
+func (w *SubWindow) drawRender(geom Geometry, level *BGRA) {
+	f := w.vis.render
+	w.img, w.fg = nil, nil
+	if len(w.tiles) != 0 || w.gc != 0 {
+		// Previously drawn on the CPU.
+		w.freeTiles()
+	}
+	w.drawn = geom
+
+	pos, size := w.clipGeometry(geom)
+	if size.W == 0 || size.H == 0 {
+		w.unmapWindow()
+		return
+	}
+
+	if w.rsrc == 0 || w.stale || level != w.rlevel {
+		w.freeRenderSource()
+		if err := w.uploadRender(f, level); err != nil {
+			w.freeRenderSource()
+			w.err = err
+			return
+		}
+		w.stale = false
+	}
+
+	w.window(pos, size)
+	w.rsize = size
+	if w.rdst == 0 {
+		pic, err := render.NewPictureId(w.t.x)
+		if err != nil {
+			w.err = err
+			return
+		}
+		render.CreatePicture(w.t.x, pic, xproto.Drawable(w.wnd), f.visuals[w.vis.visual.VisualId], 0, nil)
+		w.rdst = pic
+	}
+
+	w.setRenderTransform(geom)
+	w.applyShape()
+}
+
+// uploadRender uploads a mipmap level of the image as a Picture.
+func (w *SubWindow) uploadRender(f *renderFormats, img *BGRA) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+
+	pixmap, err := xproto.NewPixmapId(w.t.x)
+	if err != nil {
+		return err
+	}
+	gc, err := xproto.NewGcontextId(w.t.x)
+	if err != nil {
+		return err
+	}
+	xproto.CreatePixmap(w.t.x, 32, pixmap, xproto.Drawable(w.t.wnd), uint16(b.Dx()), uint16(b.Dy()))
+	// The picture keeps the pixmap alive.
+	defer xproto.FreePixmap(w.t.x, pixmap)
+	xproto.CreateGC(w.t.x, gc, xproto.Drawable(pixmap), 0, nil)
+	defer xproto.FreeGC(w.t.x, gc)
+
+	if err := w.t.putImage(f.format, img, xproto.Drawable(pixmap), gc); err != nil {
+		return err
+	}
+
+	pic, err := render.NewPictureId(w.t.x)
+	if err != nil {
+		return err
+	}
+	render.CreatePicture(w.t.x, pic, xproto.Drawable(pixmap), f.argb32, 0, nil)
+	w.rsrc, w.rlevel = pic, img
+	return nil
+}
+
+// renderTransform returns the scale and translation that map window
+// pixels onto the image as the CPU path would.
+func (w *SubWindow) renderTransform(geom Geometry) (sx, sy, tx, ty float64) {
+	b := w.view.Bounds()
+	sx = float64(b.Dx()) / float64(geom.Image.W)
+	sy = float64(b.Dy()) / float64(geom.Image.H)
+	switch {
+	case w.zoom > 0:
+		sx, sy = 1/w.zoom, 1/w.zoom
+		tx, ty = w.panX, w.panY
+	case !geom.Crop.Empty():
+		c := geom.Crop
+		sx = float64(c.Dx()) / float64(geom.Image.W)
+		sy = float64(c.Dy()) / float64(geom.Image.H)
+		tx, ty = float64(c.Min.X), float64(c.Min.Y)
+	}
+	return
+}
+
+// setRenderTransform maps window pixels onto the uploaded mipmap level.
+func (w *SubWindow) setRenderTransform(geom Geometry) {
+	sx, sy, tx, ty := w.renderTransform(geom)
+	if b := w.view.Bounds(); w.rlevel != nil && !b.Empty() {
+		fx := float64(w.rlevel.Rect.Dx()) / float64(b.Dx())
+		fy := float64(w.rlevel.Rect.Dy()) / float64(b.Dy())
+		sx, sy, tx, ty = sx*fx, sy*fy, tx*fx, ty*fy
+	}
+
+	filter := "good"
+	if sx == 1 && sy == 1 && tx == float64(int(tx)) && ty == float64(int(ty)) {
+		filter = "nearest"
+	}
+	render.SetPictureFilter(w.t.x, w.rsrc, uint16(len(filter)), filter, nil)
+	render.SetPictureTransform(w.t.x, w.rsrc, render.Transform{
+		Matrix11: fixed(sx), Matrix13: fixed(tx),
+		Matrix22: fixed(sy), Matrix23: fixed(ty),
+		Matrix33: fixed(1),
+	})
+}
+
+func fixed(v float64) render.Fixed { return render.Fixed(v * 65536) }
+
+// composite draws rsrc onto the window.
+func (w *SubWindow) composite() {
+	if w.rdst == 0 {
+		return
+	}
+	width, height := uint16(w.rsize.W), uint16(w.rsize.H)
+	op := byte(render.PictOpSrc)
+	if bg, ok := w.bg.(solid); ok {
+		render.FillRectangles(
+			w.t.x,
+			render.PictOpSrc,
+			w.rdst,
+			render.Color{
+				Red:   uint16(bg.R) * 0x101,
+				Green: uint16(bg.G) * 0x101,
+				Blue:  uint16(bg.B) * 0x101,
+				Alpha: 0xffff,
+			},
+			[]xproto.Rectangle{{Width: width, Height: height}},
+		)
+		op = render.PictOpOver
+	}
+	render.Composite(w.t.x, op, w.rsrc, 0, w.rdst, 0, 0, 0, 0, 0, 0, width, height)
+}
+
+func (w *SubWindow) freeRenderTarget() {
+	if w.rdst != 0 {
+		render.FreePicture(w.t.x, w.rdst)
+		w.rdst = 0
+	}
+}
+
+func (w *SubWindow) freeRenderSource() {
+	if w.rsrc != 0 {
+		render.FreePicture(w.t.x, w.rsrc)
+		w.rsrc = 0
+	}
+	w.rlevel = nil
+}
+
+// freeRender frees the uploaded image.
+func (w *SubWindow) freeRender() {
+	w.freeRenderTarget()
+	w.freeRenderSource()
+}
@@ -0,0 +1,391 @@
+package x
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xvfb starts Xvfb with a single screen of the given depth and returns a
+// TermWindow for a top-level window on it. The test is skipped if Xvfb is
+// not installed.
+func xvfb(t testing.TB, depth int, args ...string) *TermWindow {
+	t.Helper()
+	path, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not installed")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	args = append([]string{
+		"-displayfd", "3",
+		"-nolisten", "tcp",
+		"-screen", "0", fmt.Sprintf("640x480x%d", depth),
+	}, args...)
+	cmd := exec.Command(path, args...)
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		t.Fatal(err)
+	}
+	w.Close()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	display := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(r).ReadString('\n')
+		display <- strings.TrimSpace(l)
+	}()
+	var d string
+	select {
+	case d = <-display:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Xvfb did not start")
+	}
+	if d == "" {
+		t.Fatalf("Xvfb -screen 0 640x480x%d failed to start", depth)
+	}
+
+	c, err := xgb.NewConnDisplay(":" + d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen := xproto.Setup(c).DefaultScreen(c)
+	wnd, err := xproto.NewWindowId(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xproto.CreateWindowChecked(
+		c,
+		0, // CopyFromParent
+		wnd,
+		screen.Root,
+		0, 0, 640, 480, 0,
+		xproto.WindowClassInputOutput,
+		0, // CopyFromParent
+		0,
+		nil,
+	).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xproto.MapWindow(c, wnd)
+
+	tw := &TermWindow{
+		x:       c,
+		wnd:     wnd,
+		display: ":" + d,
+		windows: make(map[string]*SubWindow),
+	}
+	t.Cleanup(tw.Close)
+	return tw
+}
+
+// quadrants returns an image with red, green, blue and white quadrants.
+func quadrants(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	colors := [4]color.NRGBA{
+		{255, 0, 0, 255},
+		{0, 255, 0, 255},
+		{0, 0, 255, 255},
+		{255, 255, 255, 255},
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetNRGBA(x, y, colors[2*(2*y/size)+2*x/size])
+		}
+	}
+	return img
+}
+
+// checkQuadrants reads back the window of w, which shows quadrants, and
+// checks the color of each quadrant in the PixelFormat of t.
+func checkQuadrants(t *testing.T, tw *TermWindow, w *SubWindow, size int) {
+	t.Helper()
+	p, err := tw.PixelFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.sem.Lock()
+	wnd := w.wnd
+	w.sem.Unlock()
+	if wnd == 0 {
+		t.Fatal("no window created")
+	}
+
+	img, err := xproto.GetImage(
+		tw.x,
+		xproto.ImageFormatZPixmap,
+		xproto.Drawable(wnd),
+		0, 0, uint16(size), uint16(size),
+		0xffffffff,
+	).Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Depth != p.Depth {
+		t.Fatalf("window depth %d, expected %d", img.Depth, p.Depth)
+	}
+
+	stride, bpp := p.Stride(size), int(p.BitsPerPixel)/8
+	pixel := func(b []byte) uint32 {
+		var v uint32
+		for i := 0; i < bpp; i++ {
+			if p.BigEndian {
+				v = v<<8 | uint32(b[i])
+			} else {
+				v |= uint32(b[i]) << (8 * i)
+			}
+		}
+		return v & (p.Red | p.Green | p.Blue)
+	}
+	// The quadrants are saturated, so each channel is either 0 or its full
+	// mask regardless of its amount of bits.
+	for _, c := range []struct {
+		pt image.Point
+		e  uint32
+	}{
+		{image.Pt(size/4, size/4), p.Red},
+		{image.Pt(3*size/4, size/4), p.Green},
+		{image.Pt(size/4, 3*size/4), p.Blue},
+		{image.Pt(3*size/4, 3*size/4), p.Red | p.Green | p.Blue},
+	} {
+		pt, e := c.pt, c.e
+		g := pixel(img.Data[pt.Y*stride+pt.X*bpp:])
+		if g != e {
+			t.Errorf("pixel %v: got %#x, expected %#x", pt, g, e)
+		}
+	}
+}
+
+// drawQuadrants shows a quadrants image in a subwindow.
+func drawQuadrants(t *testing.T, tw *TermWindow, size int) *SubWindow {
+	t.Helper()
+	w := tw.SubWindow("quadrants")
+	w.SetImage(NewImage(quadrants(size)))
+	w.SetGeometry(image.Rect(8, 8, 8+size, 8+size))
+	w.Show()
+	if err := w.Render(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestXvfbVisual(t *testing.T) {
+	for _, c := range []struct {
+		depth int
+		bpp   byte
+	}{
+		{16, 16},
+		{24, 32},
+		{30, 32},
+	} {
+		for _, render := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d/render=%t", c.depth, render), func(t *testing.T) {
+				tw := xvfb(t, c.depth)
+				tw.UseRender(render)
+
+				screen := xproto.Setup(tw.x).DefaultScreen(tw.x)
+				depth, visual, ok := FindVisual(screen, screen.RootVisual)
+				if !ok || int(depth.Depth) != c.depth {
+					t.Fatalf("FindVisual root visual: depth %d, %t", depth.Depth, ok)
+				}
+
+				id, d := tw.Visual()
+				if id != visual.VisualId || int(d) != c.depth {
+					t.Fatalf("visual %#x depth %d, expected %#x depth %d", id, d, visual.VisualId, c.depth)
+				}
+				p, err := tw.PixelFormat()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.BitsPerPixel != c.bpp {
+					t.Fatalf("%d bits per pixel, expected %d", p.BitsPerPixel, c.bpp)
+				}
+
+				w := drawQuadrants(t, tw, 64)
+				checkQuadrants(t, tw, w, 64)
+			})
+		}
+	}
+}
+
+// argbParent replaces the terminal window of tw by a window using a 32-bit
+// TrueColor visual, whose children need an explicit colormap.
+func argbParent(t *testing.T, tw *TermWindow) xproto.Visualid {
+	t.Helper()
+	screen := xproto.Setup(tw.x).DefaultScreen(tw.x)
+	depth, visual, ok := findTrueColor(screen, 32)
+	if !ok {
+		t.Skip("no 32-bit TrueColor visual")
+	}
+	cmap, ok := tw.createColormap(screen, visual.VisualId)
+	if !ok {
+		t.Fatal("could not create colormap")
+	}
+	t.Cleanup(func() { xproto.FreeColormap(tw.x, cmap) })
+
+	wnd, err := xproto.NewWindowId(tw.x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xproto.CreateWindowChecked(
+		tw.x,
+		depth.Depth,
+		wnd,
+		screen.Root,
+		0, 0, 640, 480, 0,
+		xproto.WindowClassInputOutput,
+		visual.VisualId,
+		xproto.CwBorderPixel|xproto.CwColormap,
+		[]uint32{0, uint32(cmap)},
+	).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xproto.MapWindow(tw.x, wnd)
+	tw.wnd = wnd
+	return visual.VisualId
+}
+
+func TestXvfbVisualARGBParent(t *testing.T) {
+	for _, render := range []bool{false, true} {
+		t.Run(fmt.Sprintf("render=%t", render), func(t *testing.T) {
+			tw := xvfb(t, 24)
+			exp := argbParent(t, tw)
+			tw.UseRender(render)
+
+			if id, d := tw.Visual(); id != exp || d != 32 {
+				t.Fatalf("visual %#x depth %d, expected the visual of the parent %#x", id, d, exp)
+			}
+			w := drawQuadrants(t, tw, 64)
+			checkQuadrants(t, tw, w, 64)
+		})
+	}
+}
+
+func TestXvfbARGB(t *testing.T) {
+	for _, render := range []bool{false, true} {
+		t.Run(fmt.Sprintf("render=%t", render), func(t *testing.T) {
+			tw := xvfb(t, 24)
+			tw.UseRender(render)
+			if !tw.UseARGB() {
+				t.Skip("no 32-bit TrueColor visual")
+			}
+			if _, d := tw.Visual(); d != 32 {
+				t.Fatalf("depth %d after UseARGB", d)
+			}
+
+			const size = 32
+			img := image.NewNRGBA(image.Rect(0, 0, size, size))
+			for i := 0; i < len(img.Pix); i += 4 {
+				copy(img.Pix[i:], []byte{255, 0, 0, 128})
+			}
+			w := tw.SubWindow("argb")
+			w.SetImage(NewImage(img))
+			w.SetGeometry(image.Rect(0, 0, size, size))
+			w.Show()
+			if err := w.Render(); err != nil {
+				t.Fatal(err)
+			}
+			p, err := tw.PixelFormat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w.sem.Lock()
+			wnd := w.wnd
+			w.sem.Unlock()
+			r, err := xproto.GetImage(
+				tw.x,
+				xproto.ImageFormatZPixmap,
+				xproto.Drawable(wnd),
+				size/2, size/2, 1, 1,
+				0xffffffff,
+			).Reply()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Depth != 32 {
+				t.Fatalf("window depth %d", r.Depth)
+			}
+			// Half transparent red, premultiplied, in 8-bit channels.
+			exp := (p.Red | p.Alpha) & 0x80808080
+			var got uint32
+			for i := 0; i < 4; i++ {
+				if p.BigEndian {
+					got = got<<8 | uint32(r.Data[i])
+				} else {
+					got |= uint32(r.Data[i]) << (8 * i)
+				}
+			}
+			if got != exp {
+				t.Errorf("got pixel %#x, expected premultiplied %#x", got, exp)
+			}
+		})
+	}
+}
+
+// BenchmarkXvfbPutImage compares uploads using MIT-SHM with PutImage
+// requests.
+func BenchmarkXvfbPutImage(b *testing.B) {
+	for _, size := range []int{256, 1024, 2048} {
+		for _, useSHM := range []bool{true, false} {
+			b.Run(fmt.Sprintf("%d/shm=%t", size, useSHM), func(b *testing.B) {
+				tw := xvfb(b, 24)
+				tw.shmMu.Lock()
+				if !useSHM {
+					tw.shmState = -1
+				} else if !tw.initSHM() {
+					tw.shmMu.Unlock()
+					b.Skip("MIT-SHM not available")
+				}
+				tw.shmMu.Unlock()
+
+				p, err := tw.PixelFormat()
+				if err != nil {
+					b.Fatal(err)
+				}
+				pixmap, err := xproto.NewPixmapId(tw.x)
+				if err != nil {
+					b.Fatal(err)
+				}
+				gc, err := xproto.NewGcontextId(tw.x)
+				if err != nil {
+					b.Fatal(err)
+				}
+				xproto.CreatePixmap(tw.x, p.Depth, pixmap, xproto.Drawable(tw.wnd), uint16(size), uint16(size))
+				xproto.CreateGC(tw.x, gc, xproto.Drawable(pixmap), 0, nil)
+				img := ImageToBGRA(quadrants(size))
+
+				b.SetBytes(int64(4 * size * size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := tw.putImage(p, img, xproto.Drawable(pixmap), gc); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				if useSHM && tw.shm == nil {
+					b.Fatal("MIT-SHM was not used")
+				}
+			})
+		}
+	}
+}
@@ -2,10 +2,14 @@ package zug
 
 import (
 	"errors"
+	"fmt"
+	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +17,15 @@ import (
 	_ "golang.org/x/image/bmp"
 	_ "golang.org/x/image/webp"
 
+	"github.com/frizinak/zug/format"
+	_ "github.com/frizinak/zug/format/farbfeld"
+	"github.com/frizinak/zug/format/icc"
+	_ "github.com/frizinak/zug/format/ico"
+	_ "github.com/frizinak/zug/format/jpeg"
+	_ "github.com/frizinak/zug/format/pnm"
+	_ "github.com/frizinak/zug/format/qoi"
+	_ "github.com/frizinak/zug/format/svg"
+	_ "github.com/frizinak/zug/format/tiff"
 	"github.com/frizinak/zug/img"
 	"github.com/frizinak/zug/x"
 )
@@ -23,7 +36,13 @@ type Zug struct {
 	term *x.TermWindow
 
 	layers map[string]*Layer
+	seq    int
 	draw   bool
+	limits format.Limits
+	size   image.Point
+
+	profile   *icc.Profile
+	ignoreICC bool
 }
 
 func New(m *img.Manager, term *x.TermWindow) *Zug {
@@ -59,6 +78,58 @@ func (z *Zug) Close() error {
 	return errors.New(strings.Join(gerr, "\n"))
 }
 
+// SetLimits sets the limits applied when layers decode their source.
+// Use this to guard against decompression bombs from untrusted sources.
+func (z *Zug) SetLimits(l format.Limits) {
+	z.sem.Lock()
+	z.limits = l
+	z.sem.Unlock()
+}
+
+// Limits returns the limits set by SetLimits.
+func (z *Zug) Limits() format.Limits {
+	z.sem.RLock()
+	defer z.sem.RUnlock()
+	return z.limits
+}
+
+// SetDecodeSize hints the largest size images will be displayed at, e.g.:
+// the size of TermWindow.Geometry. Large images in formats that support
+// it are decoded at a reduced size that is still at least this large.
+// A zero size decodes images at full resolution.
+func (z *Zug) SetDecodeSize(size image.Point) {
+	z.sem.Lock()
+	z.size = size
+	z.sem.Unlock()
+}
+
+// SetColorProfile sets the ICC profile of the display, images are
+// converted from their embedded profile to it. nil means sRGB.
+func (z *Zug) SetColorProfile(p *icc.Profile) {
+	z.sem.Lock()
+	z.profile = p
+	z.sem.Unlock()
+}
+
+// SetColorManagement enables or disables converting images with an
+// embedded ICC profile, it is enabled by default.
+func (z *Zug) SetColorManagement(enabled bool) {
+	z.sem.Lock()
+	z.ignoreICC = !enabled
+	z.sem.Unlock()
+}
+
+func (z *Zug) decoder() *x.Decoder {
+	z.sem.RLock()
+	defer z.sem.RUnlock()
+	return &x.Decoder{
+		Limits:    z.limits,
+		Size:      z.size,
+		Profile:   z.profile,
+		IgnoreICC: z.ignoreICC,
+	}
+}
+
 func (z *Zug) Layers() []string {
 	z.sem.RLock()
 	n := make([]string, 0, len(z.layers))
@@ -89,13 +160,97 @@ func (z *Zug) Layer(name string) *Layer {
 
 	wnd := z.term.SubWindow(name)
 
-	l := &Layer{SubWindow: wnd, m: z.m}
+	l := &Layer{SubWindow: wnd, z: z, m: z.m, name: name, seq: z.seq}
+	z.seq++
 	z.layers[name] = l
 	z.draw = true
+	z.restack()
 
 	return l
 }
 
+// stack returns all layers from bottom to top.
+func (z *Zug) stack() []*Layer {
+	ls := make([]*Layer, 0, len(z.layers))
+	for _, l := range z.layers {
+		ls = append(ls, l)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		a, b := ls[i], ls[j]
+		if a.zindex != b.zindex {
+			return a.zindex < b.zindex
+		}
+		return a.seq < b.seq
+	})
+	return ls
+}
+
+// restack orders the subwindows of all layers by their z-index.
+func (z *Zug) restack() {
+	ls := z.stack()
+	names := make([]string, len(ls))
+	for i, l := range ls {
+		names[i] = l.name
+	}
+	_ = z.term.Restack(names...)
+}
+
+// move places l at index i of the stack (-1 being the top) by taking over
+// the z-index of its new neighbour and renumbering the creation order.
+func (z *Zug) move(l *Layer, i int) {
+	ls := z.stack()
+	for j := range ls {
+		if ls[j] == l {
+			ls = append(ls[:j], ls[j+1:]...)
+			break
+		}
+	}
+	if i < 0 || i > len(ls) {
+		i = len(ls)
+	}
+	switch {
+	case i > 0:
+		l.zindex = ls[i-1].zindex
+	case len(ls) != 0:
+		l.zindex = ls[0].zindex
+	}
+
+	ls = append(ls, nil)
+	copy(ls[i+1:], ls[i:])
+	ls[i] = l
+	for j, o := range ls {
+		o.seq = j
+	}
+	z.seq = len(ls)
+	z.restack()
+}
+
+// moveRel places l directly above or below the layer named sibling.
+func (z *Zug) moveRel(l *Layer, sibling string, above bool) error {
+	s, ok := z.layers[sibling]
+	if !ok {
+		return fmt.Errorf("no such layer: '%s'", sibling)
+	}
+	if s == l {
+		return nil
+	}
+	ls := z.stack()
+	i := 0
+	for _, o := range ls {
+		if o == s {
+			break
+		}
+		if o != l {
+			i++
+		}
+	}
+	if above {
+		i++
+	}
+	z.move(l, i)
+	return nil
+}
+
 func (z *Zug) RenderWithRefresh() error {
 	z.sem.RLock()
 	for _, l := range z.layers {
@@ -110,8 +265,13 @@ func (z *Zug) Render() error { return z.term.Render(false) }
 
 type Layer struct {
 	*x.SubWindow
+	z *Zug
 	m *img.Manager
 
+	name   string
+	zindex int
+	seq    int
+
 	lastLoad time.Time
 
 	state struct {
@@ -125,6 +285,58 @@ func (l *Layer) SetImage(*x.BGRA) error {
 	return errors.New("don't use this directly")
 }
 
+// ZIndex returns the stacking order set by SetZIndex.
+func (l *Layer) ZIndex() int {
+	l.z.sem.RLock()
+	defer l.z.sem.RUnlock()
+	return l.zindex
+}
+
+// SetZIndex sets the stacking order of the layer, layers with a higher
+// index are shown above those with a lower one. Layers with the same index
+// are stacked in the order they were created.
+func (l *Layer) SetZIndex(n int) {
+	l.z.sem.Lock()
+	defer l.z.sem.Unlock()
+	l.zindex = n
+	l.z.restack()
+}
+
+// Raise moves the layer above all other layers, taking over the z-index of
+// the topmost one.
+func (l *Layer) Raise() {
+	l.z.sem.Lock()
+	l.z.move(l, -1)
+	l.z.sem.Unlock()
+}
+
+// ToTop is Raise.
+func (l *Layer) ToTop() { l.Raise() }
+
+// Lower moves the layer below all other layers, taking over the z-index of
+// the bottommost one.
+func (l *Layer) Lower() {
+	l.z.sem.Lock()
+	l.z.move(l, 0)
+	l.z.sem.Unlock()
+}
+
+// Above moves the layer directly above the layer named sibling, taking
+// over its z-index.
+func (l *Layer) Above(sibling string) error {
+	l.z.sem.Lock()
+	defer l.z.sem.Unlock()
+	return l.z.moveRel(l, sibling, true)
+}
+
+// Below moves the layer directly below the layer named sibling, taking
+// over its z-index.
+func (l *Layer) Below(sibling string) error {
+	l.z.sem.Lock()
+	defer l.z.sem.Unlock()
+	return l.z.moveRel(l, sibling, false)
+}
+
 // Close or SubWindow.Close should not be used.
 func (l *Layer) Close() {
 	panic("don't close a layer, use zug.DelLayer")
@@ -133,6 +345,10 @@ func (l *Layer) Close() {
 // SetSource loads an image from the given URI.
 // this might be cached by the file img.Manager.
 // Use reload to refresh a local file.
+// The image is decoded within the limits set by Zug.SetLimits.
+// Only the image header is read before returning so geometry can be
+// calculated immediately, pixels are decoded in the background.
+// Use Wait to block until they are and to retrieve decoding errors.
 func (l *Layer) SetSource(uri string) error {
 	path, err := l.m.Do(uri)
 	if err != nil {
@@ -170,32 +386,33 @@ func (l *Layer) Refresh() error {
 		if err := l.load(l.state.path); err != nil {
 			return err
 		}
-		l.Render()
+		if err := l.Render(); err != nil {
+			return err
+		}
 	}
 
 	l.lastLoad = time.Now()
 	return nil
 }
 
+// load probes the image at path and decodes it in the background, see
+// x.Decoder.ReadAsync. Decoding errors are returned by Wait.
 func (l *Layer) load(path string) error {
 	l.lastLoad = time.Now()
-	f, err := os.Open(path)
+	stat, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	img, err := x.ImageRead(f)
+	dec := l.z.decoder()
+	img, err := dec.ReadAsync(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
 	if err != nil {
 		return err
 	}
 
-	s, _ := f.Stat()
-	l.state.mtime = time.Now()
-	if s != nil {
-		l.state.mtime = s.ModTime()
-	}
-
+	l.state.mtime = stat.ModTime()
 	l.SubWindow.SetImage(img)
 	return nil
 }